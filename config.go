@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Config is the configuration of the CLI, kept in
+// ~/.eksphemeral/config.json unless EKSPHEMERAL_CONFIG says otherwise
+type Config struct {
+	// APIKey is the key to authenticate with against the control plane
+	APIKey string `json:"apikey,omitempty"`
+	// Issuer is the URL of the OIDC provider to log in with
+	Issuer string `json:"issuer,omitempty"`
+	// ClientID identifies the CLI with the OIDC provider
+	ClientID string `json:"clientid,omitempty"`
+	// Token is the ID token to authenticate with against the
+	// control plane, as handed out by the OIDC provider at login
+	Token string `json:"token,omitempty"`
+}
+
+// configPath returns where the config of the CLI is kept
+func configPath() string {
+	if path, ok := os.LookupEnv("EKSPHEMERAL_CONFIG"); ok {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(home, ".eksphemeral", "config.json")
+}
+
+// loadConfig returns the config of the CLI, which is
+// empty if there's no config file (yet)
+func loadConfig() (Config, error) {
+	cfg := Config{}
+	raw, err := ioutil.ReadFile(configPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return cfg, err
+	}
+	err = json.Unmarshal(raw, &cfg)
+	return cfg, err
+}
+
+// storeConfig writes the config of the CLI, readable by the
+// current user only since it holds credentials
+func storeConfig(cfg Config) error {
+	path := configPath()
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(cfg, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, raw, 0600)
+}
@@ -8,16 +8,8 @@ set -o pipefail
 ################################################################################
 # BASE install
 
-echo "Using the follwing input: cluster $CLUSTER_NAME v$KUBERNETES_VERSION with $NUM_WORKERS workers"
-
-# first, provision EKS control and data plane using eksctl:
-eksctl create cluster \
-    --name $CLUSTER_NAME \
-    --version $KUBERNETES_VERSION \
-    --nodes $NUM_WORKERS \
-    --auto-kubeconfig \
-    --full-ecr-access \
-    --appmesh-access
+# first, provision EKS control and data plane the same way the base image does:
+./provision.sh
 
 export KUBECONFIG=/home/eksctl/.kube/eksctl/clusters/$CLUSTER_NAME
 
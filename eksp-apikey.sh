@@ -0,0 +1,77 @@
+#!/usr/bin/env bash
+
+set -o errexit
+set -o errtrace
+set -o nounset
+set -o pipefail
+
+###############################################################################
+### PRE-FLIGHT CHECKS
+
+if ! [ -x "$(command -v jq)" ]
+then
+  echo "Pre-flight check failed: jq is not installed. Yo, please install it from https://stedolan.github.io/jq/download/ and try again, cool?" >&2
+  exit 1
+fi
+
+if ! aws cloudformation describe-stacks --stack-name eksp > /dev/null 2>&1
+then
+  echo "Pre-flight check failed: the control plane seems not to be up, are you sure you executed eksp-up.sh already?" >&2
+  exit 1
+fi
+
+APIKEY_CMD=${1:-list}
+
+###############################################################################
+### MANAGE API KEYS
+
+EKSPHEMERAL_URL=$(aws cloudformation describe-stacks --stack-name eksp | jq '.Stacks[].Outputs[] | select(.OutputKey=="EKSphemeralAPIEndpoint").OutputValue' -r)
+
+case $APIKEY_CMD in
+  issue)
+    printf "\nIssuing an API key to %s\n" $(echo "${2}" | jq .user -r) >&2
+    ;;
+  revoke)
+    printf "\nTrying to revoke API key %s\n" ${2} >&2
+    ;;
+esac
+
+# admins manage API keys via the API, authenticated like any other caller:
+if [ -n "${EKSPHEMERAL_API_KEY:-}" ] || [ -n "${EKSPHEMERAL_TOKEN:-}" ]
+then
+  case $APIKEY_CMD in
+    issue)
+      curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" --header "Content-Type: application/json" --request POST --data "${2}" "$EKSPHEMERAL_URL/apikeys"
+      ;;
+    revoke)
+      curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" --request DELETE "$EKSPHEMERAL_URL/apikeys/${2}"
+      ;;
+    *)
+      curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" "$EKSPHEMERAL_URL/apikeys"
+      ;;
+  esac
+  exit 0
+fi
+
+# without an API key, such as for issuing the first one, admins invoke the
+# function directly with their AWS credentials, which is all it goes by:
+APIKEYS_FUNCTION=$(aws cloudformation describe-stacks --stack-name eksp | jq '.Stacks[].Outputs[] | select(.OutputKey=="APIKeysFunction").OutputValue' -r)
+
+case $APIKEY_CMD in
+  issue)
+    REQUEST=$(jq -n --arg body "${2}" '{eksphemeralInternal: "eksp-apikey.sh", httpMethod: "POST", body: $body}')
+    ;;
+  revoke)
+    REQUEST=$(jq -n --arg keyid "${2}" '{eksphemeralInternal: "eksp-apikey.sh", httpMethod: "DELETE", pathParameters: {keyid: $keyid}}')
+    ;;
+  *)
+    REQUEST=$(jq -n '{eksphemeralInternal: "eksp-apikey.sh", httpMethod: "GET"}')
+    ;;
+esac
+
+PAYLOAD=$(mktemp)
+RESPONSE=$(mktemp)
+echo "$REQUEST" > $PAYLOAD
+aws lambda invoke --function-name $APIKEYS_FUNCTION --payload fileb://$PAYLOAD $RESPONSE > /dev/null
+jq .body -r $RESPONSE
+rm $PAYLOAD $RESPONSE
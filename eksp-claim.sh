@@ -0,0 +1,44 @@
+#!/usr/bin/env bash
+
+set -o errexit
+set -o errtrace
+set -o nounset
+set -o pipefail
+
+###############################################################################
+### PRE-FLIGHT CHECKS
+
+if ! [ -x "$(command -v jq)" ]
+then
+  echo "Pre-flight check failed: jq is not installed. Yo, please install it from https://stedolan.github.io/jq/download/ and try again, cool?" >&2
+  exit 1
+fi
+
+if ! aws cloudformation describe-stacks --stack-name eksp > /dev/null 2>&1
+then
+  echo "Pre-flight check failed: the control plane seems not to be up, are you sure you executed eksp-up.sh already?" >&2
+  exit 1
+fi
+
+POOL=${1}
+CLAIM=${2:-"{}"}
+
+###############################################################################
+### CLAIM A CLUSTER FROM A WARM POOL
+
+EKSPHEMERAL_URL=$(aws cloudformation describe-stacks --stack-name eksp | jq '.Stacks[].Outputs[] | select(.OutputKey=="EKSphemeralAPIEndpoint").OutputValue' -r)
+
+printf "\nTrying to claim a cluster from warm pool %s\n" $POOL >&2
+
+CLAIM_RESULT=$(curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" --header "Content-Type: application/json" --request POST --data "$CLAIM" "$EKSPHEMERAL_URL/claim/$POOL")
+CLUSTER_NAME=$(echo "$CLAIM_RESULT" | jq '.data.name // empty' -r)
+
+if [ -n "$CLUSTER_NAME" ]
+then
+  # the kubectl context is named after the name the cluster was claimed under:
+  CLUSTER_ALIAS=$(echo "$CLAIM_RESULT" | jq '.data.alias // .data.name' -r)
+  ACCESS_ROLE=$(aws cloudformation describe-stacks --stack-name eksp | jq '.Stacks[].Outputs[] | select(.OutputKey=="ClusterAccessRoleArn").OutputValue' -r)
+  aws eks update-kubeconfig --name $CLUSTER_NAME --role-arn $ACCESS_ROLE --alias $CLUSTER_ALIAS >&2
+fi
+
+echo "$CLAIM_RESULT"
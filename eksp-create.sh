@@ -5,17 +5,6 @@ set -o errtrace
 # set -o nounset
 set -o pipefail
 
-###############################################################################
-### USER-DEFINED GLOBAL CONSTANTS
-
-DEFAULT_K8S_VERSION=1.12
-EKSCTL_IMAGE=base
-
-if [[ ! -z "$EKSPHEMERAL_EKSCTL_IMG" ]]
-then
-  EKSCTL_IMAGE=$EKSPHEMERAL_EKSCTL_IMG
-fi
-
 ###############################################################################
 ### DEPENDENCIES CHECKS
 
@@ -60,13 +49,8 @@ fi
 ###############################################################################
 ### EVALUATE COMMAND LINE PARAMETERS
 
-# Look up default VPC and secuirity group:
-default_vpc=$(aws ec2 describe-vpcs --filters "Name=isDefault, Values=true" | jq .Vpcs[0].VpcId -r)
-default_sg=$(aws ec2 describe-security-groups  | jq  --arg default_vpc "$default_vpc" '.SecurityGroups[] | select (.VpcId == $default_vpc) | .GroupId' -r)
-
 # Get user provided parameters, if any:
 CLUSTER_SPEC=${1:-svc/default-cc.json}
-EKSPHEMERAL_SG=${2:-$default_sg}
 
 # If no name is provided in the cluster spec,
 # generate a unique one as a fallback, otherwise
@@ -78,26 +62,6 @@ else
   CLUSTER_NAME=$(cat $CLUSTER_SPEC | jq .name -r)
 fi
 
-# If the number of worker nodes is not provided 
-# in the cluster spec, set default, otherwise
-# use the one from the JSON doc:
-if ! cat $CLUSTER_SPEC | jq .numworkers -r > /dev/null 2>&1
-then
-  NUM_WORKERS=1
-else
-  NUM_WORKERS=$(cat $CLUSTER_SPEC | jq .numworkers -r)
-fi
-
-# If the Kubernetes version is not provided 
-# in the cluster spec, set default, otherwise
-# use the one from the JSON doc:
-if ! cat $CLUSTER_SPEC | jq .kubeversion -r > /dev/null 2>&1
-then
-  K8S_VERSION=$DEFAULT_K8S_VERSION
-else
-  K8S_VERSION=$(cat $CLUSTER_SPEC | jq .kubeversion -r)
-fi
-
 ###############################################################################
 ### PRE-FLIGHT CHECKS
 
@@ -109,7 +73,7 @@ fi
 
 # Check dependency, that is, if control plane is available:
 EKSPHEMERAL_URL=$(aws cloudformation describe-stacks --stack-name eksp | jq '.Stacks[].Outputs[] | select(.OutputKey=="EKSphemeralAPIEndpoint").OutputValue' -r)
-CONTROLPLANE_STATUS=$(curl -sL -w "%{http_code}" -o /dev/null "$EKSPHEMERAL_URL/status/*")
+CONTROLPLANE_STATUS=$(curl -sL --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" -w "%{http_code}" -o /dev/null "$EKSPHEMERAL_URL/status/*")
 
 if [ $CONTROLPLANE_STATUS != "200" ]
 then
@@ -117,52 +81,69 @@ then
   exit 1
 fi
 
+# Check if the cluster name is still available, since names must be unique
+# amongst active clusters:
+if [ "$(curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" "$EKSPHEMERAL_URL/status/$CLUSTER_NAME" | jq .data.name -r 2>/dev/null)" == "$CLUSTER_NAME" ]
+then
+  echo "Pre-flight check failed: there's already an active cluster named $CLUSTER_NAME, please pick another name." >&2
+  exit 1
+fi
+
 ###############################################################################
-### DATA PLANE OPERATION
+### CONTROL PLANE (METADATA) OPERATIONS
 
-printf "I will now provision the EKS cluster %s using AWS Fargate:\n\n" $CLUSTER_NAME
+# create a cluster (metadata) entry in S3 via Lambda (our control plane),
+# which in turn provisions the EKS cluster (our data plane) using eksctl in
+# AWS Fargate; the idempotency key makes sure that retries don't create
+# duplicate clusters:
+IDEMPOTENCY_KEY=$(uuidgen | tr '[:upper:]' '[:lower:]')
+CREATE_RESULT=$(curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" --retry 3 --header "Content-Type: application/json" --header "Idempotency-Key: $IDEMPOTENCY_KEY" --request POST --data @$CLUSTER_SPEC $EKSPHEMERAL_URL/create/)
+CLUSTERID=$(echo "$CREATE_RESULT" | jq .data.id -r)
 
-# provision the EKS cluster using containerized eksctl:
-fargate task run eksctl \
-          --image quay.io/mhausenblas/eksctl:$EKSCTL_IMAGE \
-          --region "$(aws configure get region)" \
-          --env AWS_ACCESS_KEY_ID="$(aws configure get aws_access_key_id)" \
-          --env AWS_SECRET_ACCESS_KEY="$(aws configure get aws_secret_access_key)" \
-          --env AWS_DEFAULT_REGION="$(aws configure get region)" \
-          --env CLUSTER_NAME="$CLUSTER_NAME" \
-          --env NUM_WORKERS="$NUM_WORKERS" \
-          --env KUBERNETES_VERSION="$K8S_VERSION" \
-          --security-group-id "$EKSPHEMERAL_SG"
+if [ -z "$CLUSTERID" ] || [ "$CLUSTERID" == "null" ]
+then
+  echo "Failed to create control plane entry for cluster $CLUSTER_NAME: $(echo "$CREATE_RESULT" | jq .error -c)" >&2
+  exit 1
+fi
 
-printf "Waiting for EKS cluster provisioning to complete. Allow some 15 min to complete, checking status every minute:\n"
+printf "\nSuccessfully created control plane entry for cluster %s (ID %s), the control plane is now provisioning it using AWS Fargate.\n" $CLUSTER_NAME $CLUSTERID
 
-# this is necessary to make sure the EKS control plane is up and we can query the cluster status:
-sleep 120
+printf "Waiting for EKS cluster provisioning to complete. Allow some 15 min to complete, checking status every minute.\n"
+printf "To follow the progress, use: eksp logs -f %s\n" $CLUSTERID
 
-while [ "$(aws eks describe-cluster --name $CLUSTER_NAME | jq .cluster.status -r)" != "ACTIVE" ]
+while true
 do
+    STATUS_RESULT=$(curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" "$EKSPHEMERAL_URL/status/$CLUSTERID")
+    if [ "$(echo "$STATUS_RESULT" | jq .data.details.status -r)" == "ACTIVE" ]
+    then
+      break
+    fi
+    PROVISIONING_ERROR=$(echo "$STATUS_RESULT" | jq '.data.provisioningerror // empty' -r)
+    if [ -n "$PROVISIONING_ERROR" ]
+    then
+      printf "\n"
+      echo "Failed to provision cluster $CLUSTER_NAME: $PROVISIONING_ERROR, check the logs with: eksp logs $CLUSTERID" >&2
+      exit 1
+    fi
+    if [ "$(echo "$STATUS_RESULT" | jq '.error // empty' -c)" != "" ]
+    then
+      printf "\n"
+      echo "Failed to provision cluster $CLUSTER_NAME: $(echo "$STATUS_RESULT" | jq .error.message -r)" >&2
+      exit 1
+    fi
     printf "."
-    sleep 60 
+    sleep 60
 done
 
-printf "\nSuccessfully created data plane for cluster %s using AWS Fargate and now moving on to the control plane in AWS Lambda and S3 ...\n" $CLUSTER_NAME
-
-# note, one could use https://docs.aws.amazon.com/cli/latest/reference/cloudformation/wait/stack-exists.html as well here, maybe?
-
-###############################################################################
-### CONTROL PLANE (METADATA) OPERATIONS
-
-# now that the EKS cluster (our data plane) is up and running,
-# let's create a cluster (metadata) entry in S3 via Lambda (our control plane):
-CLUSTERID=$(curl -s --header "Content-Type: application/json" --request POST --data @$CLUSTER_SPEC $EKSPHEMERAL_URL/create/)
-
-printf "\nSuccessfully created control plane entry for cluster %s via AWS Lambda and Amazon S3 ...\n" $CLUSTER_NAME
+printf "\nSuccessfully created data plane for cluster %s using AWS Fargate\n" $CLUSTER_NAME
 
 ###############################################################################
 ### CONFIG AND SMOKE TEST
 
 printf "\nNow moving on to configure kubectl to point to your EKS cluster:\n"
-aws eks update-kubeconfig --name $CLUSTER_NAME
+# the control plane grants access to its clusters to a dedicated role:
+ACCESS_ROLE=$(aws cloudformation describe-stacks --stack-name eksp | jq '.Stacks[].Outputs[] | select(.OutputKey=="ClusterAccessRoleArn").OutputValue' -r)
+aws eks update-kubeconfig --name $CLUSTER_NAME --role-arn $ACCESS_ROLE
 
 printf "\nYour EKS cluster is now set up and configured:\n"
 kubectl config get-contexts
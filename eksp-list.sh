@@ -19,7 +19,8 @@ then
   exit 1
 fi
 
-CLUSTER_ID=${1}
+CLUSTER_ID=${1:-}
+LIST_QUERY=${2:-}
 
 
 ###############################################################################
@@ -29,8 +30,8 @@ EKSPHEMERAL_URL=$(aws cloudformation describe-stacks --stack-name eksp | jq '.St
 
 if [ ! -z "$CLUSTER_ID" ]
 then
-  curl -s "$EKSPHEMERAL_URL/status/$CLUSTER_ID"
+  curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" "$EKSPHEMERAL_URL/status/$CLUSTER_ID"
 else
-  curl -s "$EKSPHEMERAL_URL/status/*"
+  curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" "$EKSPHEMERAL_URL/status/*?$LIST_QUERY"
 fi 
 
@@ -0,0 +1,43 @@
+#!/usr/bin/env bash
+
+set -o errexit
+set -o errtrace
+set -o nounset
+set -o pipefail
+
+###############################################################################
+### PRE-FLIGHT CHECKS
+
+if ! [ -x "$(command -v jq)" ]
+then
+  echo "Pre-flight check failed: jq is not installed. Yo, please install it from https://stedolan.github.io/jq/download/ and try again, cool?" >&2
+  exit 1
+fi
+
+if ! aws cloudformation describe-stacks --stack-name eksp > /dev/null 2>&1
+then
+  echo "Pre-flight check failed: the control plane seems not to be up, are you sure you executed eksp-up.sh already?" >&2
+  exit 1
+fi
+
+SCHEDULE_CMD=${1:-list}
+
+###############################################################################
+### MANAGE CLUSTER CREATION SCHEDULES
+
+EKSPHEMERAL_URL=$(aws cloudformation describe-stacks --stack-name eksp | jq '.Stacks[].Outputs[] | select(.OutputKey=="EKSphemeralAPIEndpoint").OutputValue' -r)
+
+case $SCHEDULE_CMD in
+  add)
+    SCHEDULE=${2}
+    curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" --header "Content-Type: application/json" --request POST --data "$SCHEDULE" "$EKSPHEMERAL_URL/schedules"
+    ;;
+  rm)
+    SCHEDULE_ID=${2}
+    printf "\nTrying to remove schedule %s\n" $SCHEDULE_ID >&2
+    curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" --request DELETE "$EKSPHEMERAL_URL/schedules/$SCHEDULE_ID"
+    ;;
+  *)
+    curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" "$EKSPHEMERAL_URL/schedules"
+    ;;
+esac
@@ -0,0 +1,49 @@
+#!/usr/bin/env bash
+
+set -o errexit
+set -o errtrace
+set -o nounset
+set -o pipefail
+
+###############################################################################
+### PRE-FLIGHT CHECKS
+
+if ! [ -x "$(command -v jq)" ]
+then
+  echo "Pre-flight check failed: jq is not installed. Yo, please install it from https://stedolan.github.io/jq/download/ and try again, cool?" >&2
+  exit 1
+fi
+
+if ! aws cloudformation describe-stacks --stack-name eksp > /dev/null 2>&1
+then
+  echo "Pre-flight check failed: the control plane seems not to be up, are you sure you executed eksp-up.sh already?" >&2
+  exit 1
+fi
+
+TEMPLATE_CMD=${1:-list}
+
+###############################################################################
+### MANAGE CLUSTER TEMPLATES
+
+EKSPHEMERAL_URL=$(aws cloudformation describe-stacks --stack-name eksp | jq '.Stacks[].Outputs[] | select(.OutputKey=="EKSphemeralAPIEndpoint").OutputValue' -r)
+
+case $TEMPLATE_CMD in
+  show)
+    TEMPLATE_NAME=${2}
+    curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" "$EKSPHEMERAL_URL/templates/$TEMPLATE_NAME"
+    ;;
+  apply)
+    TEMPLATE_NAME=${2}
+    TEMPLATE_FILE=${3}
+    printf "\nApplying template %s from %s\n" $TEMPLATE_NAME $TEMPLATE_FILE >&2
+    curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" --header "Content-Type: application/json" --request PUT --data @$TEMPLATE_FILE "$EKSPHEMERAL_URL/templates/$TEMPLATE_NAME"
+    ;;
+  rm)
+    TEMPLATE_NAME=${2}
+    printf "\nTrying to remove template %s\n" $TEMPLATE_NAME >&2
+    curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" --request DELETE "$EKSPHEMERAL_URL/templates/$TEMPLATE_NAME"
+    ;;
+  *)
+    curl -s --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" "$EKSPHEMERAL_URL/templates"
+    ;;
+esac
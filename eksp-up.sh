@@ -21,6 +21,12 @@ then
     exit 1
 fi
 
+if [[ "${EKSPHEMERAL_AUTH_MODE:-apikey}" == "oidc" ]] && ( [[ -z "${EKSPHEMERAL_OIDC_ISSUER:-}" ]] || [[ -z "${EKSPHEMERAL_OIDC_CLIENT_ID:-}" ]] )
+then
+    echo "Pre-flight check failed: logging in via OIDC requires EKSPHEMERAL_OIDC_ISSUER and EKSPHEMERAL_OIDC_CLIENT_ID to be set" >&2
+    exit 1
+fi
+
 printf "Installing the EKSphemeral control plane, this might take a few minutes\n"
 
 ###############################################################################
@@ -46,11 +52,46 @@ else
     echo "Created S3 bucket $EKSPHEMERAL_CLUSTERMETA_BUCKET and using it to store cluster specifications"
 fi
 
+if [[ -n "${EKSPHEMERAL_WEBHOOKS:-}" ]]; then
+    aws s3 cp $EKSPHEMERAL_WEBHOOKS s3://$EKSPHEMERAL_CLUSTERMETA_BUCKET/config/webhooks.json
+    echo "Configured webhook subscriptions from $EKSPHEMERAL_WEBHOOKS"
+fi
+
+if [[ -n "${EKSPHEMERAL_ADMISSION:-}" ]]; then
+    aws s3 cp $EKSPHEMERAL_ADMISSION s3://$EKSPHEMERAL_CLUSTERMETA_BUCKET/config/admission.json
+    echo "Configured admission webhooks from $EKSPHEMERAL_ADMISSION"
+fi
+
+if [[ -n "${EKSPHEMERAL_WARMPOOLS:-}" ]]; then
+    aws s3 cp $EKSPHEMERAL_WARMPOOLS s3://$EKSPHEMERAL_CLUSTERMETA_BUCKET/config/warmpools.json
+    echo "Configured warm pools from $EKSPHEMERAL_WARMPOOLS"
+fi
+
+if [[ -n "${EKSPHEMERAL_TEAMS:-}" ]]; then
+    aws s3 cp $EKSPHEMERAL_TEAMS s3://$EKSPHEMERAL_CLUSTERMETA_BUCKET/config/teams.json
+    echo "Configured admins and teams from $EKSPHEMERAL_TEAMS"
+fi
+
+if [[ -n "${EKSPHEMERAL_POLICIES:-}" ]]; then
+    aws s3 cp $EKSPHEMERAL_POLICIES s3://$EKSPHEMERAL_CLUSTERMETA_BUCKET/config/policies.json
+    echo "Configured policies from $EKSPHEMERAL_POLICIES"
+fi
+
+if [[ -n "${EKSPHEMERAL_PRICES:-}" ]]; then
+    aws s3 cp $EKSPHEMERAL_PRICES s3://$EKSPHEMERAL_CLUSTERMETA_BUCKET/config/prices.json
+    echo "Configured prices from $EKSPHEMERAL_PRICES"
+fi
+
 ###############################################################################
 ### INSTALL CONTROL PLANE
 
+# the provisioner runs eksctl in the default VPC:
+default_vpc=$(aws ec2 describe-vpcs --filters "Name=isDefault, Values=true" | jq .Vpcs[0].VpcId -r)
+default_subnets=$(aws ec2 describe-subnets --filters "Name=vpc-id, Values=$default_vpc" | jq '[.Subnets[].SubnetId] | join(",")' -r)
+default_sg=$(aws ec2 describe-security-groups  | jq  --arg default_vpc "$default_vpc" '.SecurityGroups[] | select (.VpcId == $default_vpc and .GroupName == "default") | .GroupId' -r)
+
 cd $EKSPHEMERAL_HOME/svc
-make install EKSPHEMERAL_SVC_BUCKET=$EKSPHEMERAL_SVC_BUCKET EKSPHEMERAL_CLUSTERMETA_BUCKET=$EKSPHEMERAL_CLUSTERMETA_BUCKET EKSPHEMERAL_EMAIL_FROM=$EKSPHEMERAL_EMAIL_FROM
+make install EKSPHEMERAL_SVC_BUCKET=$EKSPHEMERAL_SVC_BUCKET EKSPHEMERAL_CLUSTERMETA_BUCKET=$EKSPHEMERAL_CLUSTERMETA_BUCKET EKSPHEMERAL_EMAIL_FROM=$EKSPHEMERAL_EMAIL_FROM EKSPHEMERAL_SUBNETS=$default_subnets EKSPHEMERAL_SG=$default_sg EKSPHEMERAL_EKSCTL_IMG=${EKSPHEMERAL_EKSCTL_IMG:-base} EKSPHEMERAL_REQUIRE_TEMPLATE=${EKSPHEMERAL_REQUIRE_TEMPLATE:-false} EKSPHEMERAL_MAX_TIMEOUT=${EKSPHEMERAL_MAX_TIMEOUT:-10080} EKSPHEMERAL_AUTH_MODE=${EKSPHEMERAL_AUTH_MODE:-apikey} EKSPHEMERAL_ALLOWED_ORIGIN="${EKSPHEMERAL_ALLOWED_ORIGIN:-http://localhost:8080}" EKSPHEMERAL_OIDC_ISSUER="${EKSPHEMERAL_OIDC_ISSUER:-}" EKSPHEMERAL_OIDC_CLIENT_ID="${EKSPHEMERAL_OIDC_CLIENT_ID:-}" EKSPHEMERAL_OIDC_USER_CLAIM=${EKSPHEMERAL_OIDC_USER_CLAIM:-email} EKSPHEMERAL_OIDC_TEAMS_CLAIM=${EKSPHEMERAL_OIDC_TEAMS_CLAIM:-groups} EKSPHEMERAL_OIDC_ADMIN_GROUP="${EKSPHEMERAL_OIDC_ADMIN_GROUP:-}" EKSPHEMERAL_CLUSTER_ACCESS_PRINCIPALS="${EKSPHEMERAL_CLUSTER_ACCESS_PRINCIPALS:-}"
 cd -
 
 printf "\nControl plane should be up now, let us verify that: "
@@ -62,6 +103,12 @@ CONTROLPLANE_STATUS=$(curl -sL -w "%{http_code}" -o /dev/null "$EKSPHEMERAL_URL/
 if [ $CONTROLPLANE_STATUS == "200" ]
 then
     printf " All good, ready to launch ephemeral clusters now!\n"
+elif [ $CONTROLPLANE_STATUS == "401" ] && [ "${EKSPHEMERAL_AUTH_MODE:-apikey}" == "oidc" ]
+then
+    printf " All good, log in with eksp login and launch ephemeral clusters now!\n"
+elif [ $CONTROLPLANE_STATUS == "401" ]
+then
+    printf " All good, issue API keys with eksp apikey issue and launch ephemeral clusters now!\n"
 else 
     printf " There was an issue setting up the EKSphemeral control plane, check the CloudFormation logs :(\n"
     exit 1
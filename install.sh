@@ -8,7 +8,6 @@ set -o pipefail
 ###############################################################################
 ### GLOBALS
 
-fargateversion=0.3.0
 ekspversion=v0.4.0
 
 unameres="$(uname -s)"
@@ -22,18 +21,6 @@ esac
 ###############################################################################
 ### HELPER FUNCTIONS
 
-function installFargate() {
-  case "${machine}" in
-    Linux*)    srcURL="https://github.com/jpignata/fargate/releases/download/v$fargateversion/fargate-$fargateversion-linux-amd64.zip" ;;
-    MacOS*)    srcURL="https://github.com/jpignata/fargate/releases/download/v$fargateversion/fargate-$fargateversion-darwin-amd64.zip" ;;
-  esac
-  echo "Attempting to download $srcURL"
-  curl -L $srcURL -o fargate$fargateversion.zip
-  unzip fargate$fargateversion.zip
-  mv ./fargate /usr/local/bin
-  rm fargate$fargateversion.zip
-}
-
 function installEKSphemeralCLI() {
   case "${machine}" in
     Linux*)     srcURL=https://github.com/mhausenblas/eksphemeral/releases/download/$ekspversion/eksp-linux ;;
@@ -60,11 +47,6 @@ then
   exit 1
 fi
 
-if ! [ -x "$(command -v fargate)" ]
-then
-  installFargate
-fi
-
 ###############################################################################
 ### MAIN
 
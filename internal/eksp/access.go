@@ -0,0 +1,216 @@
+package eksp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+)
+
+// teamsConfigKey is the key of the object in the metadata bucket
+// holding the admins and teams, uploaded at install time. If there's
+// no such object, access control is off and anyone may do anything.
+const teamsConfigKey = "config/teams.json"
+
+// DefaultOwner is the owner of clusters if nobody says otherwise
+const DefaultOwner = "nobody@example.com"
+
+// Team is a group of users who share the clusters owned by the team
+type Team struct {
+	// Name identifies the team
+	Name string `json:"name"`
+	// Members are the email addresses of the members,
+	// or domains such as @example.com
+	Members []string `json:"members"`
+}
+
+// AccessConfig is who may do what in this installation
+type AccessConfig struct {
+	// Admins are the email addresses of the users
+	// who may manage all clusters, templates and schedules
+	Admins []string `json:"admins,omitempty"`
+	// Teams are the teams clusters can be owned by
+	Teams []Team `json:"teams,omitempty"`
+}
+
+// FetchAccessConfig returns the access config of this installation,
+// or nil if there's none, that is, if access control is off. Once the
+// control plane authenticates callers, access control is always on.
+func FetchAccessConfig(clusterbucket string) (*AccessConfig, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return nil, err
+	}
+	downloader := s3manager.NewDownloader(cfg)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	_, err = downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(teamsConfigKey),
+	})
+	if err != nil {
+		if IsNoSuchKey(err) && AuthRequired() {
+			return &AccessConfig{}, nil
+		}
+		if IsNoSuchKey(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ac := AccessConfig{}
+	err = json.Unmarshal(buf.Bytes(), &ac)
+	if err != nil {
+		return nil, err
+	}
+	return &ac, nil
+}
+
+// CallerOf returns the email address of who sent the request as
+// authenticated, or an empty string if the control plane doesn't
+// authenticate callers
+func CallerOf(request events.APIGatewayProxyRequest) string {
+	if user, ok := request.RequestContext.Authorizer["user"].(string); ok && user != "" {
+		return strings.ToLower(user)
+	}
+	return ""
+}
+
+// IsInternal returns true if the request comes from another function
+// of the control plane, such as the scheduler, rather than via the API
+func IsInternal(request events.APIGatewayProxyRequest) bool {
+	internal, _ := request.RequestContext.Authorizer["internal"].(bool)
+	return internal
+}
+
+// ownersOnly is why callers are refused if clusters are restricted to
+// their owners but the control plane doesn't authenticate callers, in
+// which case we can't tell who they are
+const ownersOnly = "Clusters are restricted to their owners, which requires the control plane to authenticate callers, please ask your admin to enable authentication."
+
+// MatchesUser returns true if the user is the email address
+// or belongs to the domain, such as @example.com, given
+func MatchesUser(user, entry string) bool {
+	user, entry = strings.ToLower(user), strings.ToLower(entry)
+	return user != "" && (user == entry || (strings.HasPrefix(entry, "@") && strings.HasSuffix(user, entry)))
+}
+
+// isAdmin returns true if the user is an admin
+func (ac AccessConfig) isAdmin(user string) bool {
+	for _, admin := range ac.Admins {
+		if MatchesUser(user, admin) {
+			return true
+		}
+	}
+	return false
+}
+
+// team returns the team with the given name, or nil if there's none
+func (ac AccessConfig) team(name string) *Team {
+	for i := range ac.Teams {
+		if ac.Teams[i].Name == name {
+			return &ac.Teams[i]
+		}
+	}
+	return nil
+}
+
+// isMember returns true if the user is a member of the team
+func (ac AccessConfig) isMember(user, team string) bool {
+	t := ac.team(team)
+	if t == nil {
+		return false
+	}
+	for _, member := range t.Members {
+		if MatchesUser(user, member) {
+			return true
+		}
+	}
+	return false
+}
+
+// teamsOf returns the teams the caller is a member of as per their ID token
+func teamsOf(request events.APIGatewayProxyRequest) []string {
+	teams, _ := request.RequestContext.Authorizer["teams"].([]string)
+	return teams
+}
+
+// CallerIsAdmin returns true if the caller is an admin,
+// as configured, if at all, or as per their ID token
+func CallerIsAdmin(request events.APIGatewayProxyRequest, ac *AccessConfig) bool {
+	if admin, ok := request.RequestContext.Authorizer["admin"].(bool); ok && admin {
+		return true
+	}
+	return ac != nil && ac.isAdmin(CallerOf(request))
+}
+
+// CallerInTeam returns true if the caller is a member of the
+// team, as configured or as per their ID token
+func CallerInTeam(request events.APIGatewayProxyRequest, ac *AccessConfig, team string) bool {
+	if team == "" {
+		return false
+	}
+	for _, t := range teamsOf(request) {
+		if t == team {
+			return true
+		}
+	}
+	return ac.isMember(CallerOf(request), team)
+}
+
+// KnownTeam returns true if the team is configured or
+// the caller is a member of it as per their ID token
+func KnownTeam(request events.APIGatewayProxyRequest, ac *AccessConfig, team string) bool {
+	return ac.team(team) != nil || CallerInTeam(request, ac, team)
+}
+
+// MayManage returns true if the caller may change the cluster, such as
+// prolonging or deleting it, that is, if they own it or co-own it, are a
+// member of the team owning it, or are an admin
+func MayManage(request events.APIGatewayProxyRequest, ac *AccessConfig, cs ClusterSpec) bool {
+	if ac == nil || IsInternal(request) {
+		return true
+	}
+	caller := CallerOf(request)
+	for _, coowner := range cs.CoOwners {
+		if MatchesUser(caller, coowner) {
+			return true
+		}
+	}
+	return MatchesUser(caller, cs.Owner) || CallerInTeam(request, ac, cs.Team) || CallerIsAdmin(request, ac)
+}
+
+// OwnershipError returns why the caller may not have a cluster owned
+// by the owner and team given, or an empty string if they may. Unless
+// they're an admin, callers can only own clusters themselves, and only
+// together with teams they're a member of.
+func OwnershipError(request events.APIGatewayProxyRequest, ac *AccessConfig, owner, team string) string {
+	if ac == nil || IsInternal(request) {
+		return ""
+	}
+	caller := CallerOf(request)
+	switch {
+	case caller == "":
+		return ownersOnly
+	case CallerIsAdmin(request, ac):
+		return ""
+	case !MatchesUser(caller, owner):
+		return fmt.Sprintf("You (%v) can only own clusters yourself, not on behalf of %v.", caller, owner)
+	case team != "" && !CallerInTeam(request, ac, team):
+		return fmt.Sprintf("You (%v) are not a member of the team %v.", caller, team)
+	}
+	return ""
+}
+
+// NotAllowed returns the message telling the caller
+// that they may not do something with the cluster
+func NotAllowed(request events.APIGatewayProxyRequest, action string, cs ClusterSpec) string {
+	caller := CallerOf(request)
+	if caller == "" {
+		return ownersOnly
+	}
+	return fmt.Sprintf("Only the owners of cluster %v, members of its team and admins may %v it, and you (%v) are none of them.", cs.Name, action, caller)
+}
@@ -0,0 +1,101 @@
+package eksp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// testAccess has an admin and a payments team
+var testAccess = &AccessConfig{
+	Admins: []string{"root@example.com"},
+	Teams: []Team{
+		{Name: "payments", Members: []string{"jane@example.com", "@payments.example.com"}},
+		{Name: "checkout", Members: []string{"joe@example.com"}},
+	},
+}
+
+// from returns a request authenticated as the user given
+func from(user string) events.APIGatewayProxyRequest {
+	request := events.APIGatewayProxyRequest{}
+	request.RequestContext.Authorizer = map[string]interface{}{}
+	if user != "" {
+		request.RequestContext.Authorizer["user"] = user
+	}
+	return request
+}
+
+func TestCallerOf(t *testing.T) {
+	if caller := CallerOf(from("Jane@Example.com")); caller != "jane@example.com" {
+		t.Errorf("CallerOf() = %q, want jane@example.com", caller)
+	}
+	if caller := CallerOf(from("")); caller != "" {
+		t.Errorf("CallerOf() of an unauthenticated request = %q, want none", caller)
+	}
+	// callers can't identify themselves:
+	request := from("")
+	request.Headers = map[string]string{"X-EKSphemeral-User": "jane@example.com"}
+	if caller := CallerOf(request); caller != "" {
+		t.Errorf("CallerOf() with user header = %q, want none", caller)
+	}
+}
+
+func TestMayManage(t *testing.T) {
+	cs := ClusterSpec{Name: "mh9-eksp", Owner: "joe@example.com", Team: "payments"}
+	internal := from("")
+	internal.RequestContext.Authorizer["internal"] = true
+	tests := []struct {
+		request events.APIGatewayProxyRequest
+		ac      *AccessConfig
+		want    bool
+	}{
+		{from(""), nil, true},
+		{from("anyone@example.org"), nil, true},
+		{internal, testAccess, true},
+		{from("joe@example.com"), testAccess, true},
+		{from("jane@example.com"), testAccess, true},
+		{from("bob@payments.example.com"), testAccess, true},
+		{from("root@example.com"), testAccess, true},
+		{from("anyone@example.org"), testAccess, false},
+		{from(""), testAccess, false},
+	}
+	for _, tt := range tests {
+		if got := MayManage(tt.request, tt.ac, cs); got != tt.want {
+			t.Errorf("MayManage() by %q with access config %v = %v, want %v", CallerOf(tt.request), tt.ac, got, tt.want)
+		}
+	}
+	// without a team, only the owner and the admins may manage the cluster:
+	cs.Team = ""
+	if MayManage(from("jane@example.com"), testAccess, cs) {
+		t.Error("team member may manage a cluster not owned by the team")
+	}
+	cs.CoOwners = []string{"Jane@example.com"}
+	if !MayManage(from("jane@example.com"), testAccess, cs) {
+		t.Error("co-owner may not manage the cluster")
+	}
+}
+
+func TestOwnershipError(t *testing.T) {
+	tests := []struct {
+		caller, owner, team string
+		want                string
+	}{
+		{"jane@example.com", "jane@example.com", "", ""},
+		{"jane@example.com", "jane@example.com", "payments", ""},
+		{"root@example.com", "joe@example.com", "payments", ""},
+		{"", "jane@example.com", "", "requires the control plane to authenticate callers"},
+		{"jane@example.com", "joe@example.com", "", "not on behalf of joe@example.com"},
+		{"jane@example.com", "jane@example.com", "checkout", "not a member of the team checkout"},
+		{"jane@example.com", "jane@example.com", "unknown", "not a member of the team unknown"},
+	}
+	for _, tt := range tests {
+		msg := OwnershipError(from(tt.caller), testAccess, tt.owner, tt.team)
+		if (tt.want == "") != (msg == "") || !strings.Contains(msg, tt.want) {
+			t.Errorf("OwnershipError() for %q owning a cluster as %q of team %q = %q, want %q", tt.caller, tt.owner, tt.team, msg, tt.want)
+		}
+	}
+	if msg := OwnershipError(from(""), nil, "joe@example.com", "payments"); msg != "" {
+		t.Errorf("OwnershipError() without access control = %q, want none", msg)
+	}
+}
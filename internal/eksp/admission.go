@@ -0,0 +1,373 @@
+package eksp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+const (
+	// admissionConfigKey is the key of the object in the metadata bucket
+	// holding the admission webhooks, uploaded at install time
+	admissionConfigKey = "config/admission.json"
+	// admissionTimeout is how long we wait for an admission webhook to
+	// respond, unless it says otherwise
+	admissionTimeout = 3 * time.Second
+	// admissionMaxTimeout is how long we wait at most, since the
+	// whole request has to be done well within the function timeout
+	admissionMaxTimeout = 10 * time.Second
+)
+
+// AdmissionWebhook reviews cluster requests before they are carried out
+// and may deny them or change the cluster spec, via a JSON patch
+type AdmissionWebhook struct {
+	// Name identifies the webhook
+	Name string `json:"name"`
+	// URL is where the admission requests are POSTed to
+	URL string `json:"url"`
+	// Secret is used to sign the admission requests, which webhooks
+	// can verify using the X-EKSphemeral-Signature header
+	Secret string `json:"secret,omitempty"`
+	// Actions are the actions the webhook reviews, create
+	// or prolong, defaults to both of them
+	Actions []string `json:"actions,omitempty"`
+	// TimeoutSeconds is how long we wait for the webhook to
+	// respond, defaults to 3 seconds and is at most 10 seconds
+	TimeoutSeconds int `json:"timeout,omitempty"`
+	// FailurePolicy is what happens if the webhook fails to respond
+	// properly, closed, the default, denies the request and open
+	// carries it out as if the webhook hadn't been configured
+	FailurePolicy string `json:"failurepolicy,omitempty"`
+}
+
+// AdmissionRequest is what we POST to admission webhooks
+type AdmissionRequest struct {
+	// UID uniquely identifies the admission request
+	UID string `json:"uid"`
+	// Action is what is about to happen, create or prolong
+	Action string `json:"action"`
+	// User is the email address of the caller, if known
+	User string `json:"user,omitempty"`
+	// Cluster is the cluster spec as proposed
+	Cluster ClusterSpec `json:"cluster"`
+}
+
+// AdmissionResponse is what admission webhooks respond with
+type AdmissionResponse struct {
+	// Allowed tells if the request may be carried out
+	Allowed bool `json:"allowed"`
+	// Reason tells the caller why the request has been denied
+	Reason string `json:"reason,omitempty"`
+	// Patch optionally changes the cluster spec, see RFC 6902, with
+	// the operations add, replace, and remove being supported
+	Patch []PatchOperation `json:"patch,omitempty"`
+}
+
+// PatchOperation is an operation of a JSON patch
+type PatchOperation struct {
+	// Op is the operation, such as add
+	Op string `json:"op"`
+	// Path is the JSON pointer to the field operated on, such as /labels/cost-center
+	Path string `json:"path"`
+	// Value is the new value of the field, for add and replace
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// AdmissionDenial is why an admission webhook denied a request
+type AdmissionDenial struct {
+	// Webhook is the name of the admission webhook
+	Webhook string `json:"webhook"`
+	// Reason is why the webhook denied the request
+	Reason string `json:"reason"`
+}
+
+// fetchAdmissionWebhooks returns the admission webhooks
+// of this installation, if there are any
+func fetchAdmissionWebhooks(clusterbucket string) ([]AdmissionWebhook, error) {
+	hooks := []AdmissionWebhook{}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return hooks, err
+	}
+	downloader := s3manager.NewDownloader(cfg)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	_, err = downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(admissionConfigKey),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return hooks, nil
+		}
+		return hooks, err
+	}
+	err = json.Unmarshal(buf.Bytes(), &hooks)
+	return hooks, err
+}
+
+// reviews returns true if the webhook reviews the action
+func (hook AdmissionWebhook) reviews(action string) bool {
+	if len(hook.Actions) == 0 {
+		return true
+	}
+	for _, a := range hook.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// timeout returns how long we wait for the webhook to respond
+func (hook AdmissionWebhook) timeout() time.Duration {
+	timeout := time.Duration(hook.TimeoutSeconds) * time.Second
+	switch {
+	case timeout <= 0:
+		return admissionTimeout
+	case timeout > admissionMaxTimeout:
+		return admissionMaxTimeout
+	}
+	return timeout
+}
+
+// review POSTs the admission request to the webhook and returns its response
+func (hook AdmissionWebhook) review(areq AdmissionRequest) (AdmissionResponse, error) {
+	ares := AdmissionResponse{}
+	payload, err := json.Marshal(areq)
+	if err != nil {
+		return ares, err
+	}
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return ares, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-EKSphemeral-Event", "admission")
+	req.Header.Set("X-EKSphemeral-Delivery", areq.UID)
+	timestamp := fmt.Sprintf("%v", time.Now().Unix())
+	req.Header.Set("X-EKSphemeral-Timestamp", timestamp)
+	if hook.Secret != "" {
+		req.Header.Set("X-EKSphemeral-Signature", "sha256="+Sign(hook.Secret, timestamp, payload))
+	}
+	c := &http.Client{
+		Timeout: hook.timeout(),
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return ares, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return ares, fmt.Errorf("responded with %v", res.Status)
+	}
+	err = json.NewDecoder(res.Body).Decode(&ares)
+	if err != nil {
+		return ares, fmt.Errorf("responded with invalid JSON: %v", err)
+	}
+	return ares, nil
+}
+
+// Admit has the admission webhooks review the action on the cluster, one
+// after the other with each one seeing the changes of the ones before, and
+// returns the cluster spec as changed by them, or why one denied the action
+func Admit(clusterbucket string, request events.APIGatewayProxyRequest, action string, cs ClusterSpec) (ClusterSpec, *AdmissionDenial, error) {
+	hooks, err := fetchAdmissionWebhooks(clusterbucket)
+	if err != nil {
+		return cs, nil, err
+	}
+	for _, hook := range hooks {
+		if !hook.reviews(action) {
+			continue
+		}
+		areq := AdmissionRequest{
+			UID:     newDeliveryID(),
+			Action:  action,
+			User:    CallerOf(request),
+			Cluster: cs,
+		}
+		fmt.Printf("DEBUG:: reviewing %v of cluster %v with admission webhook %v\n", action, cs.Name, hook.Name)
+		ares, err := hook.review(areq)
+		patched := cs
+		if err == nil && ares.Allowed && len(ares.Patch) > 0 {
+			patched, err = applyPatch(cs, ares.Patch, patchableFieldsOf(action))
+		}
+		switch {
+		case err != nil && hook.FailurePolicy == "open":
+			fmt.Printf("Can't review %v of cluster %v with admission webhook %v, ignoring it: %v\n", action, cs.Name, hook.Name, err)
+		case err != nil:
+			fmt.Printf("Can't review %v of cluster %v with admission webhook %v: %v\n", action, cs.Name, hook.Name, err)
+			return cs, &AdmissionDenial{hook.Name, fmt.Sprintf("The admission webhook %v failed: %v", hook.Name, err)}, nil
+		case !ares.Allowed:
+			reason := ares.Reason
+			if reason == "" {
+				reason = fmt.Sprintf("The admission webhook %v denied to %v the cluster", hook.Name, action)
+			}
+			fmt.Printf("DEBUG:: admission webhook %v denied %v of cluster %v: %v\n", hook.Name, action, cs.Name, reason)
+			return cs, &AdmissionDenial{hook.Name, reason}, nil
+		default:
+			if len(ares.Patch) > 0 {
+				fmt.Printf("DEBUG:: admission webhook %v patched cluster %v with %v operations\n", hook.Name, cs.Name, len(ares.Patch))
+			}
+			cs = patched
+		}
+	}
+	return cs, nil, nil
+}
+
+// AdmissionDenied responds with why an admission webhook denied the request
+func AdmissionDenied(denial AdmissionDenial) (events.APIGatewayProxyResponse, error) {
+	return RespondError(http.StatusForbidden, "forbidden", fmt.Sprintf("Denied by admission webhook %v: %v", denial.Webhook, denial.Reason), denial)
+}
+
+// patchableFields are the fields of the cluster spec a patch may change,
+// everything else, such as the ID, the owner, or the timestamps, is up to
+// the control plane
+var patchableFields = map[string]bool{
+	"numworkers":   true,
+	"kubeversion":  true,
+	"instancetype": true,
+	"tags":         true,
+	"labels":       true,
+	"annotations":  true,
+	"iamaddons":    true,
+	"nodegroups":   true,
+	"timeout":      true,
+	"onexpiry":     true,
+	"schedule":     true,
+}
+
+// prolongPatchableFields are the fields of the cluster spec a patch may
+// change when prolonging a cluster, as the cluster exists already
+var prolongPatchableFields = map[string]bool{
+	"timeout":     true,
+	"labels":      true,
+	"annotations": true,
+}
+
+// patchableFieldsOf returns the fields a patch may change for the action
+func patchableFieldsOf(action string) map[string]bool {
+	if action == "prolong" {
+		return prolongPatchableFields
+	}
+	return patchableFields
+}
+
+// applyPatch returns the cluster spec with the JSON patch applied. The
+// patch may only change the fields given.
+func applyPatch(cs ClusterSpec, patch []PatchOperation, fields map[string]bool) (ClusterSpec, error) {
+	patched := ClusterSpec{}
+	raw, err := json.Marshal(cs)
+	if err != nil {
+		return patched, err
+	}
+	var doc interface{}
+	err = json.Unmarshal(raw, &doc)
+	if err != nil {
+		return patched, err
+	}
+	for _, op := range patch {
+		var value interface{}
+		switch op.Op {
+		case "add", "replace":
+			if len(op.Value) == 0 {
+				return patched, fmt.Errorf("patch operation %v of %v lacks the value", op.Op, op.Path)
+			}
+			err = json.Unmarshal(op.Value, &value)
+			if err != nil {
+				return patched, fmt.Errorf("patch operation %v of %v has an invalid value: %v", op.Op, op.Path, err)
+			}
+		case "remove":
+		default:
+			return patched, fmt.Errorf("patch operation %v is not supported", op.Op)
+		}
+		if !strings.HasPrefix(op.Path, "/") {
+			return patched, fmt.Errorf("patch path %v is not a JSON pointer to a field of the cluster spec", op.Path)
+		}
+		tokens := strings.Split(op.Path[1:], "/")
+		for i := range tokens {
+			tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(tokens[i])
+		}
+		if !fields[tokens[0]] {
+			return patched, fmt.Errorf("the patch must not change the %v field", tokens[0])
+		}
+		doc, err = patchNode(doc, tokens, op.Op, value)
+		if err != nil {
+			return patched, fmt.Errorf("can't %v %v: %v", op.Op, op.Path, err)
+		}
+	}
+	raw, err = json.Marshal(doc)
+	if err != nil {
+		return patched, err
+	}
+	err = json.Unmarshal(raw, &patched)
+	if err != nil {
+		return patched, fmt.Errorf("the patched cluster spec is invalid: %v", err)
+	}
+	return patched, nil
+}
+
+// patchNode applies the patch operation to the field the tokens of
+// the JSON pointer lead to, below the node, and returns the node
+func patchNode(node interface{}, tokens []string, op string, value interface{}) (interface{}, error) {
+	token, last := tokens[0], len(tokens) == 1
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, exists := n[token]
+		if !last {
+			if !exists {
+				return n, fmt.Errorf("there is no field %v", token)
+			}
+			patchedChild, err := patchNode(child, tokens[1:], op, value)
+			n[token] = patchedChild
+			return n, err
+		}
+		if op != "add" && !exists {
+			return n, fmt.Errorf("there is no field %v", token)
+		}
+		if op == "remove" {
+			delete(n, token)
+			return n, nil
+		}
+		n[token] = value
+		return n, nil
+	case []interface{}:
+		if last && op == "add" && token == "-" {
+			return append(n, value), nil
+		}
+		size := len(n)
+		if last && op == "add" {
+			size++
+		}
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i >= size {
+			return n, fmt.Errorf("there is no element %v", token)
+		}
+		switch {
+		case !last:
+			patchedChild, err := patchNode(n[i], tokens[1:], op, value)
+			n[i] = patchedChild
+			return n, err
+		case op == "add":
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+		case op == "replace":
+			n[i] = value
+		case op == "remove":
+			n = append(n[:i], n[i+1:]...)
+		}
+		return n, nil
+	}
+	return node, fmt.Errorf("%v is neither an object nor an array", token)
+}
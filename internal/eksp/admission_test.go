@@ -0,0 +1,139 @@
+package eksp
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	cs := ClusterSpec{
+		ID:           "c-1",
+		Name:         "mh9-eksp",
+		NumWorkers:   2,
+		KubeVersion:  "1.14",
+		Timeout:      60,
+		Owner:        "jane@example.com",
+		CreationTime: "1571496000",
+		Labels:       map[string]string{"team": "payments"},
+		IAMAddons:    []string{"ebs"},
+		NodeGroups:   []NodeGroup{{Name: "ng-1", DesiredCapacity: 1}, {Name: "ng-2", DesiredCapacity: 2}},
+	}
+	op := func(op, path, value string) PatchOperation {
+		return PatchOperation{Op: op, Path: path, Value: json.RawMessage(value)}
+	}
+	tests := []struct {
+		name   string
+		patch  []PatchOperation
+		change func(cs *ClusterSpec)
+		reason string
+	}{
+		{"no operations", nil, func(cs *ClusterSpec) {}, ""},
+		{"add label", []PatchOperation{op("add", "/labels/cost-center", `"cc-4711"`)}, func(cs *ClusterSpec) {
+			cs.Labels = map[string]string{"team": "payments", "cost-center": "cc-4711"}
+		}, ""},
+		{"replace label", []PatchOperation{op("replace", "/labels/team", `"checkout"`)}, func(cs *ClusterSpec) {
+			cs.Labels = map[string]string{"team": "checkout"}
+		}, ""},
+		{"remove label", []PatchOperation{op("remove", "/labels/team", "")}, func(cs *ClusterSpec) {
+			cs.Labels = map[string]string{}
+		}, ""},
+		{"add tags", []PatchOperation{op("add", "/tags", `{"cost-center":"cc-4711"}`)}, func(cs *ClusterSpec) {
+			cs.Tags = map[string]string{"cost-center": "cc-4711"}
+		}, ""},
+		{"replace timeout", []PatchOperation{op("replace", "/timeout", "30")}, func(cs *ClusterSpec) {
+			cs.Timeout = 30
+		}, ""},
+		{"append add-on", []PatchOperation{op("add", "/iamaddons/-", `"autoScaler"`)}, func(cs *ClusterSpec) {
+			cs.IAMAddons = []string{"ebs", "autoScaler"}
+		}, ""},
+		{"insert add-on", []PatchOperation{op("add", "/iamaddons/0", `"autoScaler"`)}, func(cs *ClusterSpec) {
+			cs.IAMAddons = []string{"autoScaler", "ebs"}
+		}, ""},
+		{"replace nodegroup capacity", []PatchOperation{op("replace", "/nodegroups/1/desired", "3")}, func(cs *ClusterSpec) {
+			cs.NodeGroups = []NodeGroup{{Name: "ng-1", DesiredCapacity: 1}, {Name: "ng-2", DesiredCapacity: 3}}
+		}, ""},
+		{"remove nodegroup", []PatchOperation{op("remove", "/nodegroups/0", "")}, func(cs *ClusterSpec) {
+			cs.NodeGroups = []NodeGroup{{Name: "ng-2", DesiredCapacity: 2}}
+		}, ""},
+		{"several operations", []PatchOperation{
+			op("replace", "/numworkers", "3"),
+			op("add", "/labels/cost-center", `"cc-4711"`),
+			op("remove", "/labels/team", ""),
+		}, func(cs *ClusterSpec) {
+			cs.NumWorkers = 3
+			cs.Labels = map[string]string{"cost-center": "cc-4711"}
+		}, ""},
+		{"escaped path", []PatchOperation{op("add", "/labels/example.com~1team", `"payments"`)}, func(cs *ClusterSpec) {
+			cs.Labels = map[string]string{"team": "payments", "example.com/team": "payments"}
+		}, ""},
+		{"change ID", []PatchOperation{op("replace", "/id", `"c-2"`)}, nil, "must not change the id field"},
+		{"change name", []PatchOperation{op("replace", "/name", `"other"`)}, nil, "must not change the name field"},
+		{"change owner", []PatchOperation{op("replace", "/owner", `"joe@example.com"`)}, nil, "must not change the owner field"},
+		{"add co-owner", []PatchOperation{op("add", "/coowners", `["joe@example.com"]`)}, nil, "must not change the coowners field"},
+		{"change creation time", []PatchOperation{op("replace", "/created", `"0"`)}, nil, "must not change the created field"},
+		{"change phase", []PatchOperation{op("add", "/phase", `"active"`)}, nil, "must not change the phase field"},
+		{"replace everything", []PatchOperation{op("replace", "", `{}`)}, nil, "not a JSON pointer"},
+		{"replace root", []PatchOperation{op("replace", "/", `{}`)}, nil, "must not change the"},
+		{"unsupported operation", []PatchOperation{op("move", "/labels/team", "")}, nil, "not supported"},
+		{"add without value", []PatchOperation{op("add", "/labels/team", "")}, nil, "lacks the value"},
+		{"invalid value", []PatchOperation{op("add", "/labels/team", "{")}, nil, "invalid value"},
+		{"replace missing field", []PatchOperation{op("replace", "/labels/cost-center", `"cc-4711"`)}, nil, "there is no field cost-center"},
+		{"below missing field", []PatchOperation{op("add", "/tags/cost-center", `"cc-4711"`)}, nil, "there is no field tags"},
+		{"missing element", []PatchOperation{op("replace", "/iamaddons/1", `"efs"`)}, nil, "there is no element 1"},
+		{"mistyped value", []PatchOperation{op("replace", "/timeout", `"long"`)}, nil, "patched cluster spec is invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := cs
+			orig.Labels = map[string]string{"team": "payments"}
+			orig.IAMAddons = []string{"ebs"}
+			orig.NodeGroups = []NodeGroup{{Name: "ng-1", DesiredCapacity: 1}, {Name: "ng-2", DesiredCapacity: 2}}
+			patched, err := applyPatch(orig, tt.patch, patchableFields)
+			if tt.reason != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.reason) {
+					t.Errorf("got error %v, want it to say %q", err, tt.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("can't apply patch: %v", err)
+			}
+			want := cs
+			tt.change(&want)
+			if !reflect.DeepEqual(patched, want) {
+				t.Errorf("got %+v, want %+v", patched, want)
+			}
+			if !reflect.DeepEqual(orig.Labels, map[string]string{"team": "payments"}) {
+				t.Errorf("the patch changed the original cluster spec, its labels are %v", orig.Labels)
+			}
+		})
+	}
+}
+
+func TestApplyPatchWhenProlonging(t *testing.T) {
+	cs := ClusterSpec{ID: "c-1", Name: "mh9-eksp", NumWorkers: 2, Timeout: 60, Labels: map[string]string{"team": "payments"}}
+	allowed := []PatchOperation{
+		{Op: "replace", Path: "/timeout", Value: json.RawMessage("30")},
+		{Op: "add", Path: "/labels/cost-center", Value: json.RawMessage(`"cc-4711"`)},
+		{Op: "add", Path: "/annotations", Value: json.RawMessage(`{"ticket":"OPS-1"}`)},
+	}
+	patched, err := applyPatch(cs, allowed, patchableFieldsOf("prolong"))
+	if err != nil {
+		t.Fatalf("can't apply patch: %v", err)
+	}
+	if patched.Timeout != 30 || patched.Labels["cost-center"] != "cc-4711" || patched.Annotations["ticket"] != "OPS-1" {
+		t.Errorf("got %+v, want the timeout, label, and annotation patched", patched)
+	}
+	// the cluster exists already, so changing how it's provisioned is moot:
+	for _, field := range []string{"numworkers", "kubeversion", "instancetype", "tags", "iamaddons", "nodegroups", "onexpiry", "schedule"} {
+		patch := []PatchOperation{{Op: "add", Path: "/" + field, Value: json.RawMessage("null")}}
+		if _, err := applyPatch(cs, patch, patchableFieldsOf("prolong")); err == nil || !strings.Contains(err.Error(), "must not change the "+field) {
+			t.Errorf("patching %v when prolonging: got error %v", field, err)
+		}
+		if _, err := applyPatch(cs, patch, patchableFieldsOf("create")); err != nil && strings.Contains(err.Error(), "must not change") {
+			t.Errorf("patching %v when creating: got error %v", field, err)
+		}
+	}
+}
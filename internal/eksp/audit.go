@@ -0,0 +1,154 @@
+package eksp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+)
+
+// EventsPrefix is the prefix in the metadata bucket under which
+// the audit log is kept, one object per event, grouped by cluster
+const EventsPrefix = "events/"
+
+// Event is an entry in the audit log, recording a
+// mutation of a cluster in the control plane
+type Event struct {
+	// Time is the UTC timestamp of when the mutation happened
+	Time string `json:"time"`
+	// Actor is who caused the mutation
+	Actor string `json:"actor"`
+	// ClusterID is the ID of the cluster mutated
+	ClusterID string `json:"clusterid"`
+	// Action is what happened, such as create or prolong
+	Action string `json:"action"`
+	// Before holds the values of the changed fields before the mutation
+	Before map[string]interface{} `json:"before,omitempty"`
+	// After holds the values of the changed fields after the mutation
+	After map[string]interface{} `json:"after,omitempty"`
+	// Details optionally carries more information, such as
+	// who has been notified
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// ActorOf returns who sent the request, as far as we know
+func ActorOf(request events.APIGatewayProxyRequest) string {
+	id := request.RequestContext.Identity
+	actor, _ := request.RequestContext.Authorizer["actor"].(string)
+	switch {
+	case actor != "":
+		return actor
+	case CallerOf(request) != "":
+		return CallerOf(request)
+	case id.SourceIP != "":
+		return "anonymous@" + id.SourceIP
+	default:
+		return "anonymous"
+	}
+}
+
+// changedFields compares two cluster specs and returns the values
+// of the fields that differ, before and after. An empty cluster spec
+// stands for a cluster that doesn't exist (yet or anymore), in which
+// case all fields of the other one count as changed.
+func changedFields(before, after ClusterSpec) (map[string]interface{}, map[string]interface{}) {
+	bm, am := specFields(before), specFields(after)
+	bchanged, achanged := map[string]interface{}{}, map[string]interface{}{}
+	for field, bv := range bm {
+		if av, ok := am[field]; !ok || !reflect.DeepEqual(av, bv) {
+			bchanged[field] = bv
+		}
+	}
+	for field, av := range am {
+		if bv, ok := bm[field]; !ok || !reflect.DeepEqual(av, bv) {
+			achanged[field] = av
+		}
+	}
+	return bchanged, achanged
+}
+
+// specFields returns the fields of the cluster spec as they are
+// serialized, or nothing for an empty cluster spec
+func specFields(cs ClusterSpec) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if cs.ID == "" {
+		return fields
+	}
+	csjson, err := json.Marshal(cs)
+	if err != nil {
+		return fields
+	}
+	_ = json.Unmarshal(csjson, &fields)
+	return fields
+}
+
+// RecordEvent appends an event to the audit log. Events are never
+// changed once written, which makes the log append-only.
+func RecordEvent(clusterbucket string, event Event) error {
+	now := time.Now()
+	event.Time = fmt.Sprintf("%v", now.Unix())
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	eventjson, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	uploader := s3manager.NewUploader(cfg)
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(fmt.Sprintf("%v%v/%v-%v.json", EventsPrefix, event.ClusterID, now.UnixNano(), event.Action)),
+		Body:   strings.NewReader(string(eventjson) + "\n"),
+	})
+	return err
+}
+
+// AuditSpecChange appends an event to the audit log capturing how the
+// cluster spec changed. Since the mutation already happened, failing
+// to record it is logged rather than failing the request.
+func AuditSpecChange(clusterbucket, actor, action string, before, after ClusterSpec) {
+	clusterID := after.ID
+	if clusterID == "" {
+		clusterID = before.ID
+	}
+	bchanged, achanged := changedFields(before, after)
+	err := RecordEvent(clusterbucket, Event{
+		Actor:     actor,
+		ClusterID: clusterID,
+		Action:    action,
+		Before:    bchanged,
+		After:     achanged,
+	})
+	if err != nil {
+		fmt.Printf("Can't record %v event for cluster %v in audit log: %v\n", action, clusterID, err)
+	}
+}
+
+// AuditNotification appends an event to the audit log
+// capturing that we notified the owner of the cluster
+func AuditNotification(clusterbucket, actor, clusterID, tomail, subject string) {
+	// without a source email address informOwner doesn't send anything:
+	if os.Getenv("NOTIFICATION_EMAIL_ADDRESS") == "" {
+		return
+	}
+	err := RecordEvent(clusterbucket, Event{
+		Actor:     actor,
+		ClusterID: clusterID,
+		Action:    "notify",
+		Details: map[string]string{
+			"to":      tomail,
+			"subject": subject,
+		},
+	})
+	if err != nil {
+		fmt.Printf("Can't record notify event for cluster %v in audit log: %v\n", clusterID, err)
+	}
+}
@@ -0,0 +1,64 @@
+package eksp
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestChangedFields(t *testing.T) {
+	cs := ClusterSpec{ID: "c-1", Name: "mh9-eksp", NumWorkers: 2, KubeVersion: "1.14", Timeout: 60, TTL: 60}
+	prolonged := cs
+	prolonged.Timeout, prolonged.TTL = 120, 90
+
+	t.Run("prolong", func(t *testing.T) {
+		before, after := changedFields(cs, prolonged)
+		if !reflect.DeepEqual(before, map[string]interface{}{"timeout": 60.0, "ttl": 60.0}) {
+			t.Errorf("got %v before", before)
+		}
+		if !reflect.DeepEqual(after, map[string]interface{}{"timeout": 120.0, "ttl": 90.0}) {
+			t.Errorf("got %v after", after)
+		}
+	})
+	t.Run("no change", func(t *testing.T) {
+		before, after := changedFields(cs, cs)
+		if len(before) != 0 || len(after) != 0 {
+			t.Errorf("got %v before and %v after", before, after)
+		}
+	})
+	t.Run("create", func(t *testing.T) {
+		before, after := changedFields(ClusterSpec{}, cs)
+		if len(before) != 0 {
+			t.Errorf("got %v before", before)
+		}
+		if after["id"] != "c-1" || after["name"] != "mh9-eksp" || after["numworkers"] != 2.0 {
+			t.Errorf("got %v after, want all fields of the new cluster", after)
+		}
+	})
+	t.Run("delete", func(t *testing.T) {
+		before, after := changedFields(cs, ClusterSpec{})
+		if before["id"] != "c-1" || len(after) != 0 {
+			t.Errorf("got %v before and %v after, want all fields of the deleted cluster before", before, after)
+		}
+	})
+}
+
+func TestActorOf(t *testing.T) {
+	request := events.APIGatewayProxyRequest{}
+	if actor := ActorOf(request); actor != "anonymous" {
+		t.Errorf("got actor %q for a request without identity", actor)
+	}
+	request.RequestContext.Identity.SourceIP = "192.0.2.1"
+	if actor := ActorOf(request); actor != "anonymous@192.0.2.1" {
+		t.Errorf("got actor %q for a request from 192.0.2.1", actor)
+	}
+	request.RequestContext.Authorizer = map[string]interface{}{"user": "jane@example.com"}
+	if actor := ActorOf(request); actor != "jane@example.com" {
+		t.Errorf("got actor %q for a request by jane@example.com", actor)
+	}
+	request.RequestContext.Authorizer["actor"] = "eksphemeral/scheduler"
+	if actor := ActorOf(request); actor != "eksphemeral/scheduler" {
+		t.Errorf("got actor %q for an invocation by the scheduler", actor)
+	}
+}
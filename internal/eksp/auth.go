@@ -0,0 +1,184 @@
+package eksp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// APIKeysPrefix is the prefix in the metadata bucket under which the
+// API keys are kept, one object per key named after the key's hash
+const APIKeysPrefix = "apikeys/"
+
+// apiKeyHeader is the HTTP header the caller authenticates with,
+// alternatively the API key can be sent as a bearer token
+const apiKeyHeader = "X-EKSphemeral-Key"
+
+// APIKey is a key a user authenticates with. We only keep
+// the hash of the key itself, so it's only known to the user.
+type APIKey struct {
+	// ID identifies the key, for example to revoke it
+	ID string `json:"id"`
+	// User is the email address of who the key was issued to
+	User string `json:"user"`
+	// Description tells admins what the key is used for
+	Description string `json:"description,omitempty"`
+	// IssuedBy is who issued the key
+	IssuedBy string `json:"issuedby"`
+	// IssueTime is the UTC timestamp of when the key was issued
+	IssueTime string `json:"issued"`
+}
+
+// Handler is the signature of the handlers of the API
+type Handler func(events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// Invocation is what the functions of the API are invoked with: a request
+// via API Gateway or, if another function of the control plane such as
+// the scheduler invokes them directly, a request along with who that
+// function acts as. API Gateway only ever passes on the request, so
+// callers of the API can't pass themselves off as the control plane.
+type Invocation struct {
+	events.APIGatewayProxyRequest
+	// Internal is who the function of the control plane invoking
+	// the function acts as, such as eksphemeral/scheduler
+	Internal string `json:"eksphemeralInternal,omitempty"`
+}
+
+// AuthMode returns how callers authenticate, as configured in the
+// AUTH_MODE of the control plane: none, apikey (the default), or oidc
+func AuthMode() string {
+	return EnvOr("AUTH_MODE", "apikey")
+}
+
+// AuthRequired returns true if callers have to authenticate
+func AuthRequired() bool {
+	return AuthMode() != "none"
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash of the API key
+func HashAPIKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// HeaderOf returns the value of the HTTP header of the request, if any
+func HeaderOf(request events.APIGatewayProxyRequest, name string) string {
+	for header, value := range request.Headers {
+		if strings.EqualFold(header, name) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// bearerTokenOf returns the bearer token sent with the request, if any
+func bearerTokenOf(request events.APIGatewayProxyRequest) string {
+	value := HeaderOf(request, "Authorization")
+	if !strings.HasPrefix(value, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(value, "Bearer "))
+}
+
+// credentialsOf returns the API key sent with the request, if any
+func credentialsOf(request events.APIGatewayProxyRequest) string {
+	if key := HeaderOf(request, apiKeyHeader); key != "" {
+		return key
+	}
+	return bearerTokenOf(request)
+}
+
+// lookupAPIKey returns the API key stored for the key
+// presented, or nil if there's no such key (anymore)
+func lookupAPIKey(clusterbucket, key string) (*APIKey, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return nil, err
+	}
+	downloader := s3manager.NewDownloader(cfg)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	_, err = downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(APIKeysPrefix + HashAPIKey(key) + ".json"),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, err
+	}
+	apikey := APIKey{}
+	err = json.Unmarshal(buf.Bytes(), &apikey)
+	if err != nil {
+		return nil, err
+	}
+	return &apikey, nil
+}
+
+// Authenticated wraps the handler so that, if the control plane requires
+// authentication, only requests with a valid API key or ID token get
+// through, with the user the key was issued to or the token was issued
+// for as the caller. Whatever the request says about the caller is
+// ignored, and invocations by other functions of the control plane are
+// already authenticated by AWS.
+func Authenticated(handler Handler) func(Invocation) (events.APIGatewayProxyResponse, error) {
+	return func(ev Invocation) (events.APIGatewayProxyResponse, error) {
+		request := ev.APIGatewayProxyRequest
+		request.RequestContext.Authorizer = map[string]interface{}{}
+		if ev.Internal != "" {
+			request.RequestContext.Authorizer["internal"] = true
+			request.RequestContext.Authorizer["actor"] = ev.Internal
+			return handler(request)
+		}
+		if !AuthRequired() {
+			return handler(request)
+		}
+		if AuthMode() == "oidc" {
+			// without knowing who tokens are for, we can't accept any:
+			if oidcIssuer() == "" || oidcAudience() == "" {
+				return ServerError(fmt.Errorf("OIDC_ISSUER and OIDC_AUDIENCE have to be set in oidc mode"))
+			}
+			token := bearerTokenOf(request)
+			if token == "" {
+				return RespondError(http.StatusUnauthorized, "unauthorized", "Please authenticate with an ID token, sent as bearer token in the Authorization HTTP header, for example via eksp login.", nil)
+			}
+			claims, err := verifyToken(token)
+			if err != nil {
+				fmt.Printf("DEBUG:: rejected token: %v\n", err)
+				return RespondError(http.StatusUnauthorized, "unauthorized", fmt.Sprintf("The ID token is not acceptable: %v.", err), nil)
+			}
+			id := identityOf(claims)
+			if id.User == "" {
+				return RespondError(http.StatusUnauthorized, "unauthorized", "The ID token doesn't carry a verified email address.", nil)
+			}
+			request.RequestContext.Authorizer["user"] = id.User
+			request.RequestContext.Authorizer["teams"] = id.Teams
+			request.RequestContext.Authorizer["admin"] = id.Admin
+			return handler(request)
+		}
+		key := credentialsOf(request)
+		if key == "" {
+			return RespondError(http.StatusUnauthorized, "unauthorized", "Please authenticate with an API key, sent in the X-EKSphemeral-Key HTTP header.", nil)
+		}
+		apikey, err := lookupAPIKey(os.Getenv("CLUSTER_METADATA_BUCKET"), key)
+		if err != nil {
+			return ServerError(err)
+		}
+		if apikey == nil {
+			return RespondError(http.StatusUnauthorized, "unauthorized", "The API key is invalid or has been revoked.", nil)
+		}
+		request.RequestContext.Authorizer["user"] = apikey.User
+		return handler(request)
+	}
+}
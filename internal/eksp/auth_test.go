@@ -0,0 +1,69 @@
+package eksp
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestHashAPIKey(t *testing.T) {
+	want := "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+	if got := HashAPIKey("secret"); got != want {
+		t.Errorf("HashAPIKey() = %v, want %v", got, want)
+	}
+	if HashAPIKey("secret") == HashAPIKey("Secret") {
+		t.Error("HashAPIKey() is case-insensitive")
+	}
+}
+
+func TestCredentialsOf(t *testing.T) {
+	for headers, want := range map[[2]string]string{
+		{"X-EKSphemeral-Key", "eksp_123"}:    "eksp_123",
+		{"x-eksphemeral-key", " eksp_123 "}:  "eksp_123",
+		{"Authorization", "Bearer eksp_123"}: "eksp_123",
+		{"Authorization", "Basic amFuZTo="}:  "",
+		{"X-EKSphemeral-User", "jane"}:       "",
+	} {
+		request := events.APIGatewayProxyRequest{Headers: map[string]string{headers[0]: headers[1]}}
+		if got := credentialsOf(request); got != want {
+			t.Errorf("credentialsOf() with %v: %v = %q, want %q", headers[0], headers[1], got, want)
+		}
+	}
+}
+
+// echoCaller responds with who the caller is
+func echoCaller(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	caller := CallerOf(request)
+	if IsInternal(request) {
+		caller = "internal"
+	}
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: caller}, nil
+}
+
+func TestAuthenticated(t *testing.T) {
+	defer os.Setenv("AUTH_MODE", os.Getenv("AUTH_MODE"))
+	handler := Authenticated(echoCaller)
+	// whatever callers claim about themselves doesn't count:
+	forged := Invocation{APIGatewayProxyRequest: from("root@example.com")}
+	forged.RequestContext.Authorizer["internal"] = true
+	forged.Headers = map[string]string{"X-EKSphemeral-User": "root@example.com"}
+
+	os.Setenv("AUTH_MODE", "none")
+	resp, _ := handler(forged)
+	if resp.StatusCode != http.StatusOK || resp.Body != "" {
+		t.Errorf("without authentication, got %v %q, want no caller", resp.StatusCode, resp.Body)
+	}
+
+	os.Setenv("AUTH_MODE", "apikey")
+	resp, _ = handler(forged)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without API key, got %v, want %v", resp.StatusCode, http.StatusUnauthorized)
+	}
+	// other functions of the control plane don't need an API key:
+	resp, _ = handler(Invocation{Internal: "eksphemeral/scheduler"})
+	if resp.StatusCode != http.StatusOK || resp.Body != "internal" {
+		t.Errorf("for an internal invocation, got %v %q, want it to get through", resp.StatusCode, resp.Body)
+	}
+}
@@ -0,0 +1,205 @@
+package eksp
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"text/template"
+)
+
+const (
+	// defaultInstanceType is the EC2 instance type of
+	// the worker nodes, unless the cluster spec says otherwise
+	defaultInstanceType = "m5.large"
+	// defaultNodeGroupName is the name of the nodegroup
+	// made up of the worker nodes
+	defaultNodeGroupName = "ng-1"
+	// clusterIDTag is the tag carrying the cluster ID,
+	// put on all AWS resources of the cluster
+	clusterIDTag = ReservedTagPrefix + "cluster-id"
+)
+
+// defaultIAMAddons are the IAM add-on policies granted to the worker
+// nodes, unless the cluster spec says otherwise, matching the
+// --full-ecr-access and --appmesh-access eksctl flags
+var defaultIAMAddons = []string{"imageBuilder", "appMesh"}
+
+// ProvisioningPrefix is the prefix in the metadata bucket under which
+// we store the eksctl ClusterConfig manifests for the provisioner to
+// pick up, since they can exceed what fits into a task override
+const ProvisioningPrefix = "provisioning/"
+
+// ClusterConfigKey returns the key of the eksctl
+// ClusterConfig manifest of the cluster with the given ID
+func ClusterConfigKey(clusterID string) string {
+	return ProvisioningPrefix + clusterID + ".yaml"
+}
+
+// clusterConfigTemplate is an eksctl ClusterConfig manifest,
+// see https://eksctl.io/usage/schema/ for the schema
+var clusterConfigTemplate = template.Must(template.New("clusterconfig").Funcs(template.FuncMap{
+	"quote": strconv.Quote,
+}).Parse(`apiVersion: eksctl.io/v1alpha5
+kind: ClusterConfig
+
+metadata:
+  name: {{quote .Name}}
+  region: {{quote .Region}}
+  version: {{quote .KubeVersion}}
+{{- if .Tags}}
+  tags:
+{{- range .Tags}}
+    {{quote .Key}}: {{quote .Value}}
+{{- end}}
+{{- end}}
+
+nodeGroups:
+{{- range .NodeGroups}}
+  - name: {{quote .Name}}
+{{- if .Spot}}
+    instancesDistribution:
+      instanceTypes: [{{quote .InstanceType}}]
+      onDemandBaseCapacity: 0
+      onDemandPercentageAboveBaseCapacity: 0
+{{- else}}
+    instanceType: {{quote .InstanceType}}
+{{- end}}
+    minSize: {{.MinSize}}
+    maxSize: {{.MaxSize}}
+    desiredCapacity: {{.DesiredCapacity}}
+{{- if .VolumeSize}}
+    volumeSize: {{.VolumeSize}}
+{{- end}}
+{{- if .Labels}}
+    labels:
+{{- range .Labels}}
+      {{quote .Key}}: {{quote .Value}}
+{{- end}}
+{{- end}}
+{{- if .Taints}}
+    taints:
+{{- range .Taints}}
+      {{quote .Key}}: {{quote .Value}}
+{{- end}}
+{{- end}}
+{{- if $.Tags}}
+    tags:
+{{- range $.Tags}}
+      {{quote .Key}}: {{quote .Value}}
+{{- end}}
+{{- end}}
+    iam:
+      withAddonPolicies:
+{{- range $.IAMAddons}}
+        {{.}}: true
+{{- end}}
+{{- end}}
+`))
+
+// Tag is a key/value pair, used to render tags in a stable order
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// SortedTags returns the key/value pairs ordered by key
+func SortedTags(m map[string]string) []Tag {
+	tags := []Tag{}
+	for k, v := range m {
+		tags = append(tags, Tag{k, v})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+	return tags
+}
+
+// nodeGroupConfig is the view on a nodegroup for rendering
+type nodeGroupConfig struct {
+	Name            string
+	InstanceType    string
+	MinSize         int
+	MaxSize         int
+	DesiredCapacity int
+	VolumeSize      int
+	Spot            bool
+	Labels          []Tag
+	Taints          []Tag
+}
+
+// clusterConfig is the view on the cluster spec for rendering,
+// with all defaults applied
+type clusterConfig struct {
+	Name        string
+	Region      string
+	KubeVersion string
+	Tags        []Tag
+	NodeGroups  []nodeGroupConfig
+	IAMAddons   []string
+}
+
+// RenderClusterConfig turns the cluster spec into an eksctl ClusterConfig
+// manifest, using the region given unless the cluster spec sets one
+func RenderClusterConfig(cs ClusterSpec, defaultRegion string) (string, error) {
+	cc := clusterConfig{
+		Name:        cs.Name,
+		Region:      cs.Region,
+		KubeVersion: cs.KubeVersion,
+		IAMAddons:   cs.IAMAddons,
+	}
+	if cc.Region == "" {
+		cc.Region = defaultRegion
+	}
+	tags := map[string]string{}
+	for k, v := range cs.Tags {
+		tags[k] = v
+	}
+	// labels become tags, so that AWS resources can be told apart by them:
+	for k, v := range cs.Labels {
+		tags[k] = v
+	}
+	if cs.ID != "" {
+		tags[clusterIDTag] = cs.ID
+	}
+	cc.Tags = SortedTags(tags)
+	if len(cc.IAMAddons) == 0 {
+		cc.IAMAddons = defaultIAMAddons
+	}
+	instanceType := cs.InstanceType
+	if instanceType == "" {
+		instanceType = defaultInstanceType
+	}
+	ngs := cs.NodeGroups
+	if len(ngs) == 0 {
+		ngs = []NodeGroup{
+			{
+				Name:            defaultNodeGroupName,
+				DesiredCapacity: cs.NumWorkers,
+			},
+		}
+	}
+	for _, ng := range ngs {
+		ngc := nodeGroupConfig{
+			Name:            ng.Name,
+			InstanceType:    ng.InstanceType,
+			MinSize:         ng.MinSize,
+			MaxSize:         MaxSizeOf(ng),
+			DesiredCapacity: ng.DesiredCapacity,
+			VolumeSize:      ng.VolumeSize,
+			Spot:            ng.Spot,
+			Labels:          SortedTags(ng.Labels),
+			Taints:          SortedTags(ng.Taints),
+		}
+		if ngc.InstanceType == "" {
+			ngc.InstanceType = instanceType
+		}
+		if ngc.MinSize == 0 {
+			ngc.MinSize = ngc.DesiredCapacity
+		}
+		cc.NodeGroups = append(cc.NodeGroups, ngc)
+	}
+	var buf bytes.Buffer
+	err := clusterConfigTemplate.Execute(&buf, cc)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,124 @@
+package eksp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func ExampleRenderClusterConfig() {
+	cs := ClusterSpec{
+		ID:          "e-abc123",
+		Name:        "mh9-eksp",
+		NumWorkers:  3,
+		KubeVersion: "1.14",
+		Tags:        map[string]string{"team": "payments"},
+	}
+	manifest, err := RenderClusterConfig(cs, "us-east-2")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Print(manifest)
+	// Output:
+	// apiVersion: eksctl.io/v1alpha5
+	// kind: ClusterConfig
+	//
+	// metadata:
+	//   name: "mh9-eksp"
+	//   region: "us-east-2"
+	//   version: "1.14"
+	//   tags:
+	//     "eksphemeral.io/cluster-id": "e-abc123"
+	//     "team": "payments"
+	//
+	// nodeGroups:
+	//   - name: "ng-1"
+	//     instanceType: "m5.large"
+	//     minSize: 3
+	//     maxSize: 3
+	//     desiredCapacity: 3
+	//     tags:
+	//       "eksphemeral.io/cluster-id": "e-abc123"
+	//       "team": "payments"
+	//     iam:
+	//       withAddonPolicies:
+	//         imageBuilder: true
+	//         appMesh: true
+}
+
+func TestRenderClusterConfigOverrides(t *testing.T) {
+	cs := ClusterSpec{
+		Name:         "mh9-eksp",
+		NumWorkers:   1,
+		KubeVersion:  "1.13",
+		Region:       "eu-west-1",
+		InstanceType: "t3.xlarge",
+		IAMAddons:    []string{"autoScaler"},
+	}
+	manifest, err := RenderClusterConfig(cs, "us-east-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`region: "eu-west-1"`, `instanceType: "t3.xlarge"`, "autoScaler: true"} {
+		if !strings.Contains(manifest, want) {
+			t.Errorf("manifest lacks %v:\n%v", want, manifest)
+		}
+	}
+	for _, unwanted := range []string{"us-east-2", "appMesh", "tags:"} {
+		if strings.Contains(manifest, unwanted) {
+			t.Errorf("manifest contains %v:\n%v", unwanted, manifest)
+		}
+	}
+}
+
+func TestRenderClusterConfigNodeGroups(t *testing.T) {
+	cs := ClusterSpec{
+		Name:         "mh9-eksp",
+		KubeVersion:  "1.14",
+		InstanceType: "t3.large",
+		NodeGroups: []NodeGroup{
+			{Name: "system", DesiredCapacity: 1},
+			{
+				Name:            "batch",
+				InstanceType:    "c5.2xlarge",
+				DesiredCapacity: 2,
+				MaxSize:         6,
+				Spot:            true,
+				VolumeSize:      200,
+				Labels:          map[string]string{"workload": "batch"},
+				Taints:          map[string]string{"batch": "true:NoSchedule"},
+			},
+		},
+	}
+	manifest, err := RenderClusterConfig(cs, "us-east-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := strings.Index(manifest, `- name: "batch"`)
+	if i < 0 {
+		t.Fatalf("manifest lacks the batch nodegroup:\n%v", manifest)
+	}
+	system, batch := manifest[:i], manifest[i:]
+	for _, want := range []string{`instanceType: "t3.large"`, "minSize: 1", "maxSize: 1"} {
+		if !strings.Contains(system, want) {
+			t.Errorf("system nodegroup lacks %v:\n%v", want, system)
+		}
+	}
+	if strings.Contains(system, "instancesDistribution") {
+		t.Errorf("system nodegroup uses spot instances:\n%v", system)
+	}
+	for _, want := range []string{
+		`instanceTypes: ["c5.2xlarge"]`,
+		"instancesDistribution:",
+		"minSize: 2",
+		"maxSize: 6",
+		"volumeSize: 200",
+		`"workload": "batch"`,
+		`"batch": "true:NoSchedule"`,
+	} {
+		if !strings.Contains(batch, want) {
+			t.Errorf("batch nodegroup lacks %v:\n%v", want, batch)
+		}
+	}
+}
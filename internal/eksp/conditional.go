@@ -0,0 +1,102 @@
+package eksp
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// isPreconditionFailed returns true if the error is S3 telling us that
+// a conditional write lost, either because the condition didn't hold
+// or because a concurrent conditional write to the object won
+func isPreconditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && (aerr.Code() == "PreconditionFailed" || aerr.Code() == "ConditionalRequestConflict")
+}
+
+// FetchObject returns the content of the object
+// with the given key along with its ETag
+func FetchObject(bucket, key string) ([]byte, string, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	svc := s3.New(cfg)
+	req := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	resp, err := req.Send(context.TODO())
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return content, aws.StringValue(resp.ETag), nil
+}
+
+// PutObjectIfAbsent stores the content under the given key unless
+// there's an object with the key already, returning false in this case.
+// Of concurrent calls for the same key exactly one succeeds, which
+// makes the object a reservation.
+func PutObjectIfAbsent(bucket, key string, content []byte) (bool, error) {
+	return putObjectIf(bucket, key, content, "If-None-Match", "*")
+}
+
+// ReplaceObjectIfUnchanged stores the content under the given key if the
+// object still has the given ETag, returning false if it's been changed
+// in the meantime, so that of concurrent replacements only one succeeds
+func ReplaceObjectIfUnchanged(bucket, key, etag string, content []byte) (bool, error) {
+	return putObjectIf(bucket, key, content, "If-Match", etag)
+}
+
+// DeleteObject deletes the object with the given key,
+// which is a no-op if there's no such object
+func DeleteObject(bucket, key string) error {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	svc := s3.New(cfg)
+	req := svc.DeleteObjectRequest(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	_, err = req.Send(context.TODO())
+	return err
+}
+
+// putObjectIf stores the content under the given key
+// if the condition the HTTP header sets out holds
+func putObjectIf(bucket, key string, content []byte, header, value string) (bool, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return false, err
+	}
+	svc := s3.New(cfg)
+	req := svc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String("application/json"),
+	})
+	req.Handlers.Build.PushBack(func(r *aws.Request) {
+		r.HTTPRequest.Header.Set(header, value)
+	})
+	_, err = req.Send(context.TODO())
+	if err != nil {
+		if isPreconditionFailed(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
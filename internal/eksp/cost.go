@@ -0,0 +1,248 @@
+package eksp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// pricesConfigKey is the key of the object in the metadata bucket holding
+// the price table, uploaded at install time. Its prices take precedence
+// over the bundled ones.
+const pricesConfigKey = "config/prices.json"
+
+// PriceTable is what the parts of a cluster cost per hour
+type PriceTable struct {
+	// Currency is the currency of the prices, such as USD
+	Currency string `json:"currency,omitempty"`
+	// ControlPlane is the hourly rate of the EKS control plane
+	ControlPlane float64 `json:"controlplane,omitempty"`
+	// SpotFactor is what spot instances cost compared
+	// to on-demand ones, for example 0.35
+	SpotFactor float64 `json:"spotfactor,omitempty"`
+	// Instances are the on-demand hourly rates of the worker
+	// nodes, keyed by EC2 instance type
+	Instances map[string]float64 `json:"instances,omitempty"`
+}
+
+// bundledPrices are the on-demand Linux prices in us-east-1, with the
+// spot factor being a rough average of the savings of spot instances
+var bundledPrices = PriceTable{
+	Currency:     "USD",
+	ControlPlane: 0.10,
+	SpotFactor:   0.35,
+	Instances: map[string]float64{
+		"t3.small":    0.0208,
+		"t3.medium":   0.0416,
+		"t3.large":    0.0832,
+		"t3.xlarge":   0.1664,
+		"t3.2xlarge":  0.3328,
+		"m5.large":    0.096,
+		"m5.xlarge":   0.192,
+		"m5.2xlarge":  0.384,
+		"m5.4xlarge":  0.768,
+		"m5a.large":   0.086,
+		"m5a.xlarge":  0.172,
+		"m4.large":    0.10,
+		"m4.xlarge":   0.20,
+		"c5.large":    0.085,
+		"c5.xlarge":   0.17,
+		"c5.2xlarge":  0.34,
+		"c5.4xlarge":  0.68,
+		"r5.large":    0.126,
+		"r5.xlarge":   0.252,
+		"r5.2xlarge":  0.504,
+		"g4dn.xlarge": 0.526,
+		"p3.2xlarge":  3.06,
+	},
+}
+
+// CostEstimate is what a cluster is estimated to cost, covering the
+// control plane and the worker nodes but not, say, load balancers
+type CostEstimate struct {
+	// Currency is the currency of the amounts, such as USD
+	Currency string `json:"currency"`
+	// HourlyRate is what the cluster costs per hour right now
+	HourlyRate float64 `json:"hourly"`
+	// SoFar is what the cluster cost since it was launched
+	SoFar float64 `json:"sofar"`
+	// Projected is what the cluster will have cost once it expires
+	Projected float64 `json:"projected"`
+	// Unpriced are the instance types of worker nodes there's
+	// no price for, which the estimate doesn't cover
+	Unpriced []string `json:"unpriced,omitempty"`
+}
+
+// FetchPriceTable returns the bundled price table,
+// with the prices the admins supplied, if any, taking precedence
+func FetchPriceTable(clusterbucket string) (PriceTable, error) {
+	pt := PriceTable{
+		Currency:     bundledPrices.Currency,
+		ControlPlane: bundledPrices.ControlPlane,
+		SpotFactor:   bundledPrices.SpotFactor,
+		Instances:    map[string]float64{},
+	}
+	for instancetype, rate := range bundledPrices.Instances {
+		pt.Instances[instancetype] = rate
+	}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return pt, err
+	}
+	downloader := s3manager.NewDownloader(cfg)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	_, err = downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(pricesConfigKey),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return pt, nil
+		}
+		return pt, err
+	}
+	supplied := PriceTable{}
+	err = json.Unmarshal(buf.Bytes(), &supplied)
+	if err != nil {
+		return pt, err
+	}
+	if supplied.Currency != "" {
+		pt.Currency = supplied.Currency
+	}
+	if supplied.ControlPlane > 0 {
+		pt.ControlPlane = supplied.ControlPlane
+	}
+	if supplied.SpotFactor > 0 {
+		pt.SpotFactor = supplied.SpotFactor
+	}
+	for instancetype, rate := range supplied.Instances {
+		pt.Instances[instancetype] = rate
+	}
+	return pt, nil
+}
+
+// workerRate returns the hourly rate of the worker nodes of the
+// cluster, along with the instance types there's no price for
+func (pt PriceTable) workerRate(cs ClusterSpec) (float64, []string) {
+	rate, unpriced := 0.0, []string{}
+	price := func(instancetype string, spot bool, count int) {
+		if instancetype == "" {
+			instancetype = "m5.large" // the default, see ClusterSpec
+		}
+		instancerate, ok := pt.Instances[instancetype]
+		if !ok {
+			for _, t := range unpriced {
+				if t == instancetype {
+					return
+				}
+			}
+			unpriced = append(unpriced, instancetype)
+			return
+		}
+		if spot {
+			instancerate *= pt.SpotFactor
+		}
+		rate += instancerate * float64(count)
+	}
+	if len(cs.NodeGroups) == 0 {
+		price(cs.InstanceType, false, cs.NumWorkers)
+	}
+	for _, ng := range cs.NodeGroups {
+		instancetype := ng.InstanceType
+		if instancetype == "" {
+			instancetype = cs.InstanceType
+		}
+		price(instancetype, ng.Spot, ng.DesiredCapacity)
+	}
+	return rate, unpriced
+}
+
+// TimestampOf returns the point in time of the UTC timestamp in
+// seconds, and false if there's no (valid) timestamp
+func TimestampOf(ts string) (time.Time, bool) {
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0), true
+}
+
+// EstimateCost returns what the cluster is estimated to have cost so far,
+// from launch until now, and what it will have cost once it expires. For
+// hibernated clusters, only the control plane counts from hibernation on.
+// Earlier hibernations and what the cluster has used besides the control
+// plane and the worker nodes are not taken into account.
+func EstimateCost(pt PriceTable, cs ClusterSpec, now time.Time) CostEstimate {
+	workers, unpriced := pt.workerRate(cs)
+	ce := CostEstimate{
+		Currency: pt.Currency,
+		Unpriced: unpriced,
+	}
+	launched, ok := TimestampOf(cs.LaunchTime)
+	if !ok {
+		launched, ok = TimestampOf(cs.CreationTime)
+	}
+	if !ok || launched.After(now) {
+		launched = now
+	}
+	until := now
+	if teardown, ok := TimestampOf(cs.TeardownTime); ok && teardown.Before(now) {
+		until = teardown
+	}
+	hours := func(from, to time.Time) float64 {
+		if to.Before(from) {
+			return 0
+		}
+		return to.Sub(from).Hours()
+	}
+	ce.SoFar = hours(launched, until) * pt.ControlPlane
+	ce.HourlyRate = pt.ControlPlane + workers
+	if hibernated, ok := TimestampOf(cs.HibernationTime); ok && cs.Phase == PhaseHibernated && hibernated.Before(until) {
+		ce.SoFar += hours(launched, hibernated) * workers
+		ce.HourlyRate = pt.ControlPlane
+	} else {
+		ce.SoFar += hours(launched, until) * workers
+	}
+	ce.Projected = ce.SoFar
+	if cs.TeardownTime == "" {
+		expiry := now.Add(time.Duration(cs.TTL) * time.Minute)
+		if created, ok := TimestampOf(cs.CreationTime); ok {
+			expiry = created.Add(time.Duration(cs.Timeout) * time.Minute)
+		}
+		ce.Projected += hours(now, expiry) * ce.HourlyRate
+	}
+	return ce
+}
+
+// RenderAmount renders the amount in the currency, such as $1.23 or 1.23 EUR
+func RenderAmount(amount float64, currency string) string {
+	if currency == "USD" {
+		return fmt.Sprintf("$%.2f", amount)
+	}
+	return fmt.Sprintf("%.2f %v", amount, currency)
+}
+
+// CostNote returns the paragraph of the mails to the owners telling
+// them what the cluster costs, or nothing if we can't tell
+func CostNote(clusterbucket string, cs ClusterSpec) string {
+	pt, err := FetchPriceTable(clusterbucket)
+	if err != nil {
+		fmt.Printf("Can't look up the prices of cluster %v: %v\n", cs.ID, err)
+		return ""
+	}
+	ce := EstimateCost(pt, cs, time.Now())
+	note := fmt.Sprintf("\n\nThe cluster costs an estimated %v per hour, it has cost %v so far and will have cost %v once it expires.",
+		RenderAmount(ce.HourlyRate, ce.Currency), RenderAmount(ce.SoFar, ce.Currency), RenderAmount(ce.Projected, ce.Currency))
+	if len(ce.Unpriced) > 0 {
+		note += fmt.Sprintf(" This doesn't cover the worker nodes of instance type %v, for lack of prices.", strings.Join(ce.Unpriced, ", "))
+	}
+	return note
+}
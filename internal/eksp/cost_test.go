@@ -0,0 +1,98 @@
+package eksp
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEstimateCost(t *testing.T) {
+	pt := PriceTable{
+		Currency:     "USD",
+		ControlPlane: 0.10,
+		SpotFactor:   0.5,
+		Instances:    map[string]float64{"m5.large": 0.10, "c5.large": 0.20},
+	}
+	now := time.Unix(1571500000, 0)
+	ago := func(hours int) string {
+		return fmt.Sprintf("%v", now.Add(-time.Duration(hours)*time.Hour).Unix())
+	}
+	tests := []struct {
+		name     string
+		cs       ClusterSpec
+		hourly   float64
+		sofar    float64
+		proj     float64
+		unpriced []string
+	}{
+		{"running", ClusterSpec{NumWorkers: 2, Timeout: 240, LaunchTime: ago(2), CreationTime: ago(2)}, 0.30, 0.60, 1.20, []string{}},
+		{"prolonged", ClusterSpec{NumWorkers: 2, Timeout: 60, LaunchTime: ago(2), CreationTime: ago(1)}, 0.30, 0.60, 0.60, []string{}},
+		{"not launched yet", ClusterSpec{NumWorkers: 2, TTL: 60}, 0.30, 0, 0.30, []string{}},
+		{"nodegroups", ClusterSpec{
+			InstanceType: "c5.large",
+			Timeout:      60,
+			LaunchTime:   ago(1),
+			CreationTime: ago(1),
+			NodeGroups: []NodeGroup{
+				{Name: "ng-1", DesiredCapacity: 1},
+				{Name: "ng-2", DesiredCapacity: 2, InstanceType: "m5.large", Spot: true},
+			},
+		}, 0.40, 0.40, 0.40, []string{}},
+		{"unpriced", ClusterSpec{
+			InstanceType: "p3.2xlarge",
+			Timeout:      60,
+			LaunchTime:   ago(1),
+			CreationTime: ago(1),
+			NodeGroups: []NodeGroup{
+				{Name: "ng-1", DesiredCapacity: 1},
+				{Name: "ng-2", DesiredCapacity: 1, InstanceType: "p3.2xlarge"},
+				{Name: "ng-3", DesiredCapacity: 1, InstanceType: "m5.large"},
+			},
+		}, 0.20, 0.20, 0.20, []string{"p3.2xlarge"}},
+		{"hibernated", ClusterSpec{NumWorkers: 2, Timeout: 300, LaunchTime: ago(3), CreationTime: ago(3), HibernationTime: ago(1), Phase: PhaseHibernated}, 0.10, 0.70, 0.90, []string{}},
+		{"torn down", ClusterSpec{NumWorkers: 2, Timeout: 240, LaunchTime: ago(3), CreationTime: ago(3), TeardownTime: ago(1)}, 0.30, 0.60, 0.60, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ce := EstimateCost(pt, tt.cs, now)
+			amounts := []struct {
+				name      string
+				got, want float64
+			}{
+				{"hourly rate", ce.HourlyRate, tt.hourly},
+				{"cost so far", ce.SoFar, tt.sofar},
+				{"projected cost", ce.Projected, tt.proj},
+			}
+			for _, a := range amounts {
+				if math.Abs(a.got-a.want) > 1e-9 {
+					t.Errorf("%v is %v, want %v", a.name, a.got, a.want)
+				}
+			}
+			if !reflect.DeepEqual(ce.Unpriced, tt.unpriced) {
+				t.Errorf("unpriced are %v, want %v", ce.Unpriced, tt.unpriced)
+			}
+			if ce.Currency != "USD" {
+				t.Errorf("currency is %v, want USD", ce.Currency)
+			}
+		})
+	}
+}
+
+func TestRenderAmount(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		rendered string
+	}{
+		{1.234, "USD", "$1.23"},
+		{0, "USD", "$0.00"},
+		{1.235, "EUR", "1.24 EUR"},
+	}
+	for _, tt := range tests {
+		if got := RenderAmount(tt.amount, tt.currency); got != tt.rendered {
+			t.Errorf("RenderAmount(%v, %v) = %v, want %v", tt.amount, tt.currency, got, tt.rendered)
+		}
+	}
+}
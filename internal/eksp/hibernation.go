@@ -0,0 +1,38 @@
+package eksp
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// HibernationTimeout is the hibernation reason of
+	// clusters hibernated since their timeout expired
+	HibernationTimeout = "timeout expired"
+	// HibernationSchedule is the hibernation reason of
+	// clusters hibernated outside of their schedule
+	HibernationSchedule = "outside schedule"
+)
+
+// WakeUp returns the spec of the hibernated cluster once its nodegroups
+// are restored. If it was hibernated since its timeout expired, the
+// timeout starts over, otherwise it keeps its original creation time,
+// so that a schedule doesn't keep the cluster around forever.
+func WakeUp(cs ClusterSpec, now time.Time) ClusterSpec {
+	reason := cs.HibernationReason
+	cs.Phase = ""
+	cs.HibernationTime, cs.HibernatedNodeGroups, cs.HibernationReason = "", nil, ""
+	cs.ExpiryWarningTime = ""
+	ct, err := strconv.ParseInt(cs.CreationTime, 10, 64)
+	if reason == HibernationTimeout || err != nil {
+		cs.TTL = cs.Timeout
+		cs.CreationTime = fmt.Sprintf("%v", now.Unix())
+		return cs
+	}
+	cs.TTL = cs.Timeout - int(now.Sub(time.Unix(ct, 0)).Minutes())
+	if cs.TTL < 0 {
+		cs.TTL = 0
+	}
+	return cs
+}
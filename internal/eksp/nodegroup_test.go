@@ -0,0 +1,84 @@
+package eksp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateNodeGroups(t *testing.T) {
+	workers := NodeGroup{Name: "workers", InstanceType: "m5.xlarge", DesiredCapacity: 2, MaxSize: 4}
+	if ferrs := validateNodeGroups(nil); len(ferrs) != 0 {
+		t.Errorf("validateNodeGroups(nil) = %v, want no issues", ferrs)
+	}
+	if ferrs := validateNodeGroups([]NodeGroup{workers}); len(ferrs) != 0 {
+		t.Errorf("validateNodeGroups(%v) = %v, want no issues", workers, ferrs)
+	}
+
+	spot := NodeGroup{
+		Name:            "workers",
+		DesiredCapacity: 1,
+		MinSize:         2,
+		Taints:          map[string]string{"spot": "true"},
+		VolumeSize:      -1,
+	}
+	ferrs := validateNodeGroups([]NodeGroup{workers, spot})
+	got := map[string]string{}
+	for _, ferr := range ferrs {
+		got[ferr.Field] = ferr.Message
+	}
+	want := []string{"nodegroups[1].name", "nodegroups[1].minsize", "nodegroups[1].taints", "nodegroups[1].volumesize"}
+	for _, field := range want {
+		if _, ok := got[field]; !ok {
+			t.Errorf("no issue with %v, want one: %v", field, ferrs)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got issues with %v, want only %v", got, want)
+	}
+	if msg := got["nodegroups[1].name"]; !strings.Contains(msg, "unique") {
+		t.Errorf("issue with the duplicate name is %q, want it to mention uniqueness", msg)
+	}
+
+	if msg := got["nodegroups[1].volumesize"]; !strings.Contains(msg, "0 for the eksctl default") {
+		t.Errorf("issue with the volume size is %q, want it to mention that 0 is fine, too", msg)
+	}
+	for _, size := range []int{0, 1, maxVolumeSize} {
+		sized := NodeGroup{Name: "sized", DesiredCapacity: 1, VolumeSize: size}
+		if ferrs := validateNodeGroups([]NodeGroup{sized}); len(ferrs) != 0 {
+			t.Errorf("validateNodeGroups() with a volume size of %d = %v, want no issues", size, ferrs)
+		}
+	}
+
+	// the maximum sizes count towards the worker limit, not just the desired capacity:
+	big := NodeGroup{Name: "big", DesiredCapacity: 1, MaxSize: maxWorkers}
+	ferrs = validateNodeGroups([]NodeGroup{workers, big})
+	if len(ferrs) != 1 || ferrs[0].Field != "nodegroups" {
+		t.Errorf("validateNodeGroups() = %v, want an issue with the total size", ferrs)
+	}
+}
+
+func TestNumWorkersOf(t *testing.T) {
+	cs := ClusterSpec{NumWorkers: 3}
+	if n := NumWorkersOf(cs); n != 3 {
+		t.Errorf("NumWorkersOf() without nodegroups = %d, want 3", n)
+	}
+	cs.NodeGroups = []NodeGroup{
+		{Name: "system", DesiredCapacity: 1},
+		{Name: "workers", DesiredCapacity: 4, MaxSize: 8},
+	}
+	if n := NumWorkersOf(cs); n != 5 {
+		t.Errorf("NumWorkersOf() with nodegroups = %d, want 5", n)
+	}
+	if n := MaxSizeOf(cs.NodeGroups[0]); n != 1 {
+		t.Errorf("MaxSizeOf(%v) = %d, want the desired capacity", cs.NodeGroups[0], n)
+	}
+}
+
+func TestSortedTags(t *testing.T) {
+	got := SortedTags(map[string]string{"team": "payments", "purpose": "ci", "env": "dev"})
+	want := []Tag{{"env", "dev"}, {"purpose", "ci"}, {"team", "payments"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SortedTags() = %v, want %v", got, want)
+	}
+}
@@ -1,9 +1,10 @@
-package main
+package eksp
 
 import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/external"
@@ -51,3 +52,35 @@ func informOwner(tomail, subject, body string) error {
 	}
 	return nil
 }
+
+// OwnersOf returns the email addresses of the owner
+// and the co-owners of the cluster, each one once
+func OwnersOf(cs ClusterSpec) []string {
+	owners := []string{}
+	seen := map[string]bool{}
+	for _, owner := range append([]string{cs.Owner}, cs.CoOwners...) {
+		if owner == "" || seen[strings.ToLower(owner)] {
+			continue
+		}
+		seen[strings.ToLower(owner)] = true
+		owners = append(owners, owner)
+	}
+	return owners
+}
+
+// InformOwners sends the mail to each owner of the cluster, recording
+// every notification in the audit log. A failure to reach one owner
+// doesn't keep us from informing the others, and the last one is returned.
+func InformOwners(clusterbucket, actor string, cs ClusterSpec, subject, body string) error {
+	var lasterr error
+	for _, tomail := range OwnersOf(cs) {
+		err := informOwner(tomail, subject, body)
+		if err != nil {
+			fmt.Printf("Can't inform %v about cluster %v: %v\n", tomail, cs.ID, err)
+			lasterr = err
+			continue
+		}
+		AuditNotification(clusterbucket, actor, cs.ID, tomail, subject)
+	}
+	return lasterr
+}
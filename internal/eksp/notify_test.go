@@ -0,0 +1,26 @@
+package eksp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOwnersOf(t *testing.T) {
+	cs := ClusterSpec{
+		Owner:    "jane@example.com",
+		CoOwners: []string{"joe@example.com", "Jane@Example.com", "", "bob@example.com", "joe@example.com"},
+	}
+	want := []string{"jane@example.com", "joe@example.com", "bob@example.com"}
+	if got := OwnersOf(cs); !reflect.DeepEqual(got, want) {
+		t.Errorf("OwnersOf() = %v, want %v", got, want)
+	}
+	// clusters created without owner might still have co-owners:
+	cs.Owner = ""
+	want = []string{"joe@example.com", "Jane@Example.com", "bob@example.com"}
+	if got := OwnersOf(cs); !reflect.DeepEqual(got, want) {
+		t.Errorf("OwnersOf() without owner = %v, want %v", got, want)
+	}
+	if got := OwnersOf(ClusterSpec{}); len(got) != 0 {
+		t.Errorf("OwnersOf() of an unowned cluster = %v, want nobody", got)
+	}
+}
@@ -0,0 +1,102 @@
+package eksp
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+)
+
+// TemplatesPrefix is the prefix in the metadata bucket
+// under which the templates are kept
+const TemplatesPrefix = "templates/"
+
+// FetchTemplate returns the template with the given name,
+// or nil if there's no such template
+func FetchTemplate(clusterbucket, name string) (*Template, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return nil, err
+	}
+	downloader := s3manager.NewDownloader(cfg)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	_, err = downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(TemplatesPrefix + name + ".json"),
+	})
+	if err != nil {
+		if IsNoSuchKey(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	t := Template{}
+	err = json.Unmarshal(buf.Bytes(), &t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// SchedulesPrefix is the prefix in the metadata bucket
+// under which the creation schedules are kept
+const SchedulesPrefix = "schedules/"
+
+// ListSchedules returns all creation schedules in the given bucket
+func ListSchedules(clusterbucket string) ([]CreationSchedule, error) {
+	scheds := []CreationSchedule{}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return scheds, err
+	}
+	svc := s3.New(cfg)
+	req := svc.ListObjectsRequest(&s3.ListObjectsInput{
+		Bucket: aws.String(clusterbucket),
+		Prefix: aws.String(SchedulesPrefix),
+	})
+	resp, err := req.Send(context.TODO())
+	if err != nil {
+		return scheds, err
+	}
+	downloader := s3manager.NewDownloader(cfg)
+	for _, obj := range resp.Contents {
+		buf := aws.NewWriteAtBuffer([]byte{})
+		_, err = downloader.Download(buf, &s3.GetObjectInput{
+			Bucket: aws.String(clusterbucket),
+			Key:    obj.Key,
+		})
+		if err != nil {
+			return scheds, err
+		}
+		sched := CreationSchedule{}
+		err = json.Unmarshal(buf.Bytes(), &sched)
+		if err != nil {
+			return scheds, err
+		}
+		scheds = append(scheds, sched)
+	}
+	return scheds, nil
+}
+
+// StoreSchedule stores the creation schedule in the given bucket
+func StoreSchedule(clusterbucket string, sched CreationSchedule) error {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	schedjson, err := json.Marshal(sched)
+	if err != nil {
+		return err
+	}
+	uploader := s3manager.NewUploader(cfg)
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(SchedulesPrefix + sched.ID + ".json"),
+		Body:   strings.NewReader(string(schedjson)),
+	})
+	return err
+}
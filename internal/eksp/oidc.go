@@ -0,0 +1,246 @@
+package eksp
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksTTL is how long we keep the signing keys of the issuer around,
+// across invocations of the function, before fetching them again
+const jwksTTL = 15 * time.Minute
+
+// jwksMinRefresh is how long we wait at least before fetching the
+// signing keys again if a token was signed with a key we don't know
+const jwksMinRefresh = 1 * time.Minute
+
+// Claims are the claims of an ID token
+type Claims map[string]interface{}
+
+// Identity is who the caller is as per the claims of their ID token
+type Identity struct {
+	// User is the email address of the caller
+	User string
+	// Teams are the teams, that is, groups, the caller is a member of
+	Teams []string
+	// Admin is true if the caller is a member of the admin group
+	Admin bool
+}
+
+// JSONWebKey is one of the signing keys of the issuer, see RFC 7517
+type JSONWebKey struct {
+	KeyID   string `json:"kid"`
+	KeyType string `json:"kty"`
+	Modulus string `json:"n"`
+	Exp     string `json:"e"`
+}
+
+// jwks caches the signing keys of the issuer, by key ID
+var jwks = struct {
+	sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}{}
+
+// oidcIssuer returns the URL of the issuer we trust, without trailing slash
+func oidcIssuer() string {
+	return strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+}
+
+// oidcAudience returns the audience tokens have to be issued for,
+// that is, the client ID EKSphemeral is registered with at the issuer
+func oidcAudience() string {
+	return os.Getenv("OIDC_AUDIENCE")
+}
+
+// EnvOr returns the value of the environment variable, or the default if unset
+func EnvOr(name, defaultval string) string {
+	if val := os.Getenv(name); val != "" {
+		return val
+	}
+	return defaultval
+}
+
+// fetchJSON fetches the JSON document at the URL into v
+func fetchJSON(url string, v interface{}) error {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %v failed with status %v", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// signingKeys returns the signing keys of the issuer, from the cache unless
+// it's stale or refresh is set, in which case we discover them anew
+func signingKeys(refresh bool) (map[string]*rsa.PublicKey, error) {
+	jwks.Lock()
+	defer jwks.Unlock()
+	age := time.Since(jwks.fetched)
+	if jwks.keys != nil && age < jwksTTL && (!refresh || age < jwksMinRefresh) {
+		return jwks.keys, nil
+	}
+	discovery := struct {
+		JWKSURI string `json:"jwks_uri"`
+	}{}
+	err := fetchJSON(oidcIssuer()+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return nil, err
+	}
+	keyset := struct {
+		Keys []JSONWebKey `json:"keys"`
+	}{}
+	err = fetchJSON(discovery.JWKSURI, &keyset)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range keyset.Keys {
+		if jwk.KeyType != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.Modulus)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.Exp)
+		if err != nil {
+			return nil, err
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	fmt.Printf("DEBUG:: fetched %v signing keys from %v\n", len(keys), discovery.JWKSURI)
+	jwks.keys, jwks.fetched = keys, time.Now()
+	return keys, nil
+}
+
+// decodeSegment decodes a base64url-encoded segment of a token into v
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// verifyToken returns the claims of the ID token if the issuer signed it
+// for us and it's valid right now, and otherwise why it's not acceptable
+func verifyToken(token string) (Claims, error) {
+	if oidcIssuer() == "" || oidcAudience() == "" {
+		return nil, fmt.Errorf("the control plane has no OIDC issuer or audience configured")
+	}
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	header := struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}{}
+	err := decodeSegment(segments[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("unsupported signing algorithm %v", header.Algorithm)
+	}
+	keys, err := signingKeys(false)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keys[header.KeyID]
+	if !ok {
+		// the issuer may have rotated its keys since:
+		keys, err = signingKeys(true)
+		if err != nil {
+			return nil, err
+		}
+		key, ok = keys[header.KeyID]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %v", header.KeyID)
+		}
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+	hashed := sha256.Sum256([]byte(segments[0] + "." + segments[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+	claims := Claims{}
+	err = decodeSegment(segments[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != oidcIssuer() {
+		return nil, fmt.Errorf("token issued by %v rather than %v", iss, oidcIssuer())
+	}
+	// tokens the issuer signed for other clients are no good for us:
+	if !Contains(claims.values("aud"), oidcAudience()) {
+		return nil, fmt.Errorf("token not issued for %v", oidcAudience())
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); !ok || now > exp {
+		return nil, fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	return claims, nil
+}
+
+// values returns the claim as a list of strings, no matter
+// if it's a single string or a list of strings in the token
+func (c Claims) values(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		vals := []string{}
+		for _, val := range v {
+			if s, ok := val.(string); ok {
+				vals = append(vals, s)
+			}
+		}
+		return vals
+	}
+	return nil
+}
+
+// identityOf maps the claims of an ID token to who the caller is: the user
+// claim (OIDC_USER_CLAIM, by default email) is the user, the teams claim
+// (OIDC_TEAMS_CLAIM, by default groups) are the teams, and members of the
+// admin group (OIDC_ADMIN_GROUP, if set) are admins
+func identityOf(claims Claims) Identity {
+	id := Identity{
+		Teams: claims.values(EnvOr("OIDC_TEAMS_CLAIM", "groups")),
+	}
+	if users := claims.values(EnvOr("OIDC_USER_CLAIM", "email")); len(users) > 0 {
+		id.User = users[0]
+	}
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		id.User = ""
+	}
+	if admingroup := os.Getenv("OIDC_ADMIN_GROUP"); admingroup != "" {
+		id.Admin = Contains(id.Teams, admingroup)
+	}
+	return id
+}
@@ -0,0 +1,176 @@
+package eksp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIssuer is an OIDC issuer serving the discovery
+// document and the public key of its signing key
+func testIssuer(key *rsa.PrivateKey) *httptest.Server {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"jwks_uri": srv.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]JSONWebKey{"keys": {{
+			KeyID:   "key-1",
+			KeyType: "RSA",
+			Modulus: base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			Exp:     base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}}})
+	})
+	return srv
+}
+
+// signToken returns a token with the claims, signed with the key
+func signToken(t *testing.T, key *rsa.PrivateKey, alg, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hashed := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// setenv sets the environment variable and returns how to restore it
+func setenv(t *testing.T, name, value string) func() {
+	old, had := os.LookupEnv(name)
+	os.Setenv(name, value)
+	return func() {
+		if had {
+			os.Setenv(name, old)
+			return
+		}
+		os.Unsetenv(name)
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherkey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := testIssuer(key)
+	defer srv.Close()
+	defer setenv(t, "OIDC_ISSUER", srv.URL+"/")()
+	defer setenv(t, "OIDC_AUDIENCE", "eksphemeral")()
+	jwks.keys = nil
+	now := time.Now().Unix()
+	claims := func(change func(c map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   srv.URL,
+			"aud":   "eksphemeral",
+			"email": "jane@example.com",
+			"exp":   now + 300,
+		}
+		change(c)
+		return c
+	}
+	tests := []struct {
+		name   string
+		token  string
+		issuer string
+		aud    string
+		reason string
+	}{
+		{"valid", signToken(t, key, "RS256", "key-1", claims(func(c map[string]interface{}) {})), "", "", ""},
+		{"valid for several audiences", signToken(t, key, "RS256", "key-1", claims(func(c map[string]interface{}) {
+			c["aud"] = []string{"kubernetes", "eksphemeral"}
+		})), "", "", ""},
+		{"issuer with trailing slash", signToken(t, key, "RS256", "key-1", claims(func(c map[string]interface{}) {
+			c["iss"] = srv.URL + "/"
+		})), "", "", ""},
+		{"no issuer configured", signToken(t, key, "RS256", "key-1", claims(func(c map[string]interface{}) {})), "-", "", "no OIDC issuer or audience"},
+		{"no audience configured", signToken(t, key, "RS256", "key-1", claims(func(c map[string]interface{}) {})), "", "-", "no OIDC issuer or audience"},
+		{"malformed", "not-a-token", "", "", "malformed token"},
+		{"malformed header", "e30.e30.e30", "", "", "unsupported signing algorithm"},
+		{"unsigned", strings.Join([]string{
+			base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)),
+			base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"x"}`)),
+			"",
+		}, "."), "", "", "unsupported signing algorithm"},
+		{"unknown key", signToken(t, key, "RS256", "key-2", claims(func(c map[string]interface{}) {})), "", "", "unknown signing key"},
+		{"signed with other key", signToken(t, otherkey, "RS256", "key-1", claims(func(c map[string]interface{}) {})), "", "", "invalid token signature"},
+		{"other issuer", signToken(t, key, "RS256", "key-1", claims(func(c map[string]interface{}) {
+			c["iss"] = "https://accounts.example.com"
+		})), "", "", "token issued by"},
+		{"other audience", signToken(t, key, "RS256", "key-1", claims(func(c map[string]interface{}) {
+			c["aud"] = "kubernetes"
+		})), "", "", "token not issued for"},
+		{"no audience", signToken(t, key, "RS256", "key-1", claims(func(c map[string]interface{}) {
+			delete(c, "aud")
+		})), "", "", "token not issued for"},
+		{"expired", signToken(t, key, "RS256", "key-1", claims(func(c map[string]interface{}) {
+			c["exp"] = now - 60
+		})), "", "", "token expired"},
+		{"no expiry", signToken(t, key, "RS256", "key-1", claims(func(c map[string]interface{}) {
+			delete(c, "exp")
+		})), "", "", "token expired"},
+		{"not valid yet", signToken(t, key, "RS256", "key-1", claims(func(c map[string]interface{}) {
+			c["nbf"] = now + 60
+		})), "", "", "token not valid yet"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.issuer == "-" {
+				defer setenv(t, "OIDC_ISSUER", "")()
+			}
+			if tt.aud == "-" {
+				defer setenv(t, "OIDC_AUDIENCE", "")()
+			}
+			c, err := verifyToken(tt.token)
+			switch {
+			case tt.reason == "" && err != nil:
+				t.Errorf("token rejected: %v", err)
+			case tt.reason == "" && c["email"] != "jane@example.com":
+				t.Errorf("got claims %v", c)
+			case tt.reason != "" && err == nil:
+				t.Errorf("token accepted, want it rejected with %q", tt.reason)
+			case tt.reason != "" && !strings.Contains(err.Error(), tt.reason):
+				t.Errorf("token rejected with %q, want %q", err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestIdentityOf(t *testing.T) {
+	defer setenv(t, "OIDC_ADMIN_GROUP", "eksp-admins")()
+	tests := []struct {
+		name   string
+		claims Claims
+		id     Identity
+	}{
+		{"user", Claims{"email": "jane@example.com"}, Identity{User: "jane@example.com"}},
+		{"teams", Claims{"email": "jane@example.com", "groups": []interface{}{"payments", "checkout"}}, Identity{User: "jane@example.com", Teams: []string{"payments", "checkout"}}},
+		{"single team", Claims{"email": "jane@example.com", "groups": "payments"}, Identity{User: "jane@example.com", Teams: []string{"payments"}}},
+		{"admin", Claims{"email": "jane@example.com", "groups": []interface{}{"eksp-admins"}}, Identity{User: "jane@example.com", Teams: []string{"eksp-admins"}, Admin: true}},
+		{"unverified email", Claims{"email": "jane@example.com", "email_verified": false}, Identity{}},
+		{"verified email", Claims{"email": "jane@example.com", "email_verified": true}, Identity{User: "jane@example.com"}},
+	}
+	for _, tt := range tests {
+		if got := identityOf(tt.claims); !reflect.DeepEqual(got, tt.id) {
+			t.Errorf("%v: identityOf(%v) = %+v, want %+v", tt.name, tt.claims, got, tt.id)
+		}
+	}
+}
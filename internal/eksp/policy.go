@@ -0,0 +1,295 @@
+package eksp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// policiesConfigKey is the key of the object in the metadata bucket holding
+// the authorization policies, uploaded at install time. If there's no such
+// object, there are no policies and only access control applies.
+const policiesConfigKey = "config/policies.json"
+
+// adminRole is the role admins have, without being listed in the roles
+const adminRole = "admin"
+
+// policyAttributes are the attributes of a request conditions can refer
+// to, next to labels.KEY for the value of the label KEY of the cluster
+var policyAttributes = []string{
+	"numworkers", "timeout", "lifetime", "prolong",
+	"kubeversion", "region", "instancetype", "onexpiry", "owner", "team",
+}
+
+// Policies decide who may do what with which clusters, on top of access control
+type Policies struct {
+	// Roles are groups of users rules apply to, keyed by role name, with
+	// the members being email addresses, domains such as @example.com,
+	// or teams such as team:payments. Admins have the role admin anyway.
+	Roles map[string][]string `json:"roles,omitempty"`
+	// Rules deny requests, if any of them applies
+	Rules []PolicyRule `json:"rules,omitempty"`
+	// Uncapped are the roles of the callers the maximum
+	// timeout doesn't apply to, such as admin
+	Uncapped []string `json:"uncapped,omitempty"`
+}
+
+// PolicyRule denies a request if it applies to the action and the caller,
+// and all of its conditions hold for the cluster
+type PolicyRule struct {
+	// Name identifies the rule
+	Name string `json:"name"`
+	// Actions are the actions the rule applies to: create, prolong,
+	// delete, claim, transfer, or wake, defaults to all of them
+	Actions []string `json:"actions,omitempty"`
+	// Roles are the roles of the callers the rule applies
+	// to, defaults to everyone
+	Roles []string `json:"roles,omitempty"`
+	// Except are the roles of the callers the rule doesn't apply to
+	Except []string `json:"except,omitempty"`
+	// When are the conditions, keyed by attribute, that all need to
+	// hold for the rule to deny the request, it always does if unset
+	When map[string]Condition `json:"when,omitempty"`
+	// Reason tells callers why the rule denied their request
+	Reason string `json:"reason,omitempty"`
+}
+
+// Condition is what an attribute of a request is compared to
+type Condition struct {
+	// GreaterThan holds for numbers greater than it
+	GreaterThan *int `json:"gt,omitempty"`
+	// LessThan holds for numbers less than it
+	LessThan *int `json:"lt,omitempty"`
+	// In holds if the attribute, or any of its values, is one of them
+	In []string `json:"in,omitempty"`
+	// NotIn holds if the attribute, or all of its values, are none of them
+	NotIn []string `json:"notin,omitempty"`
+}
+
+// PolicyDenial is why a rule denied a request
+type PolicyDenial struct {
+	// Rule is the name of the rule
+	Rule string `json:"rule"`
+	// Reason is why the rule denied the request
+	Reason string `json:"reason"`
+}
+
+// FetchPolicies returns the policies of this installation,
+// or nil if there are none
+func FetchPolicies(clusterbucket string) (*Policies, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return nil, err
+	}
+	downloader := s3manager.NewDownloader(cfg)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	_, err = downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(policiesConfigKey),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, err
+	}
+	pol := Policies{}
+	err = json.Unmarshal(buf.Bytes(), &pol)
+	if err != nil {
+		return nil, err
+	}
+	// a typo must not silently turn a rule off:
+	for _, rule := range pol.Rules {
+		for attr := range rule.When {
+			if !strings.HasPrefix(attr, "labels.") && !Contains(policyAttributes, attr) {
+				return nil, fmt.Errorf("policy rule %v refers to unknown attribute %v", rule.Name, attr)
+			}
+		}
+	}
+	return &pol, nil
+}
+
+// AttributesOf returns the attributes of the cluster the conditions of the
+// rules are evaluated against, with prolong being the minutes the cluster
+// is to be prolonged by, if at all
+func AttributesOf(cs ClusterSpec, prolong int) map[string]interface{} {
+	numworkers := cs.NumWorkers
+	instancetypes := []string{cs.InstanceType}
+	if len(cs.NodeGroups) > 0 {
+		numworkers, instancetypes = 0, []string{}
+		for _, ng := range cs.NodeGroups {
+			numworkers += ng.DesiredCapacity
+			if ng.InstanceType != "" {
+				instancetypes = append(instancetypes, ng.InstanceType)
+			} else {
+				instancetypes = append(instancetypes, cs.InstanceType)
+			}
+		}
+	}
+	// the lifetime is how long the cluster lives in total, from launch until
+	// it expires, which is the timeout unless it has been prolonged:
+	lifetime := cs.Timeout
+	created, cerr := strconv.ParseInt(cs.CreationTime, 10, 64)
+	launched, lerr := strconv.ParseInt(cs.LaunchTime, 10, 64)
+	if cerr == nil && lerr == nil && created > launched {
+		lifetime += int(created-launched) / 60
+	}
+	attrs := map[string]interface{}{
+		"numworkers":   numworkers,
+		"timeout":      cs.Timeout,
+		"lifetime":     lifetime,
+		"prolong":      prolong,
+		"kubeversion":  []string{cs.KubeVersion},
+		"region":       []string{cs.Region},
+		"instancetype": instancetypes,
+		"onexpiry":     []string{cs.OnExpiry},
+		"owner":        []string{cs.Owner},
+		"team":         []string{cs.Team},
+	}
+	for key, value := range cs.Labels {
+		attrs["labels."+key] = []string{value}
+	}
+	return attrs
+}
+
+// holds returns true if the condition holds for the value of the
+// attribute, which is either a number or a list of strings
+func (c Condition) holds(value interface{}) bool {
+	values := []string{""}
+	switch v := value.(type) {
+	case int:
+		if c.GreaterThan != nil && v <= *c.GreaterThan {
+			return false
+		}
+		if c.LessThan != nil && v >= *c.LessThan {
+			return false
+		}
+		values = []string{strconv.Itoa(v)}
+	case []string:
+		if c.GreaterThan != nil || c.LessThan != nil {
+			return false
+		}
+		values = v
+	}
+	if len(c.In) > 0 {
+		in := false
+		for _, val := range values {
+			in = in || Contains(c.In, val)
+		}
+		if !in {
+			return false
+		}
+	}
+	for _, val := range values {
+		if Contains(c.NotIn, val) {
+			return false
+		}
+	}
+	return true
+}
+
+// rolesOf returns the roles of the caller
+func rolesOf(request events.APIGatewayProxyRequest, ac *AccessConfig, pol *Policies) []string {
+	roles := []string{}
+	if ac != nil && CallerIsAdmin(request, ac) {
+		roles = append(roles, adminRole)
+	}
+	caller := CallerOf(request)
+	for role, members := range pol.Roles {
+		for _, member := range members {
+			isMember := false
+			if strings.HasPrefix(member, "team:") {
+				isMember = ac != nil && CallerInTeam(request, ac, strings.TrimPrefix(member, "team:"))
+			} else {
+				isMember = MatchesUser(caller, member)
+			}
+			if isMember {
+				roles = append(roles, role)
+				break
+			}
+		}
+	}
+	return roles
+}
+
+// anyOf returns true if any of the roles is one of the ones given
+func anyOf(roles, of []string) bool {
+	for _, role := range roles {
+		if Contains(of, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// EvaluatePolicies returns why the policies deny the caller the action
+// on the cluster, if they do. Requests from other functions of the
+// control plane, such as the scheduler, are not subject to policies.
+func EvaluatePolicies(request events.APIGatewayProxyRequest, ac *AccessConfig, pol *Policies, action string, attrs map[string]interface{}) []PolicyDenial {
+	if pol == nil || IsInternal(request) {
+		return nil
+	}
+	roles := rolesOf(request, ac, pol)
+	denials := []PolicyDenial{}
+	for _, rule := range pol.Rules {
+		if len(rule.Actions) > 0 && !Contains(rule.Actions, action) {
+			continue
+		}
+		if len(rule.Roles) > 0 && !anyOf(roles, rule.Roles) {
+			continue
+		}
+		if anyOf(roles, rule.Except) {
+			continue
+		}
+		applies := true
+		for attr, cond := range rule.When {
+			value, ok := attrs[attr]
+			if !ok {
+				value = []string{""}
+			}
+			applies = applies && cond.holds(value)
+		}
+		if !applies {
+			continue
+		}
+		reason := rule.Reason
+		if reason == "" {
+			reason = fmt.Sprintf("You may not %v this cluster as per the rule %v", action, rule.Name)
+		}
+		denials = append(denials, PolicyDenial{rule.Name, reason})
+	}
+	fmt.Printf("DEBUG:: %v policy rules deny %v to %v with roles %v\n", len(denials), action, CallerOf(request), roles)
+	return denials
+}
+
+// TimeoutCapError returns why the timeout is too long, if it exceeds the
+// maximum timeout and the caller has none of the roles the policies lift
+// the cap for. Requests from other functions of the control plane, such
+// as the scheduler, are checked when the schedule is added.
+func TimeoutCapError(request events.APIGatewayProxyRequest, ac *AccessConfig, pol *Policies, timeout int) string {
+	if timeout <= MaxTimeout() || IsInternal(request) {
+		return ""
+	}
+	if pol != nil && anyOf(rolesOf(request, ac, pol), pol.Uncapped) {
+		return ""
+	}
+	return fmt.Sprintf("must be at most %d minutes", MaxTimeout())
+}
+
+// PolicyDenied responds with why the policies deny the request
+func PolicyDenied(denials []PolicyDenial) (events.APIGatewayProxyResponse, error) {
+	reasons := []string{}
+	for _, d := range denials {
+		reasons = append(reasons, d.Reason)
+	}
+	return RespondError(http.StatusForbidden, "forbidden", "Denied by policy: "+strings.Join(reasons, "; "), denials)
+}
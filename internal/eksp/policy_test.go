@@ -0,0 +1,189 @@
+package eksp
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// requestBy returns an API request authenticated as the user
+func requestBy(user string) events.APIGatewayProxyRequest {
+	request := events.APIGatewayProxyRequest{}
+	request.RequestContext.Authorizer = map[string]interface{}{"user": user}
+	return request
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestEvaluatePolicies(t *testing.T) {
+	ac := &AccessConfig{
+		Admins: []string{"admin@example.com"},
+		Teams:  []Team{{Name: "payments", Members: []string{"jane@example.com"}}},
+	}
+	pol := &Policies{
+		Roles: map[string][]string{
+			"contractors": {"@contractor.example.com"},
+			"payments":    {"team:payments"},
+		},
+		Rules: []PolicyRule{
+			{
+				Name:    "max-workers",
+				Actions: []string{"create"},
+				Except:  []string{"admin"},
+				When:    map[string]Condition{"numworkers": {GreaterThan: intPtr(5)}},
+				Reason:  "Only admins may create clusters with more than 5 workers",
+			},
+			{
+				Name:    "contractors-no-prolong",
+				Actions: []string{"prolong"},
+				Roles:   []string{"contractors"},
+			},
+			{
+				Name:  "payments-instance-types",
+				Roles: []string{"payments"},
+				When:  map[string]Condition{"instancetype": {NotIn: []string{"m5.large", "m5.xlarge"}}},
+			},
+			{
+				Name: "no-prod",
+				When: map[string]Condition{"labels.env": {In: []string{"prod"}}},
+			},
+			{
+				Name:    "long-prolong",
+				Actions: []string{"prolong"},
+				When:    map[string]Condition{"prolong": {GreaterThan: intPtr(60)}},
+			},
+		},
+	}
+	internal := requestBy("")
+	internal.RequestContext.Authorizer["internal"] = true
+	tests := []struct {
+		name    string
+		request events.APIGatewayProxyRequest
+		pol     *Policies
+		action  string
+		cs      ClusterSpec
+		prolong int
+		rules   []string
+	}{
+		{"no policies", requestBy("joe@example.com"), nil, "create", ClusterSpec{NumWorkers: 10}, 0, nil},
+		{"allowed", requestBy("joe@example.com"), pol, "create", ClusterSpec{NumWorkers: 2}, 0, nil},
+		{"too many workers", requestBy("joe@example.com"), pol, "create", ClusterSpec{NumWorkers: 10}, 0, []string{"max-workers"}},
+		{"too many workers in nodegroups", requestBy("joe@example.com"), pol, "create", ClusterSpec{NodeGroups: []NodeGroup{{DesiredCapacity: 3}, {DesiredCapacity: 3}}}, 0, []string{"max-workers"}},
+		{"too many workers as admin", requestBy("admin@example.com"), pol, "create", ClusterSpec{NumWorkers: 10}, 0, nil},
+		{"too many workers for other action", requestBy("joe@example.com"), pol, "delete", ClusterSpec{NumWorkers: 10}, 0, nil},
+		{"too many workers internally", internal, pol, "create", ClusterSpec{NumWorkers: 10}, 0, nil},
+		{"contractor prolonging", requestBy("bob@contractor.example.com"), pol, "prolong", ClusterSpec{NumWorkers: 1}, 10, []string{"contractors-no-prolong"}},
+		{"contractor creating", requestBy("bob@contractor.example.com"), pol, "create", ClusterSpec{NumWorkers: 1}, 0, nil},
+		{"team member with allowed instance type", requestBy("jane@example.com"), pol, "create", ClusterSpec{NumWorkers: 1, InstanceType: "m5.large"}, 0, nil},
+		{"team member with other instance type", requestBy("jane@example.com"), pol, "create", ClusterSpec{NumWorkers: 1, InstanceType: "p3.2xlarge"}, 0, []string{"payments-instance-types"}},
+		{"team member with other nodegroup instance type", requestBy("jane@example.com"), pol, "create", ClusterSpec{InstanceType: "p3.2xlarge", NodeGroups: []NodeGroup{{DesiredCapacity: 1}, {DesiredCapacity: 1, InstanceType: "p3.8xlarge"}}}, 0, []string{"payments-instance-types"}},
+		{"team member with some allowed nodegroup instance type", requestBy("jane@example.com"), pol, "create", ClusterSpec{InstanceType: "m5.large", NodeGroups: []NodeGroup{{DesiredCapacity: 1}, {DesiredCapacity: 1, InstanceType: "p3.2xlarge"}}}, 0, nil},
+		{"non-member with other instance type", requestBy("joe@example.com"), pol, "create", ClusterSpec{NumWorkers: 1, InstanceType: "p3.2xlarge"}, 0, nil},
+		{"label matching", requestBy("joe@example.com"), pol, "delete", ClusterSpec{Labels: map[string]string{"env": "prod"}}, 0, []string{"no-prod"}},
+		{"label not matching", requestBy("joe@example.com"), pol, "delete", ClusterSpec{Labels: map[string]string{"env": "dev"}}, 0, nil},
+		{"label missing", requestBy("joe@example.com"), pol, "delete", ClusterSpec{}, 0, nil},
+		{"short prolong", requestBy("joe@example.com"), pol, "prolong", ClusterSpec{NumWorkers: 1}, 60, nil},
+		{"long prolong", requestBy("joe@example.com"), pol, "prolong", ClusterSpec{NumWorkers: 1}, 61, []string{"long-prolong"}},
+		{"several rules", requestBy("bob@contractor.example.com"), pol, "prolong", ClusterSpec{Labels: map[string]string{"env": "prod"}}, 120, []string{"contractors-no-prolong", "no-prod", "long-prolong"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			denials := EvaluatePolicies(tt.request, ac, tt.pol, tt.action, AttributesOf(tt.cs, tt.prolong))
+			rules := []string{}
+			for _, d := range denials {
+				rules = append(rules, d.Rule)
+				if d.Reason == "" {
+					t.Errorf("rule %v denied without a reason", d.Rule)
+				}
+			}
+			if len(rules) != len(tt.rules) || (len(rules) > 0 && !reflect.DeepEqual(rules, tt.rules)) {
+				t.Errorf("got denials by %v, want %v", rules, tt.rules)
+			}
+		})
+	}
+}
+
+func TestConditionHolds(t *testing.T) {
+	tests := []struct {
+		name  string
+		cond  Condition
+		value interface{}
+		holds bool
+	}{
+		{"greater than", Condition{GreaterThan: intPtr(5)}, 6, true},
+		{"not greater than", Condition{GreaterThan: intPtr(5)}, 5, false},
+		{"less than", Condition{LessThan: intPtr(5)}, 4, true},
+		{"not less than", Condition{LessThan: intPtr(5)}, 5, false},
+		{"in range", Condition{GreaterThan: intPtr(1), LessThan: intPtr(5)}, 3, true},
+		{"number in", Condition{In: []string{"3"}}, 3, true},
+		{"comparing strings", Condition{GreaterThan: intPtr(1)}, []string{"2"}, false},
+		{"in", Condition{In: []string{"1.13", "1.14"}}, []string{"1.14"}, true},
+		{"not in", Condition{In: []string{"1.13", "1.14"}}, []string{"1.12"}, false},
+		{"any in", Condition{In: []string{"p3.2xlarge"}}, []string{"m5.large", "p3.2xlarge"}, true},
+		{"notin", Condition{NotIn: []string{"m5.large"}}, []string{"p3.2xlarge"}, true},
+		{"any notin", Condition{NotIn: []string{"m5.large"}}, []string{"p3.2xlarge", "m5.large"}, false},
+		{"no constraints", Condition{}, []string{""}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.cond.holds(tt.value); got != tt.holds {
+			t.Errorf("%v: holds(%v) = %v, want %v", tt.name, tt.value, got, tt.holds)
+		}
+	}
+}
+
+func TestAttributesOfLifetime(t *testing.T) {
+	tests := []struct {
+		name     string
+		cs       ClusterSpec
+		lifetime int
+	}{
+		{"never prolonged", ClusterSpec{Timeout: 60, LaunchTime: "1000", CreationTime: "1000"}, 60},
+		{"prolonged an hour in", ClusterSpec{Timeout: 60, LaunchTime: "1000", CreationTime: "4600"}, 120},
+		{"not launched yet", ClusterSpec{Timeout: 60, CreationTime: "4600"}, 60},
+	}
+	for _, tt := range tests {
+		if got := AttributesOf(tt.cs, 0)["lifetime"]; got != tt.lifetime {
+			t.Errorf("%v: lifetime is %v, want %v", tt.name, got, tt.lifetime)
+		}
+	}
+}
+
+func TestTimeoutCapError(t *testing.T) {
+	defer os.Setenv("MAX_TIMEOUT", os.Getenv("MAX_TIMEOUT"))
+	os.Setenv("MAX_TIMEOUT", "1440")
+	ac := &AccessConfig{
+		Admins: []string{"admin@example.com"},
+		Teams:  []Team{{Name: "platform", Members: []string{"jane@example.com"}}},
+	}
+	pol := &Policies{
+		Roles:    map[string][]string{"platform": {"team:platform"}},
+		Uncapped: []string{"admin", "platform"},
+	}
+	internal := requestBy("")
+	internal.RequestContext.Authorizer["internal"] = true
+	tests := []struct {
+		name    string
+		request events.APIGatewayProxyRequest
+		pol     *Policies
+		timeout int
+		capped  bool
+	}{
+		{"within the maximum", requestBy("joe@example.com"), pol, 1440, false},
+		{"beyond the maximum", requestBy("joe@example.com"), pol, 1441, true},
+		{"beyond the maximum without policies", requestBy("admin@example.com"), nil, 1441, true},
+		{"admin lifting the cap", requestBy("admin@example.com"), pol, 20160, false},
+		{"admin without the cap lifted", requestBy("admin@example.com"), &Policies{}, 20160, true},
+		{"team member lifting the cap", requestBy("jane@example.com"), pol, 20160, false},
+		{"internally", internal, pol, 20160, false},
+	}
+	for _, tt := range tests {
+		msg := TimeoutCapError(tt.request, ac, tt.pol, tt.timeout)
+		if (msg != "") != tt.capped {
+			t.Errorf("%v: TimeoutCapError() = %q, want capped: %v", tt.name, msg, tt.capped)
+		}
+	}
+}
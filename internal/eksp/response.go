@@ -0,0 +1,179 @@
+package eksp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// Envelope is the JSON document every endpoint responds with.
+// Exactly one of Data and Error is set.
+type Envelope struct {
+	// Data is the payload of a successful request
+	Data interface{} `json:"data,omitempty"`
+	// Error describes why the request failed
+	Error *APIError `json:"error,omitempty"`
+}
+
+// APIError describes why a request failed
+type APIError struct {
+	// Code is a machine-readable error code, such as not_found
+	Code string `json:"code"`
+	// Message is a human-readable description of the error
+	Message string `json:"message"`
+	// Details optionally carries more information, such as
+	// the offending fields of a cluster spec
+	Details interface{} `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	if e.Details != nil {
+		details, err := json.Marshal(e.Details)
+		if err == nil {
+			return fmt.Sprintf("%v %s", e.Message, details)
+		}
+	}
+	return e.Message
+}
+
+// DetailsAs decodes the details of the error into v
+func (e *APIError) DetailsAs(v interface{}) error {
+	details, err := json.Marshal(e.Details)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(details, v)
+}
+
+// ParseResponse unwraps a response of the control plane into data. If
+// the control plane reported an error, it is returned as an *APIError.
+func ParseResponse(res string, data interface{}) error {
+	env := struct {
+		Data  json.RawMessage `json:"data"`
+		Error *APIError       `json:"error"`
+	}{}
+	err := json.Unmarshal([]byte(res), &env)
+	if err != nil {
+		return err
+	}
+	if env.Error != nil {
+		return env.Error
+	}
+	return json.Unmarshal(env.Data, data)
+}
+
+// throttlingCodes are the AWS error codes signalling that we've been
+// rate limited, which we pass on to the caller as a 429
+var throttlingCodes = map[string]bool{
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"TooManyRequestsException": true,
+	"RequestLimitExceeded":     true,
+	"SlowDown":                 true,
+}
+
+// Respond wraps the data in an envelope and responds with the status code
+func Respond(statuscode int, data interface{}) (events.APIGatewayProxyResponse, error) {
+	return respondWith(statuscode, Envelope{Data: data})
+}
+
+// RespondError wraps the error in an envelope and responds with the status code
+func RespondError(statuscode int, code, message string, details interface{}) (events.APIGatewayProxyResponse, error) {
+	fmt.Printf("DEBUG:: responding with %v %v: %v\n", statuscode, code, message)
+	return respondWith(statuscode, Envelope{Error: &APIError{
+		Code:    code,
+		Message: message,
+		Details: details,
+	}})
+}
+
+// allowedOrigin returns the origin browsers may call the control plane
+// from, as configured in the ALLOWED_ORIGIN of the control plane
+func allowedOrigin() string {
+	return EnvOr("ALLOWED_ORIGIN", "http://localhost:8080")
+}
+
+// respondWith marshals the envelope and responds with the status code
+func respondWith(statuscode int, env Envelope) (events.APIGatewayProxyResponse, error) {
+	envjson, err := json.Marshal(env)
+	if err != nil {
+		fmt.Println(err.Error())
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusInternalServerError,
+			Headers: map[string]string{
+				"Content-Type":                "application/json",
+				"Access-Control-Allow-Origin": allowedOrigin(),
+			},
+			Body: `{"error":{"code":"internal","message":"can't marshal response"}}`,
+		}, nil
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: statuscode,
+		Headers: map[string]string{
+			"Content-Type":                "application/json",
+			"Access-Control-Allow-Origin": allowedOrigin(),
+		},
+		Body: string(envjson),
+	}, nil
+}
+
+// ServerError responds with a 500, or with a 429 if
+// the error is due to AWS rate limiting us
+func ServerError(err error) (events.APIGatewayProxyResponse, error) {
+	fmt.Println(err.Error())
+	if aerr, ok := err.(awserr.Error); ok && throttlingCodes[aerr.Code()] {
+		return RespondError(http.StatusTooManyRequests, "throttled", "Too many requests, please try again later", nil)
+	}
+	return RespondError(http.StatusInternalServerError, "internal", err.Error(), nil)
+}
+
+// BadRequest responds with a 400
+func BadRequest(message string) (events.APIGatewayProxyResponse, error) {
+	return RespondError(http.StatusBadRequest, "invalid_request", message, nil)
+}
+
+// Conflict responds with a 409
+func Conflict(message string) (events.APIGatewayProxyResponse, error) {
+	return RespondError(http.StatusConflict, "conflict", message, nil)
+}
+
+// Forbidden responds with a 403
+func Forbidden(message string) (events.APIGatewayProxyResponse, error) {
+	return RespondError(http.StatusForbidden, "forbidden", message, nil)
+}
+
+// ClusterError maps errors looking up a cluster to responses: a 404
+// if there's no such cluster, carrying its tombstone if it has been
+// torn down, a 400 if the reference is ambiguous, and a 500 otherwise
+func ClusterError(bucket string, err error) (events.APIGatewayProxyResponse, error) {
+	switch cerr := err.(type) {
+	case NotFoundError:
+		ts, terr := LookupTombstone(bucket, cerr.Ref)
+		if terr != nil {
+			fmt.Println(terr.Error())
+		}
+		if ts != nil {
+			return RespondError(http.StatusNotFound, "not_found", fmt.Sprintf("Cluster %v has been deleted", cerr.Ref), ts)
+		}
+		return RespondError(http.StatusNotFound, "not_found", cerr.Error(), nil)
+	case AmbiguousRefError:
+		return RespondError(http.StatusBadRequest, "ambiguous_reference", cerr.Error(), cerr.Candidates)
+	default:
+		return ServerError(err)
+	}
+}
+
+// ValidationError responds with a 400 and the field-level issues
+// found in the cluster spec
+func ValidationError(ferrs []FieldError) (events.APIGatewayProxyResponse, error) {
+	return RespondError(http.StatusBadRequest, "validation_failed", "The cluster spec is invalid", ferrs)
+}
+
+// ConflictError responds with a 409 and the field-level issues
+// that conflict with existing clusters
+func ConflictError(ferrs []FieldError) (events.APIGatewayProxyResponse, error) {
+	return RespondError(http.StatusConflict, "conflict", "The cluster spec conflicts with an active cluster", ferrs)
+}
@@ -0,0 +1,104 @@
+package eksp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// awsError is an AWS error with the code, such as the SDK returns
+type awsError string
+
+func (e awsError) Error() string   { return string(e) + ": AWS said no" }
+func (e awsError) Code() string    { return string(e) }
+func (e awsError) Message() string { return "AWS said no" }
+func (e awsError) OrigErr() error  { return nil }
+
+func TestRespond(t *testing.T) {
+	res, err := Respond(http.StatusCreated, ClusterSpec{ID: "c-1", Name: "mh9-eksp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusCreated || res.Headers["Content-Type"] != "application/json" {
+		t.Errorf("got status code %v and headers %v", res.StatusCode, res.Headers)
+	}
+	env := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(res.Body), &env); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := env["error"]; ok {
+		t.Errorf("successful response %s carries an error", res.Body)
+	}
+	cs := ClusterSpec{}
+	if err := ParseResponse(res.Body, &cs); err != nil || cs.ID != "c-1" || cs.Name != "mh9-eksp" {
+		t.Errorf("parsed %+v, %v from %s", cs, err, res.Body)
+	}
+}
+
+func TestRespondError(t *testing.T) {
+	ferrs := []FieldError{{Field: "name", Message: "must not be empty"}}
+	res, _ := ValidationError(ferrs)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status code %v, want %v", res.StatusCode, http.StatusBadRequest)
+	}
+	cs := ClusterSpec{}
+	err := ParseResponse(res.Body, &cs)
+	apierr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("got error %v from %s, want an API error", err, res.Body)
+	}
+	if apierr.Code != "validation_failed" {
+		t.Errorf("got code %v, want validation_failed", apierr.Code)
+	}
+	details := []FieldError{}
+	if err := apierr.DetailsAs(&details); err != nil || !reflect.DeepEqual(details, ferrs) {
+		t.Errorf("got details %v, %v, want %v", details, err, ferrs)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	statuses := map[error]int{
+		errors.New("disk full"):              http.StatusInternalServerError,
+		awsError("AccessDenied"):             http.StatusInternalServerError,
+		awsError("Throttling"):               http.StatusTooManyRequests,
+		awsError("SlowDown"):                 http.StatusTooManyRequests,
+		awsError("RequestLimitExceeded"):     http.StatusTooManyRequests,
+		awsError("TooManyRequestsException"): http.StatusTooManyRequests,
+	}
+	for err, status := range statuses {
+		if res, _ := ServerError(err); res.StatusCode != status {
+			t.Errorf("responded to %v with %v, want %v", err, res.StatusCode, status)
+		}
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	if err := ParseResponse("<html>Bad Gateway</html>", &ClusterSpec{}); err == nil {
+		t.Error("parsed a response that isn't an envelope")
+	}
+	ids := []string{}
+	if err := ParseResponse(`{"data":["c-1","c-2"]}`, &ids); err != nil || len(ids) != 2 {
+		t.Errorf("got %v, %v", ids, err)
+	}
+}
+
+func TestClusterError(t *testing.T) {
+	res, _ := ClusterError("eksp-bucket", AmbiguousRefError{Ref: "e2b", Candidates: []string{"e2b4f7c0-1111", "e2b4f7c0-2222"}})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("responded to an ambiguous reference with %v, want %v", res.StatusCode, http.StatusBadRequest)
+	}
+	apierr, ok := ParseResponse(res.Body, nil).(*APIError)
+	if !ok || apierr.Code != "ambiguous_reference" {
+		t.Fatalf("got %s, want an ambiguous_reference error", res.Body)
+	}
+	candidates := []string{}
+	if apierr.DetailsAs(&candidates) != nil || len(candidates) != 2 {
+		t.Errorf("got candidates %v, want both clusters", candidates)
+	}
+	res, _ = ClusterError("eksp-bucket", errors.New("disk full"))
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("responded to a failing lookup with %v, want %v", res.StatusCode, http.StatusInternalServerError)
+	}
+}
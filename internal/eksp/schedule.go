@@ -0,0 +1,162 @@
+package eksp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minutesPerDay is the number of minutes in a day, which is
+// also the latest time of day a schedule can end at, 24:00
+const minutesPerDay = 24 * 60
+
+// weekdays maps the day names used in schedules to weekdays
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseDays parses the days of a schedule, such as Mon-Fri
+// or Mon,Wed,Fri, into the set of weekdays it covers
+func parseDays(days string) (map[time.Weekday]bool, error) {
+	wds := map[time.Weekday]bool{}
+	for _, r := range strings.Split(strings.ToLower(days), ",") {
+		bounds := strings.SplitN(strings.TrimSpace(r), "-", 2)
+		from, ok := weekdays[bounds[0]]
+		if !ok {
+			return wds, fmt.Errorf("unknown day %q", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			to, ok = weekdays[bounds[1]]
+			if !ok {
+				return wds, fmt.Errorf("unknown day %q", bounds[1])
+			}
+		}
+		// ranges can wrap around the weekend, such as Sat-Sun:
+		for d := from; ; d = (d + 1) % 7 {
+			wds[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return wds, nil
+}
+
+// parseTimeOfDay parses a time of day such as 08:00
+// into the minutes since midnight
+func parseTimeOfDay(tod string) (int, error) {
+	parts := strings.SplitN(tod, ":", 2)
+	if len(parts) != 2 || len(parts[1]) != 2 {
+		return 0, fmt.Errorf("%q is not of the form HH:MM", tod)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("%q is not of the form HH:MM", tod)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("%q is not of the form HH:MM", tod)
+	}
+	mins := h*60 + m
+	if h < 0 || m < 0 || m > 59 || mins > minutesPerDay {
+		return 0, fmt.Errorf("%q is not a time of day", tod)
+	}
+	return mins, nil
+}
+
+// Window is a parsed schedule
+type Window struct {
+	days  map[time.Weekday]bool
+	start int
+	end   int
+	loc   *time.Location
+}
+
+// ParseSchedule checks the schedule and turns it into a window
+func ParseSchedule(s Schedule) (Window, error) {
+	w := Window{}
+	var err error
+	w.days, err = parseDays(s.Days)
+	if err != nil {
+		return w, fmt.Errorf("days: %v", err)
+	}
+	w.start, err = parseTimeOfDay(s.Start)
+	if err != nil {
+		return w, fmt.Errorf("start: %v", err)
+	}
+	w.end, err = parseTimeOfDay(s.End)
+	if err != nil {
+		return w, fmt.Errorf("end: %v", err)
+	}
+	if w.start >= w.end {
+		return w, fmt.Errorf("start must be before end")
+	}
+	w.loc, err = time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return w, fmt.Errorf("timezone: unknown time zone %q", s.TimeZone)
+	}
+	return w, nil
+}
+
+// ActiveAt returns true if the schedule has the cluster active at the time
+func (w Window) ActiveAt(t time.Time) bool {
+	lt := t.In(w.loc)
+	mins := lt.Hour()*60 + lt.Minute()
+	return w.days[lt.Weekday()] && mins >= w.start && mins < w.end
+}
+
+// NextTransition returns the first point in time after t at which
+// the schedule has the cluster become active or inactive, or the
+// zero time if there's no such point, as with 24/7 schedules
+func (w Window) NextTransition(t time.Time) time.Time {
+	lt := t.In(w.loc)
+	// a week and a day covers all transitions, since the
+	// window is at most a day and repeats every week:
+	for i := 0; i <= 7; i++ {
+		day := time.Date(lt.Year(), lt.Month(), lt.Day()+i, 0, 0, 0, 0, w.loc)
+		if !w.days[day.Weekday()] {
+			continue
+		}
+		for _, mins := range []int{w.start, w.end} {
+			tr := time.Date(day.Year(), day.Month(), day.Day(), mins/60, mins%60, 0, 0, w.loc)
+			if tr.After(t) && w.ActiveAt(tr) != w.ActiveAt(t) {
+				return tr
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// NextRun returns the first point in time after t which falls on
+// one of the days at the time of day in the time zone, that is, when
+// a recurring cluster creation is due next
+func NextRun(days, tod, timezone string, t time.Time) (time.Time, error) {
+	wds, err := parseDays(days)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("days: %v", err)
+	}
+	mins, err := parseTimeOfDay(tod)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time: %v", err)
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("timezone: unknown time zone %q", timezone)
+	}
+	lt := t.In(loc)
+	for i := 0; i <= 7; i++ {
+		run := time.Date(lt.Year(), lt.Month(), lt.Day()+i, mins/60, mins%60, 0, 0, loc)
+		if wds[run.Weekday()] && run.After(t) {
+			return run, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("days: no day given")
+}
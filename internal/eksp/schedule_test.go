@@ -0,0 +1,152 @@
+package eksp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// at parses an RFC 3339 timestamp, such as 2019-06-14T17:30:00Z
+func at(t *testing.T, ts string) time.Time {
+	t.Helper()
+	tt, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tt
+}
+
+func TestParseSchedule(t *testing.T) {
+	valid := []Schedule{
+		{Days: "Mon-Fri", Start: "08:00", End: "19:00", TimeZone: "Europe/Berlin"},
+		{Days: "mon,wed,fri", Start: "00:00", End: "24:00", TimeZone: "UTC"},
+		{Days: "Sat-Sun", Start: "9:30", End: "12:00", TimeZone: "America/New_York"},
+	}
+	for _, s := range valid {
+		if _, err := ParseSchedule(s); err != nil {
+			t.Errorf("ParseSchedule(%+v) failed: %v", s, err)
+		}
+	}
+	invalid := []Schedule{
+		{Days: "Mon-Fry", Start: "08:00", End: "19:00", TimeZone: "UTC"},
+		{Days: "", Start: "08:00", End: "19:00", TimeZone: "UTC"},
+		{Days: "Mon", Start: "08:60", End: "19:00", TimeZone: "UTC"},
+		{Days: "Mon", Start: "08:00", End: "24:01", TimeZone: "UTC"},
+		{Days: "Mon", Start: "8", End: "19:00", TimeZone: "UTC"},
+		{Days: "Mon", Start: "19:00", End: "08:00", TimeZone: "UTC"},
+		{Days: "Mon", Start: "08:00", End: "19:00", TimeZone: "Mars/Olympus_Mons"},
+	}
+	for _, s := range invalid {
+		if _, err := ParseSchedule(s); err == nil {
+			t.Errorf("ParseSchedule(%+v) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestWindowActiveAt(t *testing.T) {
+	berlin, err := ParseSchedule(Schedule{Days: "Mon-Fri", Start: "08:00", End: "19:00", TimeZone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auckland, err := ParseSchedule(Schedule{Days: "Mon", Start: "00:00", End: "06:00", TimeZone: "Pacific/Auckland"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	weekend, err := ParseSchedule(Schedule{Days: "Sat-Sun", Start: "10:00", End: "24:00", TimeZone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		w    Window
+		t    string
+		want bool
+	}{
+		{"Friday afternoon in Berlin", berlin, "2019-06-14T16:30:00Z", true},
+		{"Friday evening in Berlin, still working hours in UTC", berlin, "2019-06-14T17:30:00Z", false},
+		{"Monday at 07:30 in Berlin", berlin, "2019-06-17T05:30:00Z", false},
+		{"Monday at 08:00 in Berlin", berlin, "2019-06-17T06:00:00Z", true},
+		{"Saturday in Berlin", berlin, "2019-06-15T10:00:00Z", false},
+		{"Monday in Auckland, Sunday in UTC", auckland, "2019-06-16T13:00:00Z", true},
+		{"Monday in UTC, Monday noon in Auckland", auckland, "2019-06-17T00:00:00Z", false},
+		{"Sunday just before midnight", weekend, "2019-06-16T23:59:00Z", true},
+		{"Monday just after midnight", weekend, "2019-06-17T00:01:00Z", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.w.ActiveAt(at(t, tt.t)); got != tt.want {
+				t.Errorf("ActiveAt(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowNextTransition(t *testing.T) {
+	berlin, err := ParseSchedule(Schedule{Days: "Mon-Fri", Start: "08:00", End: "19:00", TimeZone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newyork, err := ParseSchedule(Schedule{Days: "Mon-Fri", Start: "09:00", End: "17:00", TimeZone: "America/New_York"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	always, err := ParseSchedule(Schedule{Days: "Sun-Sat", Start: "00:00", End: "24:00", TimeZone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("end of the working day", func(t *testing.T) {
+		got := berlin.NextTransition(at(t, "2019-06-14T16:30:00Z"))
+		if want := at(t, "2019-06-14T17:00:00Z"); !got.Equal(want) {
+			t.Errorf("NextTransition() = %v, want %v", got, want)
+		}
+	})
+	t.Run("over the weekend", func(t *testing.T) {
+		got := berlin.NextTransition(at(t, "2019-06-14T17:30:00Z"))
+		if want := at(t, "2019-06-17T06:00:00Z"); !got.Equal(want) {
+			t.Errorf("NextTransition() = %v, want %v", got, want)
+		}
+	})
+	t.Run("over the weekend daylight saving time starts", func(t *testing.T) {
+		// 09:00 is 14:00 UTC on Friday and 13:00 UTC on Monday:
+		got := newyork.NextTransition(at(t, "2019-03-08T22:00:00Z"))
+		if want := at(t, "2019-03-11T13:00:00Z"); !got.Equal(want) {
+			t.Errorf("NextTransition() = %v, want %v", got, want)
+		}
+	})
+	t.Run("around the clock", func(t *testing.T) {
+		if got := always.NextTransition(at(t, "2019-06-14T16:30:00Z")); !got.IsZero() {
+			t.Errorf("NextTransition() = %v, want none", got)
+		}
+	})
+}
+
+func TestNextRun(t *testing.T) {
+	tests := []struct {
+		days, tod, timezone string
+		after, want         string
+	}{
+		// later the same day:
+		{"Mon-Fri", "07:30", "UTC", "2019-06-14T06:00:00Z", "2019-06-14T07:30:00Z"},
+		// exactly at the time of day, so the next day:
+		{"Mon-Fri", "07:30", "", "2019-06-13T07:30:00Z", "2019-06-14T07:30:00Z"},
+		// Friday after the run, so Monday:
+		{"Mon-Fri", "07:30", "UTC", "2019-06-14T08:00:00Z", "2019-06-17T07:30:00Z"},
+		// still Sunday in UTC, but already Monday in Tokyo:
+		{"Mon", "07:30", "Asia/Tokyo", "2019-06-16T20:00:00Z", "2019-06-16T22:30:00Z"},
+		// weekly, a week later:
+		{"Wed", "12:00", "Europe/Dublin", "2019-06-12T11:00:00Z", "2019-06-19T11:00:00Z"},
+	}
+	for _, tt := range tests {
+		got, err := NextRun(tt.days, tt.tod, tt.timezone, at(t, tt.after))
+		if err != nil {
+			t.Errorf("NextRun(%v, %v, %v, %v) failed: %v", tt.days, tt.tod, tt.timezone, tt.after, err)
+			continue
+		}
+		if want := at(t, tt.want); !got.Equal(want) {
+			t.Errorf("NextRun(%v, %v, %v, %v) = %v, want %v", tt.days, tt.tod, tt.timezone, tt.after, got.UTC(), want)
+		}
+	}
+	if _, err := NextRun("Mon-Fri", "7.30", "UTC", at(t, "2019-06-14T06:00:00Z")); err == nil || !strings.HasPrefix(err.Error(), "time:") {
+		t.Errorf("NextRun() with a malformed time of day = %v, want a time error", err)
+	}
+}
@@ -0,0 +1,295 @@
+// Package eksp holds what the EKSphemeral control plane functions, the
+// CLI, and the UI share: the cluster spec and how it's validated, as well
+// as the plumbing of the API, from authentication to the audit log.
+package eksp
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// PhaseTearingDown is the lifecycle phase of a
+	// cluster once its tear down has started
+	PhaseTearingDown = "TearingDown"
+	// PhaseDeleted is the final lifecycle phase of a cluster,
+	// once all its resources have been deleted
+	PhaseDeleted = "Deleted"
+	// PhaseHibernated is the lifecycle phase of a cluster
+	// with its nodegroups scaled to zero
+	PhaseHibernated = "Hibernated"
+	// TaskStopped is the status of a provisioning
+	// task once eksctl is done
+	TaskStopped = "STOPPED"
+)
+
+// ClusterSpec represents the parameters for eksctl,
+// as cluster metadata including owner and how long the cluster
+// still has to live.
+type ClusterSpec struct {
+	// ID is a unique identifier for the cluster
+	ID string `json:"id"`
+	// Name specifies the cluster name
+	Name string `json:"name"`
+	// NumWorkers specifies the number of worker nodes, defaults to 1
+	NumWorkers int `json:"numworkers"`
+	// KubeVersion  specifies the Kubernetes version to use, defaults to `1.12`
+	KubeVersion string `json:"kubeversion"`
+	// Region specifies the AWS region to provision the cluster in,
+	// defaults to the region of the control plane
+	Region string `json:"region,omitempty"`
+	// InstanceType specifies the EC2 instance type of the worker nodes,
+	// defaults to `m5.large`
+	InstanceType string `json:"instancetype,omitempty"`
+	// Tags are put on all AWS resources of the cluster
+	Tags map[string]string `json:"tags,omitempty"`
+	// Labels are free-form key/value pairs, such as team=payments, to
+	// select clusters by, also put on all AWS resources of the cluster
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations are free-form key/value pairs with
+	// information about the cluster, such as a ticket URL
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// IAMAddons are the eksctl IAM add-on policies granted to the worker
+	// nodes, such as autoScaler, defaults to imageBuilder and appMesh
+	IAMAddons []string `json:"iamaddons,omitempty"`
+	// NodeGroups specifies the groups of worker nodes, if not set
+	// there's a single nodegroup made up of NumWorkers nodes
+	NodeGroups []NodeGroup `json:"nodegroups,omitempty"`
+	// Timeout specifies the timeout in minutes, after which the cluster
+	// is destroyed, defaults to 10
+	Timeout int `json:"timeout"`
+	// Timeout specifies the cluster time to live in minutes.
+	// In other words: the remaining time the cluster has before it is destroyed
+	TTL int `json:"ttl"`
+	// OnExpiry specifies what happens once the timeout expired, either
+	// destroy, the default, or hibernate, scaling the nodegroups to zero
+	OnExpiry string `json:"onexpiry,omitempty"`
+	// Schedule optionally specifies when the cluster is active, outside
+	// of it the cluster is hibernated, scaling the nodegroups to zero
+	Schedule *Schedule `json:"schedule,omitempty"`
+	// Owner specifies the email address of the owner (will be notified when cluster is created and 5 min before destruction)
+	Owner string `json:"owner"`
+	// Team optionally specifies the team owning the cluster together
+	// with the owner, whose members may manage the cluster, too
+	Team string `json:"team,omitempty"`
+	// CoOwners optionally specifies the email addresses of more owners,
+	// who are notified like the owner and may manage the cluster, too
+	CoOwners []string `json:"coowners,omitempty"`
+	// CreationTime is the UTC timestamp of when the cluster was created
+	// which equals the point in time of the creation of the respective
+	// JSON representation of the cluster spec as an object in the metadata
+	// bucket
+	CreationTime string `json:"created"`
+	// LaunchTime is the UTC timestamp of when the cluster was created,
+	// unlike CreationTime it is not reset when the cluster is prolonged
+	LaunchTime string `json:"launched,omitempty"`
+	// ActivationTime is the UTC timestamp of when the cluster
+	// became active, that is, ready to be used
+	ActivationTime string `json:"activated,omitempty"`
+	// ProvisioningTask is the ARN of the ECS task
+	// running eksctl to provision the cluster
+	ProvisioningTask string `json:"provisioningtask,omitempty"`
+	// ProvisioningStatus is the last known status of the provisioning
+	// task, such as PENDING, RUNNING, or STOPPED
+	ProvisioningStatus string `json:"provisioningstatus,omitempty"`
+	// ProvisioningError states why provisioning failed, if it did
+	ProvisioningError string `json:"provisioningerror,omitempty"`
+	// Phase is the lifecycle phase of the cluster, empty while the
+	// cluster is active and TearingDown once the tear down started
+	Phase string `json:"phase,omitempty"`
+	// TeardownReason states why the cluster is torn down, for example
+	// because its timeout expired or because a user deleted it
+	TeardownReason string `json:"teardownreason,omitempty"`
+	// TeardownTime is the UTC timestamp of when the tear down started
+	TeardownTime string `json:"teardownstarted,omitempty"`
+	// HibernationTime is the UTC timestamp of when the cluster was hibernated
+	HibernationTime string `json:"hibernated,omitempty"`
+	// HibernatedNodeGroups are the sizes of the nodegroups before the
+	// cluster was hibernated, restored when the cluster is woken up
+	HibernatedNodeGroups []HibernatedNodeGroup `json:"hibernatednodegroups,omitempty"`
+	// HibernationReason states why the cluster was hibernated, because
+	// its timeout expired or because it is outside of its schedule
+	HibernationReason string `json:"hibernationreason,omitempty"`
+	// NextTransition is the UTC timestamp of when the schedule
+	// next has the cluster hibernated or woken up
+	NextTransition string `json:"nexttransition,omitempty"`
+	// ExpiryWarningTime is the UTC timestamp of when the owners were
+	// warned that the timeout is about to expire, cleared when the
+	// cluster gets a new lease of life, such as when it's prolonged
+	ExpiryWarningTime string `json:"expirywarned,omitempty"`
+	// WarmPool is the warm pool an idle, pre-provisioned cluster belongs
+	// to, until it is claimed, which clears it
+	WarmPool string `json:"warmpool,omitempty"`
+	// Alias is the name a cluster was claimed from a warm pool under,
+	// since the name of the EKS cluster is fixed once it's provisioned
+	Alias string `json:"alias,omitempty"`
+	// ClaimTime is the UTC timestamp of when the cluster
+	// was claimed from a warm pool
+	ClaimTime string `json:"claimed,omitempty"`
+	// Template is the name of the template the cluster was created from,
+	// whose cluster spec the fields of the create request are applied to
+	Template string `json:"template,omitempty"`
+	// IdempotencyKey optionally identifies the create request, so that
+	// repeated requests with the same key create the cluster only once
+	IdempotencyKey string `json:"idempotencykey,omitempty"`
+	// ClusterDetails is only valid for lookup of individual clusters,
+	// that is, when user does, for example, a eksp l CLUSTERID. It
+	// holds info such as cluster status and config
+	ClusterDetails map[string]string `json:"details,omitempty"`
+	// Cost is only valid for lookup of individual clusters, too,
+	// and holds what the cluster is estimated to cost
+	Cost *CostEstimate `json:"cost,omitempty"`
+}
+
+// NodeGroup represents a group of worker nodes of the same kind
+type NodeGroup struct {
+	// Name specifies the nodegroup name, unique within the cluster
+	Name string `json:"name"`
+	// InstanceType specifies the EC2 instance type of the nodes,
+	// defaults to the instance type of the cluster
+	InstanceType string `json:"instancetype,omitempty"`
+	// MinSize is the minimum number of nodes, defaults to DesiredCapacity
+	MinSize int `json:"minsize,omitempty"`
+	// MaxSize is the maximum number of nodes, defaults to DesiredCapacity
+	MaxSize int `json:"maxsize,omitempty"`
+	// DesiredCapacity is the number of nodes launched
+	DesiredCapacity int `json:"desired"`
+	// Labels are put on the Kubernetes nodes
+	Labels map[string]string `json:"labels,omitempty"`
+	// Taints are put on the Kubernetes nodes, keyed by taint key,
+	// with values of the form value:effect such as true:NoSchedule
+	Taints map[string]string `json:"taints,omitempty"`
+	// Spot specifies to use spot rather than on-demand instances
+	Spot bool `json:"spot,omitempty"`
+	// VolumeSize is the size of the root volume of the nodes in GiB,
+	// defaults to the eksctl default
+	VolumeSize int `json:"volumesize,omitempty"`
+}
+
+// HibernatedNodeGroup records the size of a nodegroup at the
+// time the cluster was hibernated
+type HibernatedNodeGroup struct {
+	// NodeGroup is the name of the nodegroup
+	NodeGroup string `json:"nodegroup"`
+	// AutoScalingGroup is the name of the auto scaling group
+	// eksctl created for the nodegroup
+	AutoScalingGroup string `json:"asg"`
+	// MinSize is the minimum number of nodes
+	MinSize int `json:"minsize"`
+	// MaxSize is the maximum number of nodes
+	MaxSize int `json:"maxsize"`
+	// DesiredCapacity is the number of nodes
+	DesiredCapacity int `json:"desired"`
+}
+
+// Schedule represents the working hours of a cluster, repeating weekly
+type Schedule struct {
+	// Days are the days of the week the cluster is active,
+	// such as Mon-Fri or Mon,Wed,Fri
+	Days string `json:"days"`
+	// Start is the time of day the cluster is woken up, such as 08:00
+	Start string `json:"start"`
+	// End is the time of day the cluster is hibernated, such as 19:00
+	End string `json:"end"`
+	// TimeZone is the IANA time zone of the times of day,
+	// such as Europe/Dublin, defaults to UTC
+	TimeZone string `json:"timezone,omitempty"`
+}
+
+// Tombstone is what's left of a cluster after it has been torn down
+type Tombstone struct {
+	ClusterSpec
+	// DeletionTime is the UTC timestamp of when the cluster spec was archived
+	DeletionTime string `json:"deleted"`
+}
+
+// CreationSchedule represents the request to create clusters from
+// a cluster spec at a later point in time, either once or recurring
+type CreationSchedule struct {
+	// ID is a unique identifier for the schedule
+	ID string `json:"id"`
+	// Spec is the cluster spec the clusters are created from
+	Spec ClusterSpec `json:"spec"`
+	// At is when to create the cluster once, as an RFC 3339 timestamp
+	At string `json:"at,omitempty"`
+	// Days are the days of the week to create a cluster on, such
+	// as Mon-Fri or Mon,Wed,Fri, for creating clusters recurringly
+	Days string `json:"days,omitempty"`
+	// Time is the time of day to create a cluster at, such as 07:30
+	Time string `json:"time,omitempty"`
+	// TimeZone is the IANA time zone of the time of day,
+	// such as Europe/Dublin, defaults to UTC
+	TimeZone string `json:"timezone,omitempty"`
+	// CreatedBy is who added the schedule
+	CreatedBy string `json:"createdby,omitempty"`
+	// CreationTime is the UTC timestamp of when the schedule was added
+	CreationTime string `json:"created"`
+	// NextRun is the UTC timestamp of when the next cluster is
+	// created, empty once a one-off schedule has run
+	NextRun string `json:"nextrun,omitempty"`
+	// LastRun is the UTC timestamp of when a cluster was last created
+	LastRun string `json:"lastrun,omitempty"`
+	// LastClusterID is the ID of the cluster created last
+	LastClusterID string `json:"lastcluster,omitempty"`
+	// LastError states why creating the last cluster failed, if it did
+	LastError string `json:"lasterror,omitempty"`
+}
+
+// Template is a named cluster spec users can create clusters from
+type Template struct {
+	// Name identifies the template
+	Name string `json:"name"`
+	// Description tells users what the template is for
+	Description string `json:"description,omitempty"`
+	// Spec is the cluster spec clusters are created from, with
+	// the fields of the create request taking precedence
+	Spec json.RawMessage `json:"spec"`
+	// Owners optionally restricts who may create clusters from the
+	// template to these email addresses, or domains such as @example.com
+	Owners []string `json:"owners,omitempty"`
+	// UpdatedBy is who last stored the template
+	UpdatedBy string `json:"updatedby,omitempty"`
+	// UpdateTime is the UTC timestamp of when the template was last stored
+	UpdateTime string `json:"updated"`
+}
+
+// Allows returns true if the owner may create clusters from the template
+func (t Template) Allows(owner string) bool {
+	if len(t.Owners) == 0 {
+		return true
+	}
+	owner = strings.ToLower(owner)
+	for _, o := range t.Owners {
+		o = strings.ToLower(o)
+		if owner == o || (strings.HasPrefix(o, "@") && strings.HasSuffix(owner, o)) {
+			return true
+		}
+	}
+	return false
+}
+
+// LogEvent is a line eksctl logged while provisioning the cluster
+type LogEvent struct {
+	// Time is the UTC timestamp of when the line was logged, in milliseconds
+	Time int64 `json:"time"`
+	// Message is the line logged
+	Message string `json:"message"`
+}
+
+// LogsPage is a page of the provisioning logs of a cluster
+type LogsPage struct {
+	// ClusterID is the ID of the cluster provisioned
+	ClusterID string `json:"clusterid"`
+	// Status is the last known status of the provisioning task
+	Status string `json:"status"`
+	// Error states why provisioning failed, if it did
+	Error string `json:"error,omitempty"`
+	// Events are the log lines of this page
+	Events []LogEvent `json:"events"`
+	// Next is the token to pass as the next query
+	// parameter to get the lines logged after this page
+	Next string `json:"next"`
+	// Done is true once provisioning finished, that is,
+	// there won't be any more lines after this page
+	Done bool `json:"done"`
+}
@@ -0,0 +1,28 @@
+package eksp
+
+import "testing"
+
+func TestTemplateAllows(t *testing.T) {
+	open := Template{Name: "ci-small"}
+	restricted := Template{Name: "ci-large", Owners: []string{"Jane@example.com", "@platform.example.com"}}
+	checks := []struct {
+		t     Template
+		owner string
+		want  bool
+	}{
+		{open, "anyone@example.org", true},
+		{open, "", true},
+		{restricted, "jane@example.com", true},
+		{restricted, "JANE@EXAMPLE.COM", true},
+		{restricted, "joe@platform.example.com", true},
+		{restricted, "joe@example.com", false},
+		// domains match whole domains only:
+		{restricted, "joe@notplatform.example.com", false},
+		{restricted, "", false},
+	}
+	for _, c := range checks {
+		if got := c.t.Allows(c.owner); got != c.want {
+			t.Errorf("template %v allows %q = %v, want %v", c.t.Name, c.owner, got, c.want)
+		}
+	}
+}
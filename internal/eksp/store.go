@@ -0,0 +1,446 @@
+package eksp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// NotFoundError signals that there's no active cluster
+// for a cluster ID, ID prefix, or name
+type NotFoundError struct {
+	// Ref is the cluster reference as provided by the user
+	Ref string
+}
+
+func (e NotFoundError) Error() string {
+	return fmt.Sprintf("There is no cluster with the ID, ID prefix, or name %v", e.Ref)
+}
+
+// AmbiguousRefError signals that a cluster reference
+// matches more than one cluster
+type AmbiguousRefError struct {
+	// Ref is the cluster reference as provided by the user
+	Ref string
+	// Candidates are the IDs of all clusters matching the reference
+	Candidates []string
+}
+
+func (e AmbiguousRefError) Error() string {
+	return fmt.Sprintf("The cluster reference %v is ambiguous, it matches the clusters %v", e.Ref, strings.Join(e.Candidates, ", "))
+}
+
+// IsNoSuchKey returns true if the error is S3 telling us
+// that there's no object with the key we asked for
+func IsNoSuchKey(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == s3.ErrCodeNoSuchKey
+}
+
+// ListClusterIDs returns the IDs of all clusters
+// with a cluster spec in the given bucket
+func ListClusterIDs(bucket string) ([]string, error) {
+	clusterIDs := []string{}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return clusterIDs, err
+	}
+	svc := s3.New(cfg)
+	// only list top-level objects, that is, skip anything
+	// stored under a prefix such as idempotency records:
+	input := &s3.ListObjectsInput{
+		Bucket:    &bucket,
+		Delimiter: aws.String("/"),
+	}
+	for {
+		req := svc.ListObjectsRequest(input)
+		resp, err := req.Send(context.TODO())
+		if err != nil {
+			return clusterIDs, err
+		}
+		for _, obj := range resp.Contents {
+			fn := *obj.Key
+			clusterIDs = append(clusterIDs, strings.TrimSuffix(fn, ".json"))
+		}
+		if resp.IsTruncated == nil || !*resp.IsTruncated {
+			break
+		}
+		// with a delimiter, S3 tells us where to carry on:
+		switch {
+		case resp.NextMarker != nil:
+			input.Marker = resp.NextMarker
+		case len(resp.Contents) > 0:
+			input.Marker = resp.Contents[len(resp.Contents)-1].Key
+		default:
+			return clusterIDs, nil
+		}
+	}
+	return clusterIDs, nil
+}
+
+// ResolveClusterID returns the ID of the cluster the reference
+// points to. The reference can be the cluster ID, the cluster name,
+// or a unique prefix of the cluster ID, checked in this order. The
+// name a cluster was claimed from a warm pool under counts as its name.
+func ResolveClusterID(bucket, ref string) (string, error) {
+	if ref == "" {
+		return "", NotFoundError{Ref: ref}
+	}
+	clusterIDs, err := ListClusterIDs(bucket)
+	if err != nil {
+		return "", err
+	}
+	return resolveClusterRef(ref, clusterIDs, func(name string) (string, error) {
+		return LookupClusterByName(bucket, name)
+	})
+}
+
+// resolveClusterRef resolves the reference amongst the clusters with
+// the IDs given, looking up the ID of the cluster with a name via lookup
+func resolveClusterRef(ref string, clusterIDs []string, lookup func(name string) (string, error)) (string, error) {
+	prefixmatches := []string{}
+	for _, cID := range clusterIDs {
+		if cID == ref {
+			return cID, nil
+		}
+		if strings.HasPrefix(cID, ref) {
+			prefixmatches = append(prefixmatches, cID)
+		}
+	}
+	if IsValidClusterName(ref) {
+		cID, err := lookup(ref)
+		if err != nil {
+			return "", err
+		}
+		if cID != "" {
+			return cID, nil
+		}
+	}
+	switch len(prefixmatches) {
+	case 0:
+		return "", NotFoundError{Ref: ref}
+	case 1:
+		return prefixmatches[0], nil
+	default:
+		return "", AmbiguousRefError{Ref: ref, Candidates: prefixmatches}
+	}
+}
+
+// FetchClusterSpec returns the cluster spec
+// in a given bucket, with a given cluster ID
+func FetchClusterSpec(clusterbucket, clusterid string) (ClusterSpec, error) {
+	cs := ClusterSpec{}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return cs, err
+	}
+	downloader := s3manager.NewDownloader(cfg)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	_, err = downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(clusterid + ".json"),
+	})
+	if err != nil {
+		if IsNoSuchKey(err) {
+			return cs, NotFoundError{Ref: clusterid}
+		}
+		return cs, err
+	}
+	err = json.Unmarshal(buf.Bytes(), &cs)
+	if err != nil {
+		return cs, err
+	}
+	return cs, nil
+}
+
+// FetchClusterSpecVersion returns the cluster spec along with its ETag,
+// so that it can be updated with ReplaceClusterSpec later on
+func FetchClusterSpecVersion(clusterbucket, clusterid string) (ClusterSpec, string, error) {
+	cs := ClusterSpec{}
+	content, etag, err := FetchObject(clusterbucket, clusterid+".json")
+	if err != nil {
+		if IsNoSuchKey(err) {
+			return cs, "", NotFoundError{Ref: clusterid}
+		}
+		return cs, "", err
+	}
+	err = json.Unmarshal(content, &cs)
+	if err != nil {
+		return cs, "", err
+	}
+	return cs, etag, nil
+}
+
+// ReplaceClusterSpec stores the cluster spec if it still has the given
+// ETag, returning false if it's been changed in the meantime, in which
+// case the caller has to fetch it again to apply its changes
+func ReplaceClusterSpec(clusterbucket string, cs ClusterSpec, etag string) (bool, error) {
+	csjson, err := json.Marshal(cs)
+	if err != nil {
+		return false, err
+	}
+	replaced, err := ReplaceObjectIfUnchanged(clusterbucket, cs.ID+".json", etag, csjson)
+	if err != nil || !replaced {
+		return replaced, err
+	}
+	return true, IndexClusterNames(clusterbucket, cs)
+}
+
+// StoreClusterSpec stores the cluster spec in a given bucket,
+// indexing the cluster by its name and alias, if it has one
+func StoreClusterSpec(clusterbucket string, cs ClusterSpec) error {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	csjson, err := json.Marshal(cs)
+	if err != nil {
+		return err
+	}
+	uploader := s3manager.NewUploader(cfg)
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(cs.ID + ".json"),
+		Body:   strings.NewReader(string(csjson)),
+	})
+	if err != nil {
+		return err
+	}
+	return IndexClusterNames(clusterbucket, cs)
+}
+
+// IndexClusterNames indexes the cluster by its name and alias,
+// if it has one, so that it can be looked up by them
+func IndexClusterNames(clusterbucket string, cs ClusterSpec) error {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	uploader := s3manager.NewUploader(cfg)
+	for _, name := range namesOf(cs) {
+		_, err = uploader.Upload(&s3manager.UploadInput{
+			Bucket: aws.String(clusterbucket),
+			Key:    aws.String(NamesPrefix + name),
+			Body:   strings.NewReader(cs.ID),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NamesPrefix is the prefix in the metadata bucket under which we
+// index clusters by name, each object holding the ID of the cluster,
+// so that looking up a cluster by name doesn't scan all clusters
+const NamesPrefix = "names/"
+
+// namesOf returns the names a cluster can be looked up by
+func namesOf(cs ClusterSpec) []string {
+	names := []string{}
+	if cs.Name != "" {
+		names = append(names, cs.Name)
+	}
+	if cs.Alias != "" && cs.Alias != cs.Name {
+		names = append(names, cs.Alias)
+	}
+	return names
+}
+
+// LookupClusterByName returns the ID of the active cluster
+// with the given name, or claimed from a warm pool under the
+// given name, or an empty string if there is none
+func LookupClusterByName(clusterbucket, clustername string) (string, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return "", err
+	}
+	downloader := s3manager.NewDownloader(cfg)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	_, err = downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(NamesPrefix + clustername),
+	})
+	if err != nil {
+		if IsNoSuchKey(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	cID := string(buf.Bytes())
+	// the index entry might outlive the cluster or its alias,
+	// so only trust it if the cluster still goes by the name:
+	cs, err := FetchClusterSpec(clusterbucket, cID)
+	if err != nil {
+		if _, ok := err.(NotFoundError); ok {
+			return "", nil
+		}
+		return "", err
+	}
+	if cs.Name != clustername && cs.Alias != clustername {
+		return "", nil
+	}
+	return cID, nil
+}
+
+// ReleaseClusterNames removes the cluster from the name index, once its
+// creation failed or its cluster spec has been removed from the bucket
+func ReleaseClusterNames(clusterbucket string, cs ClusterSpec) error {
+	for _, name := range namesOf(cs) {
+		// another cluster might go by the name by now:
+		cID, _, _, err := fetchNameEntry(clusterbucket, name)
+		if err != nil {
+			if IsNoSuchKey(err) {
+				continue
+			}
+			return err
+		}
+		if cID != cs.ID {
+			continue
+		}
+		err = DeleteObject(clusterbucket, NamesPrefix+name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nameReservationTimeout is how long the name of a cluster being
+// created stays reserved without the cluster spec being stored
+const nameReservationTimeout = 5 * time.Minute
+
+// ReserveClusterName reserves the name for the cluster with the given ID
+// before its cluster spec is stored, so that of concurrent creations of
+// clusters with the same name only one succeeds. Returns the ID of the
+// cluster going by the name if it's taken, or an empty string otherwise.
+func ReserveClusterName(clusterbucket, name, clusterID string) (string, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		reserved, err := PutObjectIfAbsent(clusterbucket, NamesPrefix+name, []byte(clusterID))
+		if err != nil || reserved {
+			return "", err
+		}
+		holder, etag, modified, err := fetchNameEntry(clusterbucket, name)
+		if err != nil {
+			// released in the meantime, so try again:
+			if IsNoSuchKey(err) {
+				continue
+			}
+			return "", err
+		}
+		stale, err := staleNameEntry(clusterbucket, name, holder, modified)
+		if err != nil {
+			return "", err
+		}
+		if !stale {
+			return holder, nil
+		}
+		// the index entry outlived the cluster or its alias, so take it over,
+		// unless someone else did so in the meantime:
+		replaced, err := ReplaceObjectIfUnchanged(clusterbucket, NamesPrefix+name, etag, []byte(clusterID))
+		if err != nil || replaced {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("Can't reserve the cluster name %v, it's in high demand right now", name)
+}
+
+// staleNameEntry returns true if the cluster the name index entry
+// refers to doesn't go by the name anymore, or is gone and not
+// about to be created either
+func staleNameEntry(clusterbucket, name, clusterID string, modified time.Time) (bool, error) {
+	cs, err := FetchClusterSpec(clusterbucket, clusterID)
+	if err != nil {
+		if _, ok := err.(NotFoundError); ok {
+			return time.Since(modified) > nameReservationTimeout, nil
+		}
+		return false, err
+	}
+	return cs.Name != name && cs.Alias != name, nil
+}
+
+// fetchNameEntry returns the cluster ID the name index entry
+// for the name holds, along with its ETag and modification time
+func fetchNameEntry(clusterbucket, name string) (string, string, time.Time, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	svc := s3.New(cfg)
+	req := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(NamesPrefix + name),
+	})
+	resp, err := req.Send(context.TODO())
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return string(content), aws.StringValue(resp.ETag), aws.TimeValue(resp.LastModified), nil
+}
+
+// ArchivePrefix is the prefix in the metadata bucket under
+// which the specs of torn down clusters are kept
+const ArchivePrefix = "archive/"
+
+// LookupTombstone returns the tombstone of the cluster with
+// the given ID or nil if there never was such a cluster
+func LookupTombstone(bucket, clusterid string) (*Tombstone, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return nil, err
+	}
+	downloader := s3manager.NewDownloader(cfg)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	_, err = downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(ArchivePrefix + clusterid + ".json"),
+	})
+	if err != nil {
+		if IsNoSuchKey(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ts := &Tombstone{}
+	err = json.Unmarshal(buf.Bytes(), ts)
+	if err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// ListClusterSpecs returns the cluster specs of all
+// clusters with a cluster spec in the given bucket
+func ListClusterSpecs(clusterbucket string) ([]ClusterSpec, error) {
+	specs := []ClusterSpec{}
+	clusterIDs, err := ListClusterIDs(clusterbucket)
+	if err != nil {
+		return specs, err
+	}
+	for _, cID := range clusterIDs {
+		cs, err := FetchClusterSpec(clusterbucket, cID)
+		if err != nil {
+			// the cluster might have been torn down in the meantime:
+			if _, ok := err.(NotFoundError); ok {
+				continue
+			}
+			return specs, err
+		}
+		specs = append(specs, cs)
+	}
+	return specs, nil
+}
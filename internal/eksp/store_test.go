@@ -0,0 +1,83 @@
+package eksp
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestResolveClusterRef(t *testing.T) {
+	names := map[string]string{
+		"mh9-eksp": "e2b4f7c0-1111",
+		"e2b4f7c0": "e2b4f7c0-2222",
+		"payments": "a8d10f3e-3333",
+	}
+	clusterIDs := []string{"e2b4f7c0-1111", "e2b4f7c0-2222", "a8d10f3e-3333"}
+	lookup := func(name string) (string, error) {
+		return names[name], nil
+	}
+
+	t.Run("exact ID", func(t *testing.T) {
+		cID, err := resolveClusterRef("e2b4f7c0-1111", clusterIDs, lookup)
+		if err != nil || cID != "e2b4f7c0-1111" {
+			t.Errorf("got %q, %v", cID, err)
+		}
+	})
+	t.Run("unique ID prefix", func(t *testing.T) {
+		cID, err := resolveClusterRef("a8d", clusterIDs, lookup)
+		if err != nil || cID != "a8d10f3e-3333" {
+			t.Errorf("got %q, %v", cID, err)
+		}
+	})
+	t.Run("name", func(t *testing.T) {
+		cID, err := resolveClusterRef("payments", clusterIDs, lookup)
+		if err != nil || cID != "a8d10f3e-3333" {
+			t.Errorf("got %q, %v", cID, err)
+		}
+	})
+	t.Run("name before ambiguous prefix", func(t *testing.T) {
+		cID, err := resolveClusterRef("e2b4f7c0", clusterIDs, lookup)
+		if err != nil || cID != "e2b4f7c0-2222" {
+			t.Errorf("got %q, %v", cID, err)
+		}
+	})
+	t.Run("ambiguous prefix", func(t *testing.T) {
+		_, err := resolveClusterRef("e2b", clusterIDs, lookup)
+		rerr, ok := err.(AmbiguousRefError)
+		if !ok || !reflect.DeepEqual(rerr.Candidates, []string{"e2b4f7c0-1111", "e2b4f7c0-2222"}) {
+			t.Errorf("got error %v, want it to list both candidates", err)
+		}
+	})
+	t.Run("unknown", func(t *testing.T) {
+		_, err := resolveClusterRef("checkout", clusterIDs, lookup)
+		if _, ok := err.(NotFoundError); !ok {
+			t.Errorf("got error %v, want a not found error", err)
+		}
+	})
+	t.Run("failing lookup", func(t *testing.T) {
+		failure := errors.New("access denied")
+		_, err := resolveClusterRef("checkout", clusterIDs, func(string) (string, error) {
+			return "", failure
+		})
+		if err != failure {
+			t.Errorf("got error %v, want %v", err, failure)
+		}
+	})
+}
+
+func TestNamesOf(t *testing.T) {
+	tests := []struct {
+		cs    ClusterSpec
+		names []string
+	}{
+		{ClusterSpec{Name: "mh9-eksp"}, []string{"mh9-eksp"}},
+		{ClusterSpec{Name: "ci-1a2b3c", Alias: "pr-42"}, []string{"ci-1a2b3c", "pr-42"}},
+		{ClusterSpec{Name: "pr-42", Alias: "pr-42"}, []string{"pr-42"}},
+		{ClusterSpec{}, []string{}},
+	}
+	for _, tt := range tests {
+		if names := namesOf(tt.cs); !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("namesOf(%+v) = %v, want %v", tt.cs, names, tt.names)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package eksp
+
+import (
+	"fmt"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxNameLength is the maximum length of an EKS cluster name
+	maxNameLength = 100
+	// minWorkers is the minimum number of worker nodes we provision
+	minWorkers = 1
+	// maxWorkers is the maximum number of worker nodes we provision
+	maxWorkers = 50
+	// maxVolumeSize is the maximum size of a root volume in GiB
+	maxVolumeSize = 16384
+	// maxTagKeyLength is the maximum length of an AWS tag key
+	maxTagKeyLength = 128
+	// maxTagValueLength is the maximum length of an AWS tag value
+	maxTagValueLength = 256
+	// ReservedTagPrefix is the prefix of the tags EKSphemeral
+	// puts on the AWS resources of a cluster itself
+	ReservedTagPrefix = "eksphemeral.io/"
+	// maxLabelLength is the maximum length of the name
+	// of a label key and of a label value
+	maxLabelLength = 63
+	// defaultMaxTimeout is the maximum timeout in minutes, a week,
+	// unless configured otherwise via MAX_TIMEOUT
+	defaultMaxTimeout = 7 * 24 * 60
+)
+
+// supportedKubeVersions lists the Kubernetes versions EKS (and with it
+// eksctl) can provision at the moment
+var supportedKubeVersions = []string{"1.11", "1.12", "1.13", "1.14"}
+
+// supportedIAMAddons lists the IAM add-on policies
+// eksctl can grant to the worker nodes
+var supportedIAMAddons = []string{"imageBuilder", "autoScaler", "externalDNS", "certManager", "appMesh", "ebs", "fsx", "efs", "albIngress", "xRay", "cloudWatch"}
+
+// expiryPolicies lists what can happen to a cluster once its timeout expired
+var expiryPolicies = []string{"destroy", "hibernate"}
+
+// taintEffects lists the effects a taint can have
+var taintEffects = []string{"NoSchedule", "PreferNoSchedule", "NoExecute"}
+
+// regionRE captures what AWS region names look like, such as us-east-2
+var regionRE = regexp.MustCompile(`^[a-z]{2}(-gov)?-[a-z]+-[0-9]$`)
+
+// instanceTypeRE captures what EC2 instance types look like, such as m5.large
+var instanceTypeRE = regexp.MustCompile(`^[a-z][a-z0-9-]*\.[a-z0-9]+$`)
+
+// labelRE captures what the name of a label key and a label value look like,
+// following Kubernetes: alphanumerics, with -, _, and . in between
+var labelRE = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+
+// labelPrefixRE captures the optional prefix of a label key, a DNS subdomain
+var labelPrefixRE = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+
+// clusterNameRE captures the EKS cluster name rules, further restricted
+// to lower case so that the name can be used in stack names and labels
+var clusterNameRE = regexp.MustCompile(`^[a-z][-a-z0-9]*$`)
+
+// FieldError represents a validation issue with a single field
+// of a cluster spec
+type FieldError struct {
+	// Field is the JSON name of the offending field
+	Field string `json:"field"`
+	// Message describes what is wrong with the field value
+	Message string `json:"message"`
+}
+
+// ValidateClusterSpec checks the user provided parts of a cluster spec
+// and returns all issues found, one per offending field
+func ValidateClusterSpec(cs ClusterSpec) []FieldError {
+	ferrs := []FieldError{}
+	switch {
+	case cs.Name == "":
+		ferrs = append(ferrs, FieldError{"name", "must not be empty"})
+	case len(cs.Name) > maxNameLength:
+		ferrs = append(ferrs, FieldError{"name", fmt.Sprintf("must be at most %d characters long", maxNameLength)})
+	case !clusterNameRE.MatchString(cs.Name):
+		ferrs = append(ferrs, FieldError{"name", "must start with a lower case letter and only contain lower case letters, digits, and hyphens"})
+	}
+	// with nodegroups, the number of worker nodes follows from them:
+	if len(cs.NodeGroups) == 0 && (cs.NumWorkers < minWorkers || cs.NumWorkers > maxWorkers) {
+		ferrs = append(ferrs, FieldError{"numworkers", fmt.Sprintf("must be between %d and %d", minWorkers, maxWorkers)})
+	}
+	if !isSupportedKubeVersion(cs.KubeVersion) {
+		ferrs = append(ferrs, FieldError{"kubeversion", fmt.Sprintf("must be one of %v", supportedKubeVersions)})
+	}
+	if cs.Region != "" && !regionRE.MatchString(cs.Region) {
+		ferrs = append(ferrs, FieldError{"region", "must be an AWS region such as us-east-2"})
+	}
+	if cs.InstanceType != "" && !instanceTypeRE.MatchString(cs.InstanceType) {
+		ferrs = append(ferrs, FieldError{"instancetype", "must be an EC2 instance type such as m5.large"})
+	}
+	if msg := validateTags(cs.Tags); msg != "" {
+		ferrs = append(ferrs, FieldError{"tags", msg})
+	}
+	if msg := validateLabels(cs.Labels, cs.Tags); msg != "" {
+		ferrs = append(ferrs, FieldError{"labels", msg})
+	}
+	for _, addon := range cs.IAMAddons {
+		if !Contains(supportedIAMAddons, addon) {
+			ferrs = append(ferrs, FieldError{"iamaddons", fmt.Sprintf("must only contain %v", supportedIAMAddons)})
+			break
+		}
+	}
+	ferrs = append(ferrs, validateNodeGroups(cs.NodeGroups)...)
+	// the maximum timeout depends on the caller, see TimeoutCapError:
+	if cs.Timeout <= 0 {
+		ferrs = append(ferrs, FieldError{"timeout", "must be a positive number of minutes"})
+	}
+	if cs.Schedule != nil {
+		if _, err := ParseSchedule(*cs.Schedule); err != nil {
+			ferrs = append(ferrs, FieldError{"schedule", err.Error()})
+		}
+	}
+	if cs.OnExpiry != "" && !Contains(expiryPolicies, cs.OnExpiry) {
+		ferrs = append(ferrs, FieldError{"onexpiry", fmt.Sprintf("must be one of %v", expiryPolicies)})
+	}
+	if cs.Owner != "" && !IsValidEmail(cs.Owner) {
+		ferrs = append(ferrs, FieldError{"owner", "must be a plain email address such as jane@example.com"})
+	}
+	for i, coowner := range cs.CoOwners {
+		if !IsValidEmail(coowner) {
+			ferrs = append(ferrs, FieldError{fmt.Sprintf("coowners[%d]", i), "must be a plain email address such as jane@example.com"})
+		}
+	}
+	return ferrs
+}
+
+// validateNodeGroups checks the nodegroups of a cluster spec and
+// returns all issues found, one per offending nodegroup field
+func validateNodeGroups(ngs []NodeGroup) []FieldError {
+	ferrs := []FieldError{}
+	if len(ngs) == 0 {
+		return ferrs
+	}
+	names := map[string]bool{}
+	desired, max := 0, 0
+	for i, ng := range ngs {
+		field := func(name string) string {
+			return fmt.Sprintf("nodegroups[%d].%v", i, name)
+		}
+		switch {
+		case ng.Name == "":
+			ferrs = append(ferrs, FieldError{field("name"), "must not be empty"})
+		case !clusterNameRE.MatchString(ng.Name):
+			ferrs = append(ferrs, FieldError{field("name"), "must start with a lower case letter and only contain lower case letters, digits, and hyphens"})
+		case names[ng.Name]:
+			ferrs = append(ferrs, FieldError{field("name"), "must be unique within the cluster"})
+		}
+		names[ng.Name] = true
+		if ng.InstanceType != "" && !instanceTypeRE.MatchString(ng.InstanceType) {
+			ferrs = append(ferrs, FieldError{field("instancetype"), "must be an EC2 instance type such as m5.large"})
+		}
+		switch {
+		case ng.DesiredCapacity < 0:
+			ferrs = append(ferrs, FieldError{field("desired"), "must not be negative"})
+		case ng.MinSize > ng.DesiredCapacity:
+			ferrs = append(ferrs, FieldError{field("minsize"), "must not be greater than desired"})
+		case ng.MaxSize != 0 && ng.MaxSize < ng.DesiredCapacity:
+			ferrs = append(ferrs, FieldError{field("maxsize"), "must not be less than desired"})
+		}
+		desired += ng.DesiredCapacity
+		max += MaxSizeOf(ng)
+		for k := range ng.Labels {
+			if k == "" {
+				ferrs = append(ferrs, FieldError{field("labels"), "must not have empty keys"})
+				break
+			}
+		}
+		for k, v := range ng.Taints {
+			parts := strings.SplitN(v, ":", 2)
+			if k == "" || len(parts) != 2 || !Contains(taintEffects, parts[1]) {
+				ferrs = append(ferrs, FieldError{field("taints"), fmt.Sprintf("must map keys to value:effect, with effect one of %v", taintEffects)})
+				break
+			}
+		}
+		if ng.VolumeSize < 0 || ng.VolumeSize > maxVolumeSize {
+			ferrs = append(ferrs, FieldError{field("volumesize"), fmt.Sprintf("must be between 1 and %d GiB, or 0 for the eksctl default", maxVolumeSize)})
+		}
+	}
+	if desired < minWorkers || max > maxWorkers {
+		ferrs = append(ferrs, FieldError{"nodegroups", fmt.Sprintf("must have between %d and %d worker nodes in total, up to the maximum size of each nodegroup", minWorkers, maxWorkers)})
+	}
+	return ferrs
+}
+
+// MaxSizeOf returns the maximum number of nodes of the nodegroup
+func MaxSizeOf(ng NodeGroup) int {
+	if ng.MaxSize == 0 {
+		return ng.DesiredCapacity
+	}
+	return ng.MaxSize
+}
+
+// NumWorkersOf returns the number of worker nodes the cluster launches with
+func NumWorkersOf(cs ClusterSpec) int {
+	if len(cs.NodeGroups) == 0 {
+		return cs.NumWorkers
+	}
+	n := 0
+	for _, ng := range cs.NodeGroups {
+		n += ng.DesiredCapacity
+	}
+	return n
+}
+
+// validateTags checks the tags against the AWS tagging rules
+// and returns what's wrong with them, if anything
+func validateTags(tags map[string]string) string {
+	for k, v := range tags {
+		switch {
+		case k == "":
+			return "must not have empty keys"
+		case len(k) > maxTagKeyLength:
+			return fmt.Sprintf("must have keys of at most %d characters", maxTagKeyLength)
+		case len(v) > maxTagValueLength:
+			return fmt.Sprintf("must have values of at most %d characters", maxTagValueLength)
+		case strings.HasPrefix(k, "aws:") || strings.HasPrefix(k, ReservedTagPrefix):
+			return fmt.Sprintf("must not have keys starting with aws: or %v", ReservedTagPrefix)
+		}
+	}
+	return ""
+}
+
+// validateLabels checks the labels the way Kubernetes does, and makes sure
+// they can be put on AWS resources along with the tags, returning what's
+// wrong with them, if anything
+func validateLabels(labels, tags map[string]string) string {
+	for k, v := range labels {
+		name := k
+		if i := strings.LastIndex(k, "/"); i >= 0 {
+			prefix := k[:i]
+			name = k[i+1:]
+			if !labelPrefixRE.MatchString(prefix) || len(prefix) > 253 {
+				return fmt.Sprintf("must have key prefixes that are DNS subdomains, unlike %q", k)
+			}
+		}
+		switch {
+		case len(name) > maxLabelLength || !labelRE.MatchString(name):
+			return fmt.Sprintf("must have keys of at most %d alphanumerics, -, _, and . characters, unlike %q", maxLabelLength, k)
+		case v != "" && (len(v) > maxLabelLength || !labelRE.MatchString(v)):
+			return fmt.Sprintf("must have values of at most %d alphanumerics, -, _, and . characters, unlike the one of %q", maxLabelLength, k)
+		case strings.HasPrefix(k, "aws:") || strings.HasPrefix(k, ReservedTagPrefix):
+			return fmt.Sprintf("must not have keys starting with aws: or %v", ReservedTagPrefix)
+		}
+		if tv, ok := tags[k]; ok && tv != v {
+			return fmt.Sprintf("must not have keys also used as tags, unlike %q", k)
+		}
+	}
+	return ""
+}
+
+// isSupportedKubeVersion returns true if the Kubernetes version
+// can be provisioned
+func isSupportedKubeVersion(version string) bool {
+	return Contains(supportedKubeVersions, version)
+}
+
+// Contains returns true if the value is one of the values
+func Contains(values []string, value string) bool {
+	for _, v := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxTimeout returns the maximum timeout in minutes a cluster may have,
+// configured via MAX_TIMEOUT and defaulting to a week
+func MaxTimeout() int {
+	max, err := strconv.Atoi(os.Getenv("MAX_TIMEOUT"))
+	if err != nil || max <= 0 {
+		return defaultMaxTimeout
+	}
+	return max
+}
+
+// IsValidClusterName returns true if the name is a valid name of
+// an EKS cluster, such as a cluster name or the name of a template
+func IsValidClusterName(name string) bool {
+	return clusterNameRE.MatchString(name) && len(name) <= maxNameLength
+}
+
+// IsValidEmail returns true if the address is a RFC 5322 address
+// without a display name, such as jane@example.com
+func IsValidEmail(address string) bool {
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return false
+	}
+	return addr.Address == address
+}
@@ -0,0 +1,136 @@
+package eksp
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// validSpec returns a cluster spec that passes validation,
+// for the tests to break one field at a time
+func validSpec() ClusterSpec {
+	return ClusterSpec{
+		Name:        "mh9-eksp",
+		NumWorkers:  2,
+		KubeVersion: "1.14",
+		Timeout:     60,
+	}
+}
+
+func TestValidateClusterSpec(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cs *ClusterSpec)
+		fields []string
+	}{
+		{"valid", func(cs *ClusterSpec) {}, nil},
+		{"valid with owner", func(cs *ClusterSpec) { cs.Owner = "jane@example.com" }, nil},
+		{"empty name", func(cs *ClusterSpec) { cs.Name = "" }, []string{"name"}},
+		{"upper case name", func(cs *ClusterSpec) { cs.Name = "MH9" }, []string{"name"}},
+		{"name starting with a digit", func(cs *ClusterSpec) { cs.Name = "9mh" }, []string{"name"}},
+		{"too long name", func(cs *ClusterSpec) { cs.Name = "a" + strings.Repeat("b", maxNameLength) }, []string{"name"}},
+		{"no workers", func(cs *ClusterSpec) { cs.NumWorkers = 0 }, []string{"numworkers"}},
+		{"too many workers", func(cs *ClusterSpec) { cs.NumWorkers = maxWorkers + 1 }, []string{"numworkers"}},
+		{"unsupported Kubernetes version", func(cs *ClusterSpec) { cs.KubeVersion = "1.10" }, []string{"kubeversion"}},
+		{"no timeout", func(cs *ClusterSpec) { cs.Timeout = 0 }, []string{"timeout"}},
+		{"timeout beyond the maximum, which depends on the caller", func(cs *ClusterSpec) { cs.Timeout = defaultMaxTimeout + 1 }, nil},
+		{"owner with display name", func(cs *ClusterSpec) { cs.Owner = "Jane <jane@example.com>" }, []string{"owner"}},
+		{"valid with placement", func(cs *ClusterSpec) {
+			cs.Region = "eu-west-1"
+			cs.InstanceType = "t3.xlarge"
+			cs.Tags = map[string]string{"cost-center": "1234"}
+			cs.IAMAddons = []string{"autoScaler", "cloudWatch"}
+		}, nil},
+		{"malformed region", func(cs *ClusterSpec) { cs.Region = "Ohio" }, []string{"region"}},
+		{"malformed instance type", func(cs *ClusterSpec) { cs.InstanceType = "large" }, []string{"instancetype"}},
+		{"reserved tag", func(cs *ClusterSpec) { cs.Tags = map[string]string{ReservedTagPrefix + "cluster-id": "x"} }, []string{"tags"}},
+		{"AWS tag", func(cs *ClusterSpec) { cs.Tags = map[string]string{"aws:cloudformation:stack-name": "x"} }, []string{"tags"}},
+		{"too long tag value", func(cs *ClusterSpec) {
+			cs.Tags = map[string]string{"purpose": strings.Repeat("x", maxTagValueLength+1)}
+		}, []string{"tags"}},
+		{"unknown IAM add-on", func(cs *ClusterSpec) { cs.IAMAddons = []string{"appMesh", "root"} }, []string{"iamaddons"}},
+		{"hibernate on expiry", func(cs *ClusterSpec) { cs.OnExpiry = "hibernate" }, nil},
+		{"unknown expiry policy", func(cs *ClusterSpec) { cs.OnExpiry = "archive" }, []string{"onexpiry"}},
+		{"working hours", func(cs *ClusterSpec) {
+			cs.Schedule = &Schedule{Days: "Mon-Fri", Start: "08:00", End: "19:00", TimeZone: "Europe/Berlin"}
+		}, nil},
+		{"night shift", func(cs *ClusterSpec) {
+			cs.Schedule = &Schedule{Days: "Mon-Fri", Start: "22:00", End: "06:00", TimeZone: "Europe/Berlin"}
+		}, []string{"schedule"}},
+		{"labels", func(cs *ClusterSpec) {
+			cs.Labels = map[string]string{"team": "payments", "example.com/ticket": "OPS-42", "temporary": ""}
+		}, nil},
+		{"label key with spaces", func(cs *ClusterSpec) { cs.Labels = map[string]string{"cost center": "1234"} }, []string{"labels"}},
+		{"label key with upper case prefix", func(cs *ClusterSpec) { cs.Labels = map[string]string{"Example.com/team": "payments"} }, []string{"labels"}},
+		{"label value ending in a hyphen", func(cs *ClusterSpec) { cs.Labels = map[string]string{"team": "payments-"} }, []string{"labels"}},
+		{"label clashing with a tag", func(cs *ClusterSpec) {
+			cs.Tags = map[string]string{"team": "payments"}
+			cs.Labels = map[string]string{"team": "checkout"}
+		}, []string{"labels"}},
+		{"co-owners", func(cs *ClusterSpec) { cs.CoOwners = []string{"jane@example.com", "joe@example.com"} }, nil},
+		{"co-owner without domain", func(cs *ClusterSpec) { cs.CoOwners = []string{"jane@example.com", "joe"} }, []string{"coowners[1]"}},
+		{"several issues", func(cs *ClusterSpec) {
+			cs.Name = ""
+			cs.NumWorkers = 0
+			cs.Timeout = -1
+		}, []string{"name", "numworkers", "timeout"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := validSpec()
+			tt.change(&cs)
+			ferrs := ValidateClusterSpec(cs)
+			fields := []string{}
+			for _, ferr := range ferrs {
+				fields = append(fields, ferr.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("got issues with %v, want %v: %v", fields, tt.fields, ferrs)
+			}
+		})
+	}
+}
+
+func TestIsValidClusterName(t *testing.T) {
+	valid := []string{"mh9-eksp", "a", strings.Repeat("a", maxNameLength)}
+	for _, name := range valid {
+		if !IsValidClusterName(name) {
+			t.Errorf("%q is not a valid cluster name, want it to be", name)
+		}
+	}
+	invalid := []string{"", "-eksp", "eksp_1", "Eksp", strings.Repeat("a", maxNameLength+1)}
+	for _, name := range invalid {
+		if IsValidClusterName(name) {
+			t.Errorf("%q is a valid cluster name, want it not to be", name)
+		}
+	}
+}
+
+func TestIsValidEmail(t *testing.T) {
+	for address, valid := range map[string]bool{
+		"jane@example.com":        true,
+		"jane":                    false,
+		"Jane <jane@example.com>": false,
+		"":                        false,
+	} {
+		if got := IsValidEmail(address); got != valid {
+			t.Errorf("IsValidEmail(%q) = %v, want %v", address, got, valid)
+		}
+	}
+}
+
+func TestMaxTimeout(t *testing.T) {
+	defer os.Setenv("MAX_TIMEOUT", os.Getenv("MAX_TIMEOUT"))
+	for setting, want := range map[string]int{
+		"":      defaultMaxTimeout,
+		"480":   480,
+		"0":     defaultMaxTimeout,
+		"-60":   defaultMaxTimeout,
+		"a day": defaultMaxTimeout,
+	} {
+		os.Setenv("MAX_TIMEOUT", setting)
+		if got := MaxTimeout(); got != want {
+			t.Errorf("MaxTimeout() with MAX_TIMEOUT=%q = %d, want %d", setting, got, want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package eksp
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+)
+
+// warmPoolsConfigKey is the key of the object in the metadata bucket
+// holding the warm pools, uploaded at install time
+const warmPoolsConfigKey = "config/warmpools.json"
+
+// ClaimsPrefix is the prefix in the metadata bucket under which we
+// keep a marker per claimed cluster, so that of concurrent claims
+// only one gets the cluster
+const ClaimsPrefix = "claims/"
+
+// ClaimKey returns the key of the claim marker of the cluster
+func ClaimKey(clusterID string) string {
+	return ClaimsPrefix + clusterID + ".json"
+}
+
+// ReadyToClaim returns true if the cluster is an idle cluster of the
+// warm pool that is fully provisioned, worker nodes included
+func ReadyToClaim(cs ClusterSpec, pool string) bool {
+	return cs.WarmPool == pool && cs.Phase == "" && cs.TeardownReason == "" &&
+		cs.ActivationTime != "" && (cs.ProvisioningTask == "" || cs.ProvisioningStatus == TaskStopped)
+}
+
+// WarmPool is a number of idle clusters the control plane
+// keeps ready, so that users can claim one right away
+type WarmPool struct {
+	// Name identifies the pool, and is the prefix
+	// of the names of the clusters in the pool
+	Name string `json:"name"`
+	// Size is the number of idle clusters kept ready
+	Size int `json:"size"`
+	// Spec is the cluster spec the clusters in the pool are created from,
+	// with the same defaults as for creating a cluster, except for the name
+	Spec json.RawMessage `json:"spec"`
+}
+
+// FetchWarmPools returns the warm pools of this installation,
+// if there are any
+func FetchWarmPools(clusterbucket string) ([]WarmPool, error) {
+	pools := []WarmPool{}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return pools, err
+	}
+	downloader := s3manager.NewDownloader(cfg)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	_, err = downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(warmPoolsConfigKey),
+	})
+	if err != nil {
+		if IsNoSuchKey(err) {
+			return pools, nil
+		}
+		return pools, err
+	}
+	err = json.Unmarshal(buf.Bytes(), &pools)
+	return pools, err
+}
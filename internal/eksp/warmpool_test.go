@@ -0,0 +1,36 @@
+package eksp
+
+import "testing"
+
+func TestReadyToClaim(t *testing.T) {
+	idle := func() ClusterSpec {
+		return ClusterSpec{
+			ID:                 "e-abc123",
+			Name:               "ci-1a2b3c",
+			WarmPool:           "ci",
+			ActivationTime:     "1561000000",
+			ProvisioningTask:   "arn:aws:ecs:us-east-2:123456789012:task/eksphemeral/0123",
+			ProvisioningStatus: TaskStopped,
+		}
+	}
+	if !ReadyToClaim(idle(), "ci") {
+		t.Error("idle cluster isn't ready, want it to be")
+	}
+	if ReadyToClaim(idle(), "preview") {
+		t.Error("cluster is ready to be claimed from another pool")
+	}
+	tests := map[string]func(cs *ClusterSpec){
+		"claimed":                  func(cs *ClusterSpec) { cs.WarmPool = "" },
+		"not yet active":           func(cs *ClusterSpec) { cs.ActivationTime = "" },
+		"still creating the nodes": func(cs *ClusterSpec) { cs.ProvisioningStatus = "RUNNING" },
+		"hibernated":               func(cs *ClusterSpec) { cs.Phase = PhaseHibernated },
+		"deleted":                  func(cs *ClusterSpec) { cs.TeardownReason = "deleted by jane@example.com" },
+	}
+	for name, change := range tests {
+		cs := idle()
+		change(&cs)
+		if ReadyToClaim(cs, "ci") {
+			t.Errorf("%v cluster is ready, want it not to be", name)
+		}
+	}
+}
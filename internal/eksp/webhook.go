@@ -0,0 +1,287 @@
+package eksp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	awslambda "github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+)
+
+const (
+	// webhooksConfigKey is the key of the object in the metadata bucket
+	// holding the webhook subscriptions, uploaded at install time
+	webhooksConfigKey = "config/webhooks.json"
+	// deliveriesPrefix is the prefix in the metadata bucket under which
+	// the delivery log is kept, one object per delivery, grouped by cluster
+	deliveriesPrefix = "webhooks/deliveries/"
+	// webhookAttempts is how often we try to deliver a payload
+	webhookAttempts = 3
+	// webhookTimeout is how long we wait for a subscriber to respond
+	webhookTimeout = 3 * time.Second
+)
+
+// WebhookSubscription is a subscriber interested in cluster lifecycle events
+type WebhookSubscription struct {
+	// URL is where the payloads are POSTed to
+	URL string `json:"url"`
+	// Secret is used to sign the payloads, which subscribers can verify
+	// using the X-EKSphemeral-Signature and X-EKSphemeral-Timestamp headers
+	Secret string `json:"secret"`
+	// Events are the lifecycle events the subscriber is interested in,
+	// for example created or deleted. If empty, all events are delivered.
+	Events []string `json:"events"`
+}
+
+// WebhookEvent is a lifecycle event, as handed from the function
+// that caused it to the webhooks function, which delivers it
+type WebhookEvent struct {
+	// Event is the lifecycle event, such as created or deleted
+	Event string `json:"event"`
+	// Time is the UTC timestamp of when the event happened
+	Time string `json:"time"`
+	// Cluster is the cluster spec as of the event
+	Cluster ClusterSpec `json:"cluster"`
+}
+
+// WebhookPayload is what we POST to subscribers
+type WebhookPayload struct {
+	// DeliveryID uniquely identifies the delivery
+	DeliveryID string `json:"delivery"`
+	// Event is the lifecycle event, such as created or deleted
+	Event string `json:"event"`
+	// Time is the UTC timestamp of when the event happened
+	Time string `json:"time"`
+	// Cluster is the cluster spec as of the event
+	Cluster ClusterSpec `json:"cluster"`
+}
+
+// WebhookDelivery is an entry in the delivery log
+type WebhookDelivery struct {
+	// DeliveryID identifies the delivery, as sent to the subscriber
+	DeliveryID string `json:"delivery"`
+	// URL is where the payload was POSTed to
+	URL string `json:"url"`
+	// Event is the lifecycle event delivered
+	Event string `json:"event"`
+	// ClusterID is the ID of the cluster the event is about
+	ClusterID string `json:"clusterid"`
+	// Time is the UTC timestamp of the last attempt
+	Time string `json:"time"`
+	// Attempts is how often we tried to deliver the payload
+	Attempts int `json:"attempts"`
+	// StatusCode is the HTTP status code of the last attempt, if any
+	StatusCode int `json:"statuscode,omitempty"`
+	// Error is why the last attempt failed, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// wants returns true if the subscriber is interested in the event
+func (sub WebhookSubscription) wants(event string) bool {
+	if len(sub.Events) == 0 {
+		return true
+	}
+	for _, e := range sub.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchWebhookSubscriptions returns the webhook subscriptions
+// of this installation, if there are any
+func fetchWebhookSubscriptions(clusterbucket string) ([]WebhookSubscription, error) {
+	subs := []WebhookSubscription{}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return subs, err
+	}
+	downloader := s3manager.NewDownloader(cfg)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	_, err = downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(webhooksConfigKey),
+	})
+	if err != nil {
+		if IsNoSuchKey(err) {
+			return subs, nil
+		}
+		return subs, err
+	}
+	err = json.Unmarshal(buf.Bytes(), &subs)
+	return subs, err
+}
+
+// Sign returns the hex-encoded HMAC-SHA256 of the timestamp and the
+// payload, joined by a dot. Signing the timestamp, too, lets subscribers
+// reject replayed deliveries.
+func Sign(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newDeliveryID returns a random ID for a delivery
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// deliver POSTs the payload to the subscriber, retrying with
+// backoff on network errors, 429s, and server errors
+func deliver(sub WebhookSubscription, event, deliveryID string, payload []byte) WebhookDelivery {
+	d := WebhookDelivery{
+		DeliveryID: deliveryID,
+		URL:        sub.URL,
+		Event:      event,
+	}
+	c := &http.Client{
+		Timeout: webhookTimeout,
+	}
+	backoff := 500 * time.Millisecond
+	for d.Attempts < webhookAttempts {
+		if d.Attempts > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		d.Attempts++
+		d.Time = fmt.Sprintf("%v", time.Now().Unix())
+		req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(payload))
+		if err != nil {
+			d.Error = err.Error()
+			return d
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-EKSphemeral-Event", event)
+		req.Header.Set("X-EKSphemeral-Delivery", deliveryID)
+		req.Header.Set("X-EKSphemeral-Timestamp", d.Time)
+		if sub.Secret != "" {
+			req.Header.Set("X-EKSphemeral-Signature", "sha256="+Sign(sub.Secret, d.Time, payload))
+		}
+		res, err := c.Do(req)
+		if err != nil {
+			d.StatusCode, d.Error = 0, err.Error()
+			continue
+		}
+		res.Body.Close()
+		d.StatusCode = res.StatusCode
+		switch {
+		case res.StatusCode < 300:
+			d.Error = ""
+			return d
+		case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+			d.Error = res.Status
+		default: // the subscriber rejected the payload, no point in retrying
+			d.Error = res.Status
+			return d
+		}
+	}
+	return d
+}
+
+// recordDelivery appends the delivery to the delivery log
+func recordDelivery(clusterbucket string, d WebhookDelivery) error {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	djson, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	uploader := s3manager.NewUploader(cfg)
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(fmt.Sprintf("%v%v/%v-%v.json", deliveriesPrefix, d.ClusterID, time.Now().UnixNano(), d.DeliveryID)),
+		Body:   strings.NewReader(string(djson) + "\n"),
+	})
+	return err
+}
+
+// EmitWebhook hands the lifecycle event to the webhooks function, which
+// delivers it to all subscribers interested in it. Delivery is asynchronous
+// and best effort: failures end up in the delivery log rather than failing,
+// or holding up, the operation that caused the event.
+func EmitWebhook(clusterbucket, event string, cs ClusterSpec) {
+	fn := os.Getenv("WEBHOOKS_FUNCTION")
+	if fn == "" {
+		fmt.Printf("Can't emit %v event for cluster %v, there's no webhooks function configured\n", event, cs.ID)
+		return
+	}
+	payload, err := json.Marshal(WebhookEvent{
+		Event:   event,
+		Time:    fmt.Sprintf("%v", time.Now().Unix()),
+		Cluster: cs,
+	})
+	if err != nil {
+		fmt.Printf("Can't marshal %v event for cluster %v: %v\n", event, cs.ID, err)
+		return
+	}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		fmt.Printf("Can't emit %v event for cluster %v: %v\n", event, cs.ID, err)
+		return
+	}
+	svc := awslambda.New(cfg)
+	req := svc.InvokeRequest(&awslambda.InvokeInput{
+		FunctionName:   aws.String(fn),
+		InvocationType: awslambda.InvocationTypeEvent,
+		Payload:        payload,
+	})
+	_, err = req.Send(context.TODO())
+	if err != nil {
+		fmt.Printf("Can't emit %v event for cluster %v: %v\n", event, cs.ID, err)
+	}
+}
+
+// DeliverWebhooks delivers the lifecycle event to all subscribers
+// interested in it, recording each delivery in the delivery log
+func DeliverWebhooks(clusterbucket string, ev WebhookEvent) {
+	subs, err := fetchWebhookSubscriptions(clusterbucket)
+	if err != nil {
+		fmt.Printf("Can't look up webhook subscriptions: %v\n", err)
+		return
+	}
+	for _, sub := range subs {
+		if !sub.wants(ev.Event) {
+			continue
+		}
+		deliveryID := newDeliveryID()
+		payload, err := json.Marshal(WebhookPayload{
+			DeliveryID: deliveryID,
+			Event:      ev.Event,
+			Time:       ev.Time,
+			Cluster:    ev.Cluster,
+		})
+		if err != nil {
+			fmt.Printf("Can't marshal %v webhook payload for cluster %v: %v\n", ev.Event, ev.Cluster.ID, err)
+			return
+		}
+		fmt.Printf("DEBUG:: delivering %v event for cluster %v to %v\n", ev.Event, ev.Cluster.ID, sub.URL)
+		d := deliver(sub, ev.Event, deliveryID, payload)
+		d.ClusterID = ev.Cluster.ID
+		if d.Error != "" {
+			fmt.Printf("Can't deliver %v event for cluster %v to %v after %v attempts: %v\n", ev.Event, ev.Cluster.ID, sub.URL, d.Attempts, d.Error)
+		}
+		err = recordDelivery(clusterbucket, d)
+		if err != nil {
+			fmt.Printf("Can't record delivery %v in delivery log: %v\n", deliveryID, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package eksp
+
+import (
+	"crypto/hmac"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	payload := []byte(`{"event":"created"}`)
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		payload   []byte
+		signature string
+	}{
+		{"payload", "s3cr3t", "1571496000", payload, "7a78c5e73053db6bfc51ca47292f8fb6585d51e5c1ca7c5127ce76958dd74e6d"},
+		{"other timestamp", "s3cr3t", "1571496001", payload, "250e42e173dd06c35c911af1f376f29adeadd7be076be2d6d3ba0b42946dda56"},
+		{"other secret", "other", "1571496000", payload, "d4981b0fe140480a691a3f2fe4d6b375efc85b228eef112367a767055092bd74"},
+		{"empty payload", "s3cr3t", "1571496000", []byte{}, "ef2f8c24bff72315dcbdc9e3fbae1cd85ab89e8ae5aa597fed84bb362c2b5766"},
+	}
+	for _, tt := range tests {
+		if got := Sign(tt.secret, tt.timestamp, tt.payload); got != tt.signature {
+			t.Errorf("%v: Sign(%q, %q, %s) = %v, want %v", tt.name, tt.secret, tt.timestamp, tt.payload, got, tt.signature)
+		}
+	}
+}
+
+func TestDeliverSigned(t *testing.T) {
+	payload := []byte(`{"delivery":"d-1","event":"created"}`)
+	tests := []struct {
+		name     string
+		secret   string
+		statuses []int
+		attempts int
+		failed   bool
+	}{
+		{"delivered", "s3cr3t", []int{http.StatusOK}, 1, false},
+		{"delivered unsigned", "", []int{http.StatusNoContent}, 1, false},
+		{"delivered on retry", "s3cr3t", []int{http.StatusServiceUnavailable, http.StatusOK}, 2, false},
+		{"rejected", "s3cr3t", []int{http.StatusBadRequest}, 1, true},
+		{"failing", "s3cr3t", []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusBadGateway}, webhookAttempts, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				if string(body) != string(payload) {
+					t.Errorf("got payload %s, want %s", body, payload)
+				}
+				if r.Header.Get("X-EKSphemeral-Event") != "created" || r.Header.Get("X-EKSphemeral-Delivery") != "d-1" {
+					t.Errorf("got event %q and delivery %q", r.Header.Get("X-EKSphemeral-Event"), r.Header.Get("X-EKSphemeral-Delivery"))
+				}
+				// verify the signature the way subscribers do:
+				signature := r.Header.Get("X-EKSphemeral-Signature")
+				switch {
+				case tt.secret == "" && signature != "":
+					t.Errorf("got signature %q without a secret", signature)
+				case tt.secret != "":
+					expected := "sha256=" + Sign(tt.secret, r.Header.Get("X-EKSphemeral-Timestamp"), body)
+					if !hmac.Equal([]byte(signature), []byte(expected)) {
+						t.Errorf("got signature %q, want %q", signature, expected)
+					}
+				}
+				w.WriteHeader(tt.statuses[calls])
+				calls++
+			}))
+			defer srv.Close()
+			d := deliver(WebhookSubscription{URL: srv.URL, Secret: tt.secret}, "created", "d-1", payload)
+			if d.Attempts != tt.attempts || calls != tt.attempts {
+				t.Errorf("delivered in %v attempts with %v calls, want %v", d.Attempts, calls, tt.attempts)
+			}
+			if failed := d.Error != ""; failed != tt.failed {
+				t.Errorf("delivery failed is %v, want %v: %v", failed, tt.failed, d.Error)
+			}
+			if d.StatusCode != tt.statuses[len(tt.statuses)-1] {
+				t.Errorf("got status code %v, want %v", d.StatusCode, tt.statuses[len(tt.statuses)-1])
+			}
+		})
+	}
+}
+
+func TestWants(t *testing.T) {
+	tests := []struct {
+		events []string
+		event  string
+		wants  bool
+	}{
+		{nil, "created", true},
+		{[]string{"created", "deleted"}, "deleted", true},
+		{[]string{"created"}, "prolonged", false},
+	}
+	for _, tt := range tests {
+		if got := (WebhookSubscription{Events: tt.events}).wants(tt.event); got != tt.wants {
+			t.Errorf("subscription to %v wants %v is %v, want %v", tt.events, tt.event, got, tt.wants)
+		}
+	}
+}
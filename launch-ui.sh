@@ -8,9 +8,19 @@ set -o pipefail
 ###############################################################################
 ### DEPENDENCIES CHECKS
 
+# the API key and ID token, if any, are kept in the config file of the CLI:
+if [[ -z "${EKSPHEMERAL_API_KEY:-}" && -f "$HOME/.eksphemeral/config.json" ]]
+then
+  export EKSPHEMERAL_API_KEY=$(jq '.apikey // empty' -r "$HOME/.eksphemeral/config.json")
+fi
+if [[ -z "${EKSPHEMERAL_TOKEN:-}" && -f "$HOME/.eksphemeral/config.json" ]]
+then
+  export EKSPHEMERAL_TOKEN=$(jq '.token // empty' -r "$HOME/.eksphemeral/config.json")
+fi
+
 EKSPHEMERAL_URL=$(aws cloudformation describe-stacks --stack-name eksp | jq '.Stacks[].Outputs[] | select(.OutputKey=="EKSphemeralAPIEndpoint").OutputValue' -r)
 
-CONTROLPLANE_STATUS=$(curl -sL -w "%{http_code}" -o /dev/null "$EKSPHEMERAL_URL/status/*")
+CONTROLPLANE_STATUS=$(curl -sL --header "X-EKSphemeral-Key: ${EKSPHEMERAL_API_KEY:-}" --header "Authorization: Bearer ${EKSPHEMERAL_TOKEN:-}" -w "%{http_code}" -o /dev/null "$EKSPHEMERAL_URL/status/*")
 
 if [ ! $CONTROLPLANE_STATUS == "200" ]
 then
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// deviceCodeGrant is the grant type of the device authorization flow, see RFC 8628
+const deviceCodeGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+// DeviceAuthorization is what the issuer responds with
+// when starting the device authorization flow
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// TokenResponse is what the issuer responds with when polled for the tokens
+type TokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+// discoverEndpoints returns the device authorization and
+// the token endpoint of the issuer, via OIDC discovery
+func discoverEndpoints(c *http.Client, issuer string) (string, string, error) {
+	resp, err := c.Get(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("discovery failed with status %v", resp.StatusCode)
+	}
+	discovery := struct {
+		DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+		TokenEndpoint               string `json:"token_endpoint"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&discovery)
+	if err != nil {
+		return "", "", err
+	}
+	if discovery.DeviceAuthorizationEndpoint == "" {
+		return "", "", fmt.Errorf("%v doesn't support the device authorization flow", issuer)
+	}
+	return discovery.DeviceAuthorizationEndpoint, discovery.TokenEndpoint, nil
+}
+
+// postForm posts the form to the endpoint and decodes the
+// JSON response into v, no matter the HTTP status code
+func postForm(c *http.Client, endpoint string, form url.Values, v interface{}) error {
+	resp, err := c.PostForm(endpoint, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// login signs the user in with the issuer via the device authorization
+// flow: the user confirms the code shown in the browser while we poll
+// the issuer, until it hands out the ID token or gives up
+func login(issuer, clientID, scope string) (string, error) {
+	c := &http.Client{
+		Timeout: time.Second * 30,
+	}
+	deviceEndpoint, tokenEndpoint, err := discoverEndpoints(c, issuer)
+	if err != nil {
+		return "", err
+	}
+	da := DeviceAuthorization{}
+	err = postForm(c, deviceEndpoint, url.Values{"client_id": {clientID}, "scope": {scope}}, &da)
+	if err != nil {
+		return "", err
+	}
+	if da.DeviceCode == "" {
+		return "", fmt.Errorf("%v didn't start the device authorization flow for client %v", issuer, clientID)
+	}
+	if da.VerificationURIComplete != "" {
+		pinfo(fmt.Sprintf("Please open %v in your browser and confirm the code %v", da.VerificationURIComplete, da.UserCode))
+	} else {
+		pinfo(fmt.Sprintf("Please open %v in your browser and enter the code %v", da.VerificationURI, da.UserCode))
+	}
+	interval := time.Duration(da.Interval) * time.Second
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(da.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		tr := TokenResponse{}
+		err = postForm(c, tokenEndpoint, url.Values{
+			"grant_type":  {deviceCodeGrant},
+			"device_code": {da.DeviceCode},
+			"client_id":   {clientID},
+		}, &tr)
+		if err != nil {
+			return "", err
+		}
+		switch tr.Error {
+		case "":
+			if tr.IDToken == "" {
+				return "", fmt.Errorf("%v didn't hand out an ID token", issuer)
+			}
+			return tr.IDToken, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return "", fmt.Errorf("you denied the login")
+		default:
+			return "", fmt.Errorf("%v refused the login: %v", issuer, tr.Error)
+		}
+	}
+	return "", fmt.Errorf("the code expired before you confirmed it")
+}
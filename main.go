@@ -4,50 +4,31 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/url"
 	"os"
 	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
 	"text/tabwriter"
-)
+	"time"
 
-// ClusterSpec represents the parameters for eksctl,
-// as cluster metadata including owner and how long the cluster
-// still has to live.
-type ClusterSpec struct {
-	// ID is a unique identifier for the cluster
-	ID string `json:"id"`
-	// Name specifies the cluster name
-	Name string `json:"name"`
-	// NumWorkers specifies the number of worker nodes, defaults to 1
-	NumWorkers int `json:"numworkers"`
-	// KubeVersion  specifies the Kubernetes version to use, defaults to `1.12`
-	KubeVersion string `json:"kubeversion"`
-	// Timeout specifies the timeout in minutes, after which the cluster
-	// is destroyed, defaults to 10
-	Timeout int `json:"timeout"`
-	// Timeout specifies the cluster time to live in minutes.
-	// In other words: the remaining time the cluster has before it is destroyed
-	TTL int `json:"ttl"`
-	// Owner specifies the email address of the owner (will be notified when cluster is created and 5 min before destruction)
-	Owner string `json:"owner"`
-	// CreationTime is the UTC timestamp of when the cluster was created
-	// which equals the point in time of the creation of the respective
-	// JSON representation of the cluster spec as an object in the metadata
-	// bucket
-	CreationTime string `json:"created"`
-	// ClusterDetails is only valid for lookup of individual clusters,
-	// that is, when user does, for example, a eksp l CLUSTERID. It
-	// holds info such as cluster status and config
-	ClusterDetails map[string]string `json:"details"`
-}
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
 
 var Version string
 
+// logsPollInterval is how often eksp logs -f polls for new lines
+const logsPollInterval = 5 * time.Second
+
 func main() {
 	if len(os.Args) <= 1 {
 		pinfo(fmt.Sprintf("This is EKSphemeral in version %v", Version))
-		perr("Please specify one of the following commands: install, uninstall, create, list, or prolong", nil)
+		perr("Please specify one of the following commands: install, uninstall, create, list, prolong, wake, delete, history, events, logs, render, schedule, claim, transfer, template, apikey, or login", nil)
 		os.Exit(1)
 	}
 	eksphome, ok := os.LookupEnv("EKSPHEMERAL_HOME")
@@ -55,6 +36,18 @@ func main() {
 		perr("Please set the EKSPHEMERAL_HOME environment variable ", nil)
 		os.Exit(1)
 	}
+	// the scripts authenticate with the API key or ID token from the config, unless set:
+	cfg, err := loadConfig()
+	if err != nil {
+		perr("Can't read config from "+configPath(), err)
+		os.Exit(1)
+	}
+	if _, ok := os.LookupEnv("EKSPHEMERAL_API_KEY"); !ok && cfg.APIKey != "" {
+		os.Setenv("EKSPHEMERAL_API_KEY", cfg.APIKey)
+	}
+	if _, ok := os.LookupEnv("EKSPHEMERAL_TOKEN"); !ok && cfg.Token != "" {
+		os.Setenv("EKSPHEMERAL_TOKEN", cfg.Token)
+	}
 
 	cmd := os.Args[1]
 	switch cmd {
@@ -66,44 +59,231 @@ func main() {
 		shellout(eksphome + "/eksp-down.sh")
 	case "create", "c":
 		pinfo("Trying to create a new ephemeral cluster ...")
-		if len(os.Args) > 2 {
-			clusterSpecFile := os.Args[2]
+		cfs := flag.NewFlagSet("create", flag.ExitOnError)
+		template := cfs.String("template", "", "create the cluster from this template, the cluster spec provided takes precedence")
+		name := cfs.String("name", "", "the name of the cluster, takes precedence over the cluster spec")
+		team := cfs.String("team", "", "the team owning the cluster together with you, takes precedence over the cluster spec")
+		_ = cfs.Parse(os.Args[2:])
+		clusterSpecFile := cfs.Arg(0)
+		if clusterSpecFile != "" {
 			pinfo("... using cluster spec " + clusterSpecFile)
 			if _, err := os.Stat(clusterSpecFile); os.IsNotExist(err) {
 				perr("Can't create a cluster due to invalid spec:", err)
 				os.Exit(2)
 			}
+		}
+		if *template != "" || *name != "" || *team != "" {
+			if *template != "" {
+				pinfo("... using template " + *template)
+			}
+			overlay, ok := overlaySpecFile(clusterSpecFile, *template, *name, *team)
+			if !ok {
+				os.Exit(2)
+			}
+			// the control plane validates the spec, since only it knows the template:
+			shellout(eksphome+"/eksp-create.sh", overlay)
+			os.Remove(overlay)
+			break
+		}
+		if clusterSpecFile != "" {
+			if !checkSpecFile(clusterSpecFile) {
+				os.Exit(2)
+			}
 			shellout(eksphome+"/eksp-create.sh", clusterSpecFile)
 			break
 		}
 		// creating cluster with defaults:
-		shellout(eksphome+"/eksp-create.sh", os.Args[2])
+		shellout(eksphome + "/eksp-create.sh")
 	case "list", "ls", "l":
-		if len(os.Args) > 2 { // we have a cluster ID, try looking up cluster spec
-			cID := os.Args[2]
-			res := bshellout(eksphome+"/eksp-list.sh", cID)
-			cs, err := parseCS(res)
+		lfs := flag.NewFlagSet("list", flag.ExitOnError)
+		selector := lfs.String("l", "", "only list clusters with matching labels, such as team=payments,purpose!=demo")
+		_ = lfs.Parse(os.Args[2:])
+		if lfs.NArg() > 0 { // we have a cluster ID, try looking up cluster spec
+			cref := lfs.Arg(0)
+			res := bshellout(eksphome+"/eksp-list.sh", cref)
+			cs := eksp.ClusterSpec{}
+			err := eksp.ParseResponse(res, &cs)
 			if err != nil {
-				perr("Can't render cluster details. Cluster could be gone or control plane is down :(", nil)
+				perr(explainLookupFailure(cref, err), nil)
 				break
 			}
-			cs.ID = cID
-			fmt.Println(cs)
+			fmt.Println(renderCluster(cs))
 			break
 		}
 		// listing all cluster:
-		res := bshellout(eksphome + "/eksp-list.sh")
+		q := url.Values{}
+		if *selector != "" {
+			q.Set("selector", *selector)
+		}
+		res := bshellout(eksphome+"/eksp-list.sh", "", q.Encode())
 		listClusters(eksphome, res)
 	case "prolong", "p":
 		if len(os.Args) < 4 {
-			perr("Can't prolong cluster lifetime without both the cluster ID and the time in minutes provided", nil)
+			perr("Can't prolong cluster lifetime without both the cluster ID (or ID prefix, or name) and the time in minutes provided", nil)
 			os.Exit(3)
 		}
-		cID := os.Args[2]
+		cref := os.Args[2]
 		prolongFor := os.Args[3]
-		shellout(eksphome+"/eksp-prolong.sh", cID, prolongFor)
+		res := bshellout(eksphome+"/eksp-prolong.sh", cref, prolongFor)
+		cs := eksp.ClusterSpec{}
+		err := eksp.ParseResponse(res, &cs)
+		if err != nil {
+			perr("Can't prolong cluster lifetime: "+explainLookupFailure(cref, err), nil)
+			os.Exit(3)
+		}
+		pinfo(fmt.Sprintf("Successfully prolonged the lifetime of cluster %v (%v), it now has %d min to live", cs.Name, cs.ID, cs.TTL))
+	case "wake", "w":
+		if len(os.Args) < 3 {
+			perr("Can't wake up cluster without the cluster ID, ID prefix, or name provided", nil)
+			os.Exit(3)
+		}
+		cref := os.Args[2]
+		res := bshellout(eksphome+"/eksp-wake.sh", cref)
+		cs := eksp.ClusterSpec{}
+		err := eksp.ParseResponse(res, &cs)
+		if err != nil {
+			perr("Can't wake up cluster: "+explainLookupFailure(cref, err), nil)
+			os.Exit(3)
+		}
+		pinfo(fmt.Sprintf("Successfully woke up cluster %v (%v), its worker nodes are coming back and it now has %d min to live", cs.Name, cs.ID, cs.TTL))
+	case "delete", "d":
+		if len(os.Args) < 3 {
+			perr("Can't delete cluster without the cluster ID, ID prefix, or name provided", nil)
+			os.Exit(3)
+		}
+		cref := os.Args[2]
+		res := bshellout(eksphome+"/eksp-delete.sh", cref)
+		cs := eksp.ClusterSpec{}
+		err := eksp.ParseResponse(res, &cs)
+		if err != nil {
+			perr("Can't delete cluster: "+explainLookupFailure(cref, err), nil)
+			os.Exit(3)
+		}
+		pinfo(fmt.Sprintf("Successfully scheduled cluster %v (%v) for tear down, this can take some 15 minutes", cs.Name, cs.ID))
+	case "transfer":
+		tfs := flag.NewFlagSet("transfer", flag.ExitOnError)
+		coowners := tfs.String("coowners", "", "comma-separated email addresses of the co-owners, replacing the current ones, an empty string removes them")
+		_ = tfs.Parse(os.Args[2:])
+		coownersSet := false
+		tfs.Visit(func(f *flag.Flag) {
+			coownersSet = coownersSet || f.Name == "coowners"
+		})
+		if tfs.NArg() < 2 && !(tfs.NArg() == 1 && coownersSet) {
+			perr("Can't transfer cluster without both the cluster ID (or ID prefix, or name) and the email address of the new owner provided", nil)
+			os.Exit(3)
+		}
+		cref := tfs.Arg(0)
+		transfer := map[string]interface{}{"owner": tfs.Arg(1)}
+		if coownersSet {
+			list := []string{}
+			for _, coowner := range strings.Split(*coowners, ",") {
+				if coowner = strings.TrimSpace(coowner); coowner != "" {
+					list = append(list, coowner)
+				}
+			}
+			transfer["coowners"] = list
+		}
+		req, _ := json.Marshal(transfer)
+		res := bshellout(eksphome+"/eksp-transfer.sh", cref, string(req))
+		cs := eksp.ClusterSpec{}
+		err := eksp.ParseResponse(res, &cs)
+		if err != nil {
+			perr("Can't transfer cluster: "+explainLookupFailure(cref, err), nil)
+			os.Exit(3)
+		}
+		pinfo(fmt.Sprintf("Successfully transferred cluster %v (%v), it's now owned by %v", cs.Name, cs.ID, renderOwner(cs)))
+	case "history", "h":
+		hfs := flag.NewFlagSet("history", flag.ExitOnError)
+		owner := hfs.String("owner", "", "only list clusters owned by this email address")
+		since := hfs.String("since", "", "only list clusters deleted at or after this date, such as 2019-07-08")
+		until := hfs.String("until", "", "only list clusters deleted on or before this date, such as 2019-07-08")
+		_ = hfs.Parse(os.Args[2:])
+		q := url.Values{}
+		for param, value := range map[string]string{"owner": *owner, "since": *since, "until": *until} {
+			if value != "" {
+				q.Set(param, value)
+			}
+		}
+		res := bshellout(eksphome+"/eksp-history.sh", q.Encode())
+		listHistory(res)
+	case "events", "e":
+		cref := "*"
+		if len(os.Args) > 2 { // we have a cluster ID, only list its events
+			cref = os.Args[2]
+		}
+		res := bshellout(eksphome+"/eksp-events.sh", cref)
+		listEvents(cref, res)
+	case "logs":
+		lfs := flag.NewFlagSet("logs", flag.ExitOnError)
+		follow := lfs.Bool("f", false, "keep following the logs until provisioning is done")
+		_ = lfs.Parse(os.Args[2:])
+		if lfs.NArg() < 1 {
+			perr("Can't show provisioning logs without the cluster ID, ID prefix, or name provided", nil)
+			os.Exit(3)
+		}
+		showLogs(eksphome, lfs.Arg(0), *follow)
+	case "render", "r":
+		if len(os.Args) < 3 {
+			perr("Can't render the eksctl config without a cluster spec provided", nil)
+			os.Exit(2)
+		}
+		if !renderSpecFile(os.Args[2]) {
+			os.Exit(2)
+		}
+	case "schedule", "s":
+		manageSchedules(eksphome, os.Args[2:])
+	case "claim":
+		cfs := flag.NewFlagSet("claim", flag.ExitOnError)
+		name := cfs.String("name", "", "the name to claim the cluster under")
+		owner := cfs.String("owner", "", "the email address of the owner")
+		timeout := cfs.Int("timeout", 0, "the timeout in minutes, defaults to the timeout of the warm pool's clusters")
+		_ = cfs.Parse(os.Args[2:])
+		if cfs.NArg() < 1 {
+			perr("Can't claim a cluster without the warm pool provided", nil)
+			os.Exit(3)
+		}
+		pool := cfs.Arg(0)
+		claim, _ := json.Marshal(map[string]interface{}{"name": *name, "owner": *owner, "timeout": *timeout})
+		res := bshellout(eksphome+"/eksp-claim.sh", pool, string(claim))
+		cs := eksp.ClusterSpec{}
+		err := eksp.ParseResponse(res, &cs)
+		if err != nil {
+			perr("Can't claim cluster: "+explainRequestFailure(err), nil)
+			os.Exit(3)
+		}
+		as := ""
+		if cs.Alias != "" {
+			as = " as " + cs.Alias
+		}
+		pinfo(fmt.Sprintf("Successfully claimed cluster %v (%v) from warm pool %v%v, kubectl is configured to use it and it has %d min to live", cs.Name, cs.ID, pool, as, cs.TTL))
+	case "template", "t":
+		manageTemplates(eksphome, os.Args[2:])
+	case "apikey":
+		manageAPIKeys(eksphome, os.Args[2:])
+	case "login":
+		lfs := flag.NewFlagSet("login", flag.ExitOnError)
+		issuer := lfs.String("issuer", eksp.EnvOr("EKSPHEMERAL_OIDC_ISSUER", cfg.Issuer), "the URL of the OIDC provider")
+		clientID := lfs.String("client-id", eksp.EnvOr("EKSPHEMERAL_OIDC_CLIENT_ID", cfg.ClientID), "the client ID of EKSphemeral with the OIDC provider")
+		scope := lfs.String("scope", "openid email profile", "the scopes to request")
+		_ = lfs.Parse(os.Args[2:])
+		if *issuer == "" || *clientID == "" {
+			perr("Can't log in without the OIDC provider and client ID provided, via -issuer and -client-id", nil)
+			os.Exit(3)
+		}
+		token, err := login(*issuer, *clientID, *scope)
+		if err != nil {
+			perr("Can't log in", err)
+			os.Exit(3)
+		}
+		cfg.Issuer, cfg.ClientID, cfg.Token = *issuer, *clientID, token
+		err = storeConfig(cfg)
+		if err != nil {
+			perr("Can't write config to "+configPath(), err)
+			os.Exit(3)
+		}
+		pinfo("Successfully logged in, the ID token is stored in " + configPath())
 	default:
-		perr("Please specify one of the following commands: install, uninstall, create, list, or prolong", nil)
+		perr("Please specify one of the following commands: install, uninstall, create, list, prolong, wake, delete, history, events, logs, render, schedule, claim, transfer, template, apikey, or login", nil)
 	}
 }
 
@@ -199,19 +379,352 @@ func perr(msg string, err error) {
 	_, _ = fmt.Fprintf(os.Stderr, "\x1b[91m%v\x1b[0m\n", msg)
 }
 
-func parseCS(clusterspec string) (cs ClusterSpec, err error) {
-	err = json.Unmarshal([]byte(clusterspec), &cs)
+// parseCS parses the cluster spec, with the defaults the control plane applies
+func parseCS(clusterspec string) (eksp.ClusterSpec, error) {
+	// same defaults as the control plane applies:
+	cs := eksp.ClusterSpec{
+		Name:        "unknown",
+		NumWorkers:  1,
+		KubeVersion: "1.12",
+		Timeout:     10,
+		TTL:         10,
+		Owner:       eksp.DefaultOwner,
+	}
+	err := json.Unmarshal([]byte(clusterspec), &cs)
+	return cs, err
+}
+
+// checkSpecFile validates the cluster spec in the file provided and
+// reports all issues found, returning true if the spec is good to go
+func checkSpecFile(clusterSpecFile string) bool {
+	raw, err := ioutil.ReadFile(clusterSpecFile)
+	if err != nil {
+		perr("Can't read cluster spec:", err)
+		return false
+	}
+	cs, err := parseCS(string(raw))
+	if err != nil {
+		perr("Can't create a cluster due to invalid spec:", err)
+		return false
+	}
+	ferrs := eksp.ValidateClusterSpec(cs)
+	if len(ferrs) == 0 {
+		return true
+	}
+	perr("Can't create a cluster due to invalid spec", nil)
+	for _, ferr := range ferrs {
+		perr(fmt.Sprintf("  %v %v", ferr.Field, ferr.Message), nil)
+	}
+	return false
+}
+
+// renderSpecFile prints the eksctl ClusterConfig manifest the control plane
+// would provision the cluster in the spec file with, for review,
+// returning true if the spec could be rendered
+func renderSpecFile(clusterSpecFile string) bool {
+	raw, err := ioutil.ReadFile(clusterSpecFile)
+	if err != nil {
+		perr("Can't read cluster spec:", err)
+		return false
+	}
+	cs, err := parseCS(string(raw))
+	if err != nil {
+		perr("Can't render the eksctl config due to invalid spec:", err)
+		return false
+	}
+	ferrs := eksp.ValidateClusterSpec(cs)
+	if len(ferrs) > 0 {
+		perr("Can't render the eksctl config due to invalid spec", nil)
+		for _, ferr := range ferrs {
+			perr(fmt.Sprintf("  %v %v", ferr.Field, ferr.Message), nil)
+		}
+		return false
+	}
+	cs.NumWorkers = eksp.NumWorkersOf(cs)
+	cc, err := eksp.RenderClusterConfig(cs, defaultRegion())
+	if err != nil {
+		perr("Can't render the eksctl config:", err)
+		return false
+	}
+	fmt.Print(cc)
+	return true
+}
+
+// overlaySpecFile writes a cluster spec referring to the template, if
+// any, with the name, the team, and the fields of the spec file provided
+// taking precedence, and returns the file it was written to
+func overlaySpecFile(clusterSpecFile, template, name, team string) (string, bool) {
+	spec := map[string]interface{}{}
+	if clusterSpecFile != "" {
+		raw, err := ioutil.ReadFile(clusterSpecFile)
+		if err != nil {
+			perr("Can't read cluster spec:", err)
+			return "", false
+		}
+		err = json.Unmarshal(raw, &spec)
+		if err != nil {
+			perr("Can't create a cluster due to invalid spec:", err)
+			return "", false
+		}
+	}
+	if template != "" {
+		spec["template"] = template
+	}
+	if name != "" {
+		spec["name"] = name
+	}
+	if team != "" {
+		spec["team"] = team
+	}
+	if _, ok := spec["name"]; !ok && template != "" {
+		perr("Can't create a cluster from a template without a name, please provide one with -name", nil)
+		return "", false
+	}
+	raw, _ := json.Marshal(spec)
+	f, err := ioutil.TempFile("", "eksp-spec-*.json")
+	if err != nil {
+		perr("Can't write cluster spec:", err)
+		return "", false
+	}
+	defer f.Close()
+	_, err = f.Write(raw)
+	if err != nil {
+		perr("Can't write cluster spec:", err)
+		return "", false
+	}
+	return f.Name(), true
+}
+
+// manageTemplates lists, shows, applies, and removes the
+// templates users can create clusters from
+func manageTemplates(eksphome string, args []string) {
+	subcmd := "list"
+	if len(args) > 0 {
+		subcmd = args[0]
+	}
+	switch subcmd {
+	case "list", "ls", "l":
+		res := bshellout(eksphome+"/eksp-template.sh", "list")
+		listTemplates(res)
+	case "show":
+		if len(args) < 2 {
+			perr("Can't show template without its name provided", nil)
+			os.Exit(3)
+		}
+		res := bshellout(eksphome+"/eksp-template.sh", "show", args[1])
+		t := eksp.Template{}
+		err := eksp.ParseResponse(res, &t)
+		if err != nil {
+			perr("Can't show template: "+explainRequestFailure(err), nil)
+			os.Exit(3)
+		}
+		out, _ := json.MarshalIndent(t, "", "    ")
+		fmt.Println(string(out))
+	case "apply":
+		if len(args) < 2 {
+			perr("Can't apply template without a template file provided", nil)
+			os.Exit(2)
+		}
+		templateFile := args[1]
+		raw, err := ioutil.ReadFile(templateFile)
+		if err != nil {
+			perr("Can't read template:", err)
+			os.Exit(2)
+		}
+		t := eksp.Template{}
+		err = json.Unmarshal(raw, &t)
+		if err != nil || t.Name == "" {
+			perr("Can't apply template, it must be a JSON document with at least a name and a spec", err)
+			os.Exit(2)
+		}
+		res := bshellout(eksphome+"/eksp-template.sh", "apply", t.Name, templateFile)
+		err = eksp.ParseResponse(res, &t)
+		if err != nil {
+			perr("Can't apply template: "+explainRequestFailure(err), nil)
+			os.Exit(3)
+		}
+		pinfo(fmt.Sprintf("Successfully applied template %v, create clusters from it with eksp create -template %v -name NAME", t.Name, t.Name))
+	case "rm":
+		if len(args) < 2 {
+			perr("Can't remove template without its name provided", nil)
+			os.Exit(3)
+		}
+		res := bshellout(eksphome+"/eksp-template.sh", "rm", args[1])
+		t := eksp.Template{}
+		err := eksp.ParseResponse(res, &t)
+		if err != nil {
+			perr("Can't remove template: "+explainRequestFailure(err), nil)
+			os.Exit(3)
+		}
+		pinfo(fmt.Sprintf("Successfully removed template %v, clusters created from it are not affected", t.Name))
+	default:
+		perr("Please specify one of the following template commands: list, show, apply, or rm", nil)
+		os.Exit(1)
+	}
+}
+
+// manageAPIKeys issues, lists, and revokes the API keys users authenticate
+// with, which takes AWS credentials, and stores the API key to use
+func manageAPIKeys(eksphome string, args []string) {
+	subcmd := "list"
+	if len(args) > 0 {
+		subcmd = args[0]
+	}
+	switch subcmd {
+	case "issue":
+		ifs := flag.NewFlagSet("apikey issue", flag.ExitOnError)
+		description := ifs.String("description", "", "what the API key is used for")
+		_ = ifs.Parse(args[1:])
+		if ifs.NArg() < 1 {
+			perr("Can't issue API key without the email address of the user provided", nil)
+			os.Exit(3)
+		}
+		issue, _ := json.Marshal(map[string]string{"user": ifs.Arg(0), "description": *description})
+		res := bshellout(eksphome+"/eksp-apikey.sh", "issue", string(issue))
+		// the key itself is only ever part of the response to issuing it:
+		issued := struct {
+			eksp.APIKey
+			Key string `json:"key"`
+		}{}
+		err := eksp.ParseResponse(res, &issued)
+		if err != nil {
+			perr("Can't issue API key: "+explainRequestFailure(err), nil)
+			os.Exit(3)
+		}
+		pinfo(fmt.Sprintf("Successfully issued API key %v to %v, which is shown only this once, hand it over and have them run eksp apikey use KEY:", issued.ID, issued.User))
+		fmt.Println(issued.Key)
+	case "list", "ls", "l":
+		res := bshellout(eksphome+"/eksp-apikey.sh", "list")
+		listAPIKeys(res)
+	case "revoke":
+		if len(args) < 2 {
+			perr("Can't revoke API key without its ID provided", nil)
+			os.Exit(3)
+		}
+		res := bshellout(eksphome+"/eksp-apikey.sh", "revoke", args[1])
+		apikey := eksp.APIKey{}
+		err := eksp.ParseResponse(res, &apikey)
+		if err != nil {
+			perr("Can't revoke API key: "+explainRequestFailure(err), nil)
+			os.Exit(3)
+		}
+		pinfo(fmt.Sprintf("Successfully revoked API key %v of %v", apikey.ID, apikey.User))
+	case "use":
+		if len(args) < 2 {
+			perr("Can't use API key without the key provided", nil)
+			os.Exit(3)
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			perr("Can't read config from "+configPath(), err)
+			os.Exit(3)
+		}
+		cfg.APIKey = args[1]
+		err = storeConfig(cfg)
+		if err != nil {
+			perr("Can't write config to "+configPath(), err)
+			os.Exit(3)
+		}
+		pinfo("Successfully stored the API key in " + configPath())
+	default:
+		perr("Please specify one of the following apikey commands: issue, list, revoke, or use", nil)
+		os.Exit(1)
+	}
+}
+
+// manageSchedules adds, lists, and removes the schedules
+// the control plane creates clusters from
+func manageSchedules(eksphome string, args []string) {
+	subcmd := "list"
+	if len(args) > 0 {
+		subcmd = args[0]
+	}
+	switch subcmd {
+	case "add":
+		sfs := flag.NewFlagSet("schedule add", flag.ExitOnError)
+		at := sfs.String("at", "", "create the cluster once at this time, such as 2019-07-08T09:00:00+01:00")
+		days := sfs.String("days", "", "create a cluster on these days of the week, such as Mon-Fri")
+		tod := sfs.String("time", "", "create a cluster at this time of day, such as 07:30")
+		timezone := sfs.String("timezone", "", "the time zone of the time of day, such as Europe/Dublin, defaults to UTC")
+		_ = sfs.Parse(args[1:])
+		if sfs.NArg() < 1 {
+			perr("Can't add a schedule without a cluster spec provided", nil)
+			os.Exit(2)
+		}
+		clusterSpecFile := sfs.Arg(0)
+		if !checkSpecFile(clusterSpecFile) {
+			os.Exit(2)
+		}
+		raw, _ := ioutil.ReadFile(clusterSpecFile)
+		body, err := json.Marshal(map[string]interface{}{
+			"spec":     json.RawMessage(raw),
+			"at":       *at,
+			"days":     *days,
+			"time":     *tod,
+			"timezone": *timezone,
+		})
+		if err != nil {
+			perr("Can't add schedule:", err)
+			os.Exit(2)
+		}
+		res := bshellout(eksphome+"/eksp-schedule.sh", "add", string(body))
+		sched := eksp.CreationSchedule{}
+		err = eksp.ParseResponse(res, &sched)
+		if err != nil {
+			perr("Can't add schedule: "+explainRequestFailure(err), nil)
+			os.Exit(3)
+		}
+		pinfo(fmt.Sprintf("Successfully added schedule %v, creating cluster %v next at %v", sched.ID, sched.Spec.Name, renderTimestamp(sched.NextRun)))
+	case "list", "ls", "l":
+		res := bshellout(eksphome+"/eksp-schedule.sh", "list")
+		listSchedules(res)
+	case "rm":
+		if len(args) < 2 {
+			perr("Can't remove schedule without the schedule ID or ID prefix provided", nil)
+			os.Exit(3)
+		}
+		res := bshellout(eksphome+"/eksp-schedule.sh", "rm", args[1])
+		sched := eksp.CreationSchedule{}
+		err := eksp.ParseResponse(res, &sched)
+		if err != nil {
+			perr("Can't remove schedule: "+explainRequestFailure(err), nil)
+			os.Exit(3)
+		}
+		pinfo(fmt.Sprintf("Successfully removed schedule %v, no more clusters %v will be created from it", sched.ID, sched.Spec.Name))
+	default:
+		perr("Please specify one of the following schedule commands: add, list, or rm", nil)
+		os.Exit(1)
+	}
+}
+
+// explainRequestFailure renders why a request not referring
+// to a cluster, such as about schedules, failed
+func explainRequestFailure(err error) string {
+	if _, ok := err.(*eksp.APIError); !ok {
+		return "Can't reach the EKSphemeral control plane, is it up and running?"
+	}
+	return err.Error()
+}
+
+// defaultRegion returns the AWS region the aws CLI is configured to use,
+// which is where the control plane runs
+func defaultRegion() string {
+	if region, ok := os.LookupEnv("AWS_DEFAULT_REGION"); ok {
+		return region
+	}
+	out, err := exec.Command("aws", "configure", "get", "region").Output()
 	if err != nil {
-		return cs, err
+		return ""
 	}
-	return cs, nil
+	return strings.TrimSpace(string(out))
 }
 
 func listClusters(eksphome, cIDs string) {
 	cl := []string{}
-	err := json.Unmarshal([]byte(cIDs), &cl)
+	err := eksp.ParseResponse(cIDs, &cl)
 	if err != nil {
 		perr("Can't render cluster spec due to:", err)
+		return
 	}
 	if len(cl) == 0 {
 		pinfo("No clusters found")
@@ -220,20 +733,246 @@ func listClusters(eksphome, cIDs string) {
 
 	const padding = 3
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, padding, ' ', 0)
-	fmt.Fprintln(w, "NAME\tID\tKUBERNETES\tNUM WORKERS\tTIMEOUT\tTTL\tOWNER\t")
+	fmt.Fprintln(w, "NAME\tID\tKUBERNETES\tNUM WORKERS\tTIMEOUT\tTTL\tSCHEDULE\tOWNER\tLABELS\tCOST\t")
 	for _, cID := range cl {
 		res := bshellout(eksphome+"/eksp-list.sh", cID)
-		cs, err := parseCS(res)
+		cs := eksp.ClusterSpec{}
+		err := eksp.ParseResponse(res, &cs)
 		if err != nil {
 			continue
 		}
 		cs.ID = cID
-		fmt.Fprintf(w, "%s\t%s\tv%s\t%d\t%d min\t%d min\t%s\t\n", cs.Name, cs.ID, cs.KubeVersion, cs.NumWorkers, cs.Timeout, cs.TTL, cs.Owner)
+		labels := "-"
+		if len(cs.Labels) > 0 {
+			labels = renderKeyValues(cs.Labels)
+		}
+		fmt.Fprintf(w, "%s\t%s\tv%s\t%d\t%d min\t%s\t%s\t%s\t%s\t%s\t\n", renderName(cs), cs.ID, cs.KubeVersion, cs.NumWorkers, cs.Timeout, renderTTL(cs), renderNextTransition(cs), renderOwner(cs), labels, renderCost(cs))
+	}
+	w.Flush()
+}
+
+func listHistory(res string) {
+	history := []eksp.Tombstone{}
+	err := eksp.ParseResponse(res, &history)
+	if err != nil {
+		perr("Can't render cluster history", err)
+		return
+	}
+	if len(history) == 0 {
+		pinfo("No deleted clusters found")
+		return
+	}
+
+	const padding = 3
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, padding, ' ', 0)
+	fmt.Fprintln(w, "NAME\tID\tKUBERNETES\tNUM WORKERS\tLAUNCHED\tDELETED\tLIFETIME\tREASON\tOWNER\t")
+	for _, ts := range history {
+		launched := ts.LaunchTime
+		if launched == "" { // clusters created before we kept track of the launch time
+			launched = ts.CreationTime
+		}
+		fmt.Fprintf(w, "%s\t%s\tv%s\t%d\t%s\t%s\t%s\t%s\t%s\t\n",
+			ts.Name, ts.ID, ts.KubeVersion, ts.NumWorkers, renderTimestamp(launched), renderTimestamp(ts.DeletionTime),
+			renderLifetime(launched, ts.DeletionTime), ts.TeardownReason, ts.Owner)
+	}
+	w.Flush()
+}
+
+func listTemplates(res string) {
+	templates := []eksp.Template{}
+	err := eksp.ParseResponse(res, &templates)
+	if err != nil {
+		perr("Can't render templates: "+explainRequestFailure(err), nil)
+		return
+	}
+	if len(templates) == 0 {
+		pinfo("No templates found")
+		return
+	}
+
+	const padding = 3
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, padding, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDESCRIPTION\tRESTRICTED TO\tUPDATED\tUPDATED BY\t")
+	for _, t := range templates {
+		owners := "-"
+		if len(t.Owners) > 0 {
+			owners = strings.Join(t.Owners, ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", t.Name, t.Description, owners, renderTimestamp(t.UpdateTime), t.UpdatedBy)
+	}
+	w.Flush()
+}
+
+func listAPIKeys(res string) {
+	apikeys := []eksp.APIKey{}
+	err := eksp.ParseResponse(res, &apikeys)
+	if err != nil {
+		perr("Can't render API keys: "+explainRequestFailure(err), nil)
+		return
+	}
+	if len(apikeys) == 0 {
+		pinfo("No API keys found")
+		return
+	}
+
+	const padding = 3
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, padding, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSER\tDESCRIPTION\tISSUED\tISSUED BY\t")
+	for _, apikey := range apikeys {
+		description := apikey.Description
+		if description == "" {
+			description = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", apikey.ID, apikey.User, description, renderTimestamp(apikey.IssueTime), apikey.IssuedBy)
+	}
+	w.Flush()
+}
+
+func listSchedules(res string) {
+	schedules := []eksp.CreationSchedule{}
+	err := eksp.ParseResponse(res, &schedules)
+	if err != nil {
+		perr("Can't render schedules: "+explainRequestFailure(err), nil)
+		return
+	}
+	if len(schedules) == 0 {
+		pinfo("No schedules found")
+		return
+	}
+
+	const padding = 3
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, padding, ' ', 0)
+	fmt.Fprintln(w, "ID\tCLUSTER NAME\tWHEN\tNEXT RUN\tLAST RUN\tLAST CLUSTER\tCREATED BY\t")
+	for _, sched := range schedules {
+		nextrun, lastrun, lastcluster := "-", "-", "-"
+		if sched.NextRun != "" {
+			nextrun = renderTimestamp(sched.NextRun)
+		}
+		if sched.LastRun != "" {
+			lastrun = renderTimestamp(sched.LastRun)
+		}
+		switch {
+		case sched.LastError != "":
+			lastcluster = "failed: " + sched.LastError
+		case sched.LastClusterID != "":
+			lastcluster = sched.LastClusterID
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", sched.ID, sched.Spec.Name, renderWhen(sched), nextrun, lastrun, lastcluster, sched.CreatedBy)
+	}
+	w.Flush()
+}
+
+// renderWhen renders when a schedule creates clusters,
+// such as Mon-Fri 07:30 Europe/Dublin, or once
+func renderWhen(sched eksp.CreationSchedule) string {
+	if sched.At != "" {
+		return "once at " + sched.At
+	}
+	tz := sched.TimeZone
+	if tz == "" {
+		tz = "UTC"
+	}
+	return fmt.Sprintf("%s %s %s", sched.Days, sched.Time, tz)
+}
+
+func listEvents(cref, res string) {
+	auditlog := []eksp.Event{}
+	err := eksp.ParseResponse(res, &auditlog)
+	if err != nil {
+		perr("Can't render audit log: "+explainLookupFailure(cref, err), nil)
+		return
+	}
+	if len(auditlog) == 0 {
+		pinfo("No events found")
+		return
+	}
+
+	const padding = 3
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, padding, ' ', 0)
+	fmt.Fprintln(w, "TIME\tCLUSTER\tACTOR\tACTION\tCHANGES\t")
+	for _, event := range auditlog {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n",
+			renderTimestamp(event.Time), event.ClusterID, event.Actor, event.Action, renderChanges(event))
 	}
 	w.Flush()
 }
 
-func (cs ClusterSpec) String() string {
+// showLogs prints the lines eksctl logged while provisioning the cluster
+// and, if asked to follow them, keeps polling for new lines until
+// provisioning is done
+func showLogs(eksphome, cref string, follow bool) {
+	next := ""
+	for {
+		q := url.Values{}
+		if next != "" {
+			q.Set("next", next)
+		}
+		res := bshellout(eksphome+"/eksp-logs.sh", cref, q.Encode())
+		page := eksp.LogsPage{}
+		err := eksp.ParseResponse(res, &page)
+		if err != nil {
+			perr("Can't show provisioning logs: "+err.Error(), nil)
+			os.Exit(3)
+		}
+		for _, e := range page.Events {
+			fmt.Println(e.Message)
+		}
+		if page.Next != "" {
+			next = page.Next
+		}
+		if !follow || (page.Done && len(page.Events) == 0) {
+			if page.Error != "" {
+				perr("Provisioning failed: "+page.Error, nil)
+			}
+			return
+		}
+		time.Sleep(logsPollInterval)
+	}
+}
+
+// renderChanges renders the changed fields of an event as
+// field=before->after, and its details as key=value
+func renderChanges(event eksp.Event) string {
+	changes := []string{}
+	fields := map[string]bool{}
+	for field := range event.Before {
+		fields[field] = true
+	}
+	for field := range event.After {
+		fields[field] = true
+	}
+	for field := range fields {
+		before, after := "-", "-"
+		if v, ok := event.Before[field]; ok {
+			before = fmt.Sprintf("%v", v)
+		}
+		if v, ok := event.After[field]; ok {
+			after = fmt.Sprintf("%v", v)
+		}
+		changes = append(changes, fmt.Sprintf("%s=%s->%s", field, before, after))
+	}
+	for key, value := range event.Details {
+		changes = append(changes, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(changes)
+	return strings.Join(changes, " ")
+}
+
+// renderLifetime renders the time between two UTC timestamps in seconds
+func renderLifetime(from, to string) string {
+	fromsecs, err := strconv.ParseInt(from, 10, 64)
+	if err != nil {
+		return "?"
+	}
+	tosecs, err := strconv.ParseInt(to, 10, 64)
+	if err != nil {
+		return "?"
+	}
+	return fmt.Sprintf("%.0f min", time.Unix(tosecs, 0).Sub(time.Unix(fromsecs, 0)).Minutes())
+}
+
+// renderCluster renders the cluster spec and details of a cluster
+func renderCluster(cs eksp.ClusterSpec) string {
 	if cs.Name == "" {
 		return fmt.Sprintf("Cluster does not exist or control plane is down")
 	}
@@ -241,8 +980,143 @@ func (cs ClusterSpec) String() string {
 		cs.ClusterDetails["status"], cs.ClusterDetails["endpoint"], cs.ClusterDetails["platformv"], cs.ClusterDetails["vpcconf"], cs.ClusterDetails["iamrole"],
 	)
 
+	nodegroups := ""
+	for _, ng := range cs.NodeGroups {
+		nodegroups += fmt.Sprintf("\t%v\n", renderNodeGroup(ng))
+	}
+	if nodegroups != "" {
+		nodegroups = "Nodegroups:\n" + nodegroups
+	}
+	schedule := ""
+	if cs.Schedule != nil {
+		tz := cs.Schedule.TimeZone
+		if tz == "" {
+			tz = "UTC"
+		}
+		schedule = fmt.Sprintf("Schedule:\t%s %s-%s %s, %s\n", cs.Schedule.Days, cs.Schedule.Start, cs.Schedule.End, tz, renderNextTransition(cs))
+	}
+	metadata := ""
+	if len(cs.Labels) > 0 {
+		metadata += fmt.Sprintf("Labels:\t\t%s\n", renderKeyValues(cs.Labels))
+	}
+	if len(cs.Annotations) > 0 {
+		metadata += "Annotations:\n"
+		for _, a := range eksp.SortedTags(cs.Annotations) {
+			metadata += fmt.Sprintf("\t%s: %s\n", a.Key, a.Value)
+		}
+	}
+	cost := ""
+	if cs.Cost != nil {
+		cost = fmt.Sprintf("Cost:\t\t%s per hour, %s so far, %s projected\n",
+			eksp.RenderAmount(cs.Cost.HourlyRate, cs.Cost.Currency), eksp.RenderAmount(cs.Cost.SoFar, cs.Cost.Currency), eksp.RenderAmount(cs.Cost.Projected, cs.Cost.Currency))
+		if len(cs.Cost.Unpriced) > 0 {
+			cost += fmt.Sprintf("\t\tnot covering instance types %s, for lack of prices\n", strings.Join(cs.Cost.Unpriced, ", "))
+		}
+	}
 	return fmt.Sprintf(
-		"ID:\t\t%s\nName:\t\t%s\nKubernetes:\tv%s\nWorker nodes:\t%d\nTimeout:\t%d min\nTTL:\t\t%d min\nOwner:\t\t%s\nDetails:\n\t%s",
-		cs.ID, cs.Name, cs.KubeVersion, cs.NumWorkers, cs.Timeout, cs.TTL, cs.Owner, details,
+		"ID:\t\t%s\nName:\t\t%s\nKubernetes:\tv%s\nWorker nodes:\t%d\n%sTimeout:\t%d min\nTTL:\t\t%s\n%sOwner:\t\t%s\n%s%sDetails:\n\t%s",
+		cs.ID, renderName(cs), cs.KubeVersion, cs.NumWorkers, nodegroups, cs.Timeout, renderTTL(cs), schedule, renderOwner(cs), cost, metadata, details,
 	)
 }
+
+// renderTTL renders the time the cluster has left to live, which
+// doesn't apply to hibernated clusters and those in a warm pool
+func renderTTL(cs eksp.ClusterSpec) string {
+	if cs.Phase == eksp.PhaseHibernated {
+		return "hibernated"
+	}
+	if cs.WarmPool != "" && cs.TeardownReason == "" {
+		return "warm (" + cs.WarmPool + ")"
+	}
+	return fmt.Sprintf("%d min", cs.TTL)
+}
+
+// renderCost renders what the cluster is estimated to have cost so far
+// and to cost until it expires, marking estimates that miss instance types
+func renderCost(cs eksp.ClusterSpec) string {
+	if cs.Cost == nil {
+		return "-"
+	}
+	incomplete := ""
+	if len(cs.Cost.Unpriced) > 0 {
+		incomplete = "+"
+	}
+	return fmt.Sprintf("%s%s / %s%s", eksp.RenderAmount(cs.Cost.SoFar, cs.Cost.Currency), incomplete, eksp.RenderAmount(cs.Cost.Projected, cs.Cost.Currency), incomplete)
+}
+
+// renderName renders the name of the cluster, along with the name
+// it was claimed from a warm pool under, if it was
+func renderName(cs eksp.ClusterSpec) string {
+	if cs.Alias == "" {
+		return cs.Name
+	}
+	return fmt.Sprintf("%s (%s)", cs.Alias, cs.Name)
+}
+
+// renderOwner renders the owner and the co-owners
+// of the cluster, along with the team owning it, if any
+func renderOwner(cs eksp.ClusterSpec) string {
+	owners := strings.Join(append([]string{cs.Owner}, cs.CoOwners...), ", ")
+	if cs.Team == "" {
+		return owners
+	}
+	return fmt.Sprintf("%s (%s)", owners, cs.Team)
+}
+
+// renderNextTransition renders when the schedule of the cluster
+// next hibernates or wakes it up, in local time
+func renderNextTransition(cs eksp.ClusterSpec) string {
+	nt, err := strconv.ParseInt(cs.NextTransition, 10, 64)
+	if cs.Schedule == nil || err != nil {
+		return "-"
+	}
+	at := time.Unix(nt, 0).Format("Mon 15:04")
+	switch {
+	case cs.Phase == "":
+		return "hibernates " + at
+	case cs.Phase == eksp.PhaseHibernated && cs.HibernationReason == eksp.HibernationSchedule:
+		return "wakes up " + at
+	}
+	return "-"
+}
+
+// renderNodeGroup renders the kind and size of a nodegroup
+func renderNodeGroup(ng eksp.NodeGroup) string {
+	instanceType := ng.InstanceType
+	if instanceType == "" {
+		instanceType = "default"
+	}
+	min := ng.MinSize
+	if min == 0 {
+		min = ng.DesiredCapacity
+	}
+	sizes := fmt.Sprintf("%d", ng.DesiredCapacity)
+	if min != ng.DesiredCapacity || eksp.MaxSizeOf(ng) != ng.DesiredCapacity {
+		sizes += fmt.Sprintf(" (min %d, max %d)", min, eksp.MaxSizeOf(ng))
+	}
+	desc := fmt.Sprintf("%s:\t%s x %s", ng.Name, sizes, instanceType)
+	if ng.Spot {
+		desc += ", spot"
+	}
+	if ng.VolumeSize != 0 {
+		desc += fmt.Sprintf(", %d GiB volumes", ng.VolumeSize)
+	}
+	if len(ng.Labels) > 0 {
+		desc += ", labels " + renderKeyValues(ng.Labels)
+	}
+	if len(ng.Taints) > 0 {
+		desc += ", taints " + renderKeyValues(ng.Taints)
+	}
+	return desc
+}
+
+// renderKeyValues renders the map as a comma separated
+// list of key=value pairs, ordered by key
+func renderKeyValues(m map[string]string) string {
+	kvs := []string{}
+	for k, v := range m {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
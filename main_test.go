@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+func TestParseCS(t *testing.T) {
+	// a spec file only naming the cluster is as good as it is for the control plane:
+	cs, err := parseCS(`{"name": "mh9-eksp"}`)
+	if err != nil {
+		t.Fatalf("parseCS() failed: %v", err)
+	}
+	if cs.Name != "mh9-eksp" || cs.NumWorkers != 1 || cs.KubeVersion != "1.12" || cs.Timeout != 10 {
+		t.Errorf("parseCS() = %+v, want the defaults of the control plane", cs)
+	}
+	if ferrs := eksp.ValidateClusterSpec(cs); len(ferrs) != 0 {
+		t.Errorf("spec with defaults is invalid: %v", ferrs)
+	}
+	cs, err = parseCS(`{"name": "mh9-eksp", "numworkers": 3, "timeout": 60}`)
+	if err != nil || cs.NumWorkers != 3 || cs.Timeout != 60 {
+		t.Errorf("parseCS() = %+v, %v, want the fields of the spec to take precedence", cs, err)
+	}
+	if _, err := parseCS(`{"name":`); err == nil {
+		t.Error("parsed a spec that isn't valid JSON")
+	}
+}
@@ -0,0 +1,39 @@
+#!/usr/bin/env bash
+
+set -o errexit
+set -o errtrace
+set -o nounset
+set -o pipefail
+
+echo "Using the follwing input: cluster $CLUSTER_NAME v$KUBERNETES_VERSION with $NUM_WORKERS workers"
+
+# provision EKS control and data plane using eksctl, preferably using the
+# ClusterConfig manifest the control plane stored in the metadata bucket:
+if [[ -n "${CLUSTER_CONFIG_KEY:-}" ]]; then
+    aws s3 cp s3://$CLUSTER_METADATA_BUCKET/$CLUSTER_CONFIG_KEY /tmp/cluster.yaml
+    eksctl create cluster -f /tmp/cluster.yaml --auto-kubeconfig
+else
+    eksctl create cluster \
+        --name $CLUSTER_NAME \
+        --version $KUBERNETES_VERSION \
+        --nodes $NUM_WORKERS \
+        --auto-kubeconfig \
+        --full-ecr-access \
+        --appmesh-access
+fi
+
+export KUBECONFIG=/home/eksctl/.kube/eksctl/clusters/$CLUSTER_NAME
+
+# only the task role that created the cluster has access to it, so map the
+# role cluster owners assume into the cluster via the aws-auth config map,
+# keeping the mappings of the worker nodes eksctl put there:
+if [[ -n "${CLUSTER_ACCESS_ROLE_ARN:-}" ]]; then
+    echo "Granting $CLUSTER_ACCESS_ROLE_ARN access to cluster $CLUSTER_NAME"
+    MAP_ROLES=$(kubectl -n kube-system get configmap aws-auth -o jsonpath='{.data.mapRoles}')
+    MAP_ROLES="$MAP_ROLES
+- rolearn: $CLUSTER_ACCESS_ROLE_ARN
+  username: eksphemeral-owner
+  groups:
+    - system:masters"
+    kubectl -n kube-system create configmap aws-auth --from-literal=mapRoles="$MAP_ROLES" --dry-run -o yaml | kubectl apply -f -
+fi
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// explainLookupFailure renders why looking up the cluster failed, telling
+// apart clusters that never existed, clusters that have been deleted,
+// and a control plane that can't be reached
+func explainLookupFailure(cref string, err error) string {
+	apierr, ok := err.(*eksp.APIError)
+	if !ok {
+		return "Can't reach the EKSphemeral control plane, is it up and running?"
+	}
+	if apierr.Code != "not_found" {
+		return apierr.Error()
+	}
+	ts := eksp.Tombstone{}
+	if apierr.DetailsAs(&ts) != nil || ts.DeletionTime == "" {
+		return fmt.Sprintf("There is no cluster with the ID, ID prefix, or name %v", cref)
+	}
+	return fmt.Sprintf("Cluster %v (%v) was deleted at %v", ts.Name, ts.ID, renderTimestamp(ts.DeletionTime))
+}
+
+// renderTimestamp renders a UTC timestamp in seconds in local time
+func renderTimestamp(ts string) string {
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ts
+	}
+	return time.Unix(secs, 0).Format("2006-01-02 15:04 MST")
+}
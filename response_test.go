@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// apiErrorOf returns the error the CLI sees for the response
+func apiErrorOf(t *testing.T, statuscode int, code, message string, details interface{}) error {
+	res, err := eksp.RespondError(statuscode, code, message, details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return eksp.ParseResponse(res.Body, &eksp.ClusterSpec{})
+}
+
+func TestExplainLookupFailure(t *testing.T) {
+	local := time.Local
+	time.Local = time.UTC
+	defer func() { time.Local = local }()
+
+	ts := eksp.Tombstone{
+		ClusterSpec:  eksp.ClusterSpec{ID: "e2b4f7c0-1111", Name: "mh9-eksp"},
+		DeletionTime: "1571496000",
+	}
+	tests := []struct {
+		err         error
+		explanation string
+	}{
+		{errors.New("connection refused"), "Can't reach the EKSphemeral control plane"},
+		{apiErrorOf(t, http.StatusNotFound, "not_found", "no such cluster", nil), "There is no cluster with the ID, ID prefix, or name mh9"},
+		{apiErrorOf(t, http.StatusNotFound, "not_found", "deleted", ts), "Cluster mh9-eksp (e2b4f7c0-1111) was deleted at 2019-10-19 14:40 UTC"},
+		{apiErrorOf(t, http.StatusBadRequest, "ambiguous_reference", "ambiguous", []string{"c-1", "c-2"}), `ambiguous ["c-1","c-2"]`},
+	}
+	for _, tt := range tests {
+		if got := explainLookupFailure("mh9", tt.err); !strings.HasPrefix(got, tt.explanation) {
+			t.Errorf("explained %v as %q, want %q", tt.err, got, tt.explanation)
+		}
+	}
+}
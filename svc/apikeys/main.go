@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// apiKeyPrefix is what all API keys start with, so that
+// they're easy to spot, for example in leaked config files
+const apiKeyPrefix = "eksp_"
+
+// apiKeyIDLength is the number of characters of the hash
+// of an API key that make up its ID
+const apiKeyIDLength = 12
+
+// directInvocation is who we record as the issuer of API keys issued by
+// invoking the function directly, since all we know is that the caller
+// has AWS credentials allowing them to do so
+const directInvocation = "AWS credentials"
+
+// IssueRequest is who to issue an API key to
+type IssueRequest struct {
+	// User is the email address of the user
+	User string `json:"user"`
+	// Description optionally tells admins what the key is used for
+	Description string `json:"description"`
+}
+
+// IssuedAPIKey is an API key as handed out to the user,
+// the only time the key itself is ever shown
+type IssuedAPIKey struct {
+	eksp.APIKey
+	// Key is the API key to authenticate with
+	Key string `json:"key"`
+}
+
+// newAPIKey returns a new random API key
+func newAPIKey() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(b), nil
+}
+
+// issueAPIKey issues a new API key to the user asked for
+func issueAPIKey(clusterbucket string, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ir := IssueRequest{}
+	err := json.Unmarshal([]byte(request.Body), &ir)
+	if err != nil {
+		return eksp.BadRequest(fmt.Sprintf("The request is not valid JSON: %v", err))
+	}
+	addr, err := mail.ParseAddress(ir.User)
+	if err != nil || addr.Address != ir.User {
+		return eksp.BadRequest("Invalid API key request, please specify the user as a plain email address such as jane@example.com.")
+	}
+	key, err := newAPIKey()
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	hash := eksp.HashAPIKey(key)
+	issued := IssuedAPIKey{
+		APIKey: eksp.APIKey{
+			ID:          hash[:apiKeyIDLength],
+			User:        strings.ToLower(ir.User),
+			Description: ir.Description,
+			IssuedBy:    issuerOf(request),
+			IssueTime:   fmt.Sprintf("%v", time.Now().Unix()),
+		},
+		Key: key,
+	}
+	err = storeAPIKey(clusterbucket, hash, issued.APIKey)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	fmt.Printf("DEBUG:: issued API key %v to %v\n", issued.ID, issued.User)
+	return eksp.Respond(http.StatusOK, issued)
+}
+
+// issuerOf returns who issues an API key: the admin calling the API or,
+// for the first keys, someone invoking the function directly
+func issuerOf(request events.APIGatewayProxyRequest) string {
+	if eksp.IsInternal(request) {
+		return directInvocation
+	}
+	return eksp.CallerOf(request)
+}
+
+// revokeAPIKey deletes the API key with the ID or unique ID prefix provided
+func revokeAPIKey(clusterbucket, kref string) (events.APIGatewayProxyResponse, error) {
+	matches, err := listAPIKeys(clusterbucket, kref)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if len(matches) > 1 {
+		candidates := []string{}
+		for _, apikey := range matches {
+			candidates = append(candidates, apikey.ID)
+		}
+		return eksp.RespondError(http.StatusBadRequest, "ambiguous_reference", fmt.Sprintf("The ID prefix %v matches more than one API key", kref), candidates)
+	}
+	for hash, apikey := range matches {
+		err = rmAPIKey(clusterbucket, hash)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+		fmt.Printf("DEBUG:: revoked API key %v of %v\n", apikey.ID, apikey.User)
+		return eksp.Respond(http.StatusOK, apikey)
+	}
+	return eksp.RespondError(http.StatusNotFound, "not_found", fmt.Sprintf("There is no API key with the ID or ID prefix %v", kref), nil)
+}
+
+func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	clusterbucket := os.Getenv("CLUSTER_METADATA_BUCKET")
+	fmt.Printf("DEBUG:: API keys %v start\n", request.HTTPMethod)
+	// admins manage API keys via the API, and in order to issue the first
+	// ones, by invoking the function directly with their AWS credentials:
+	if !eksp.IsInternal(request) {
+		ac, err := eksp.FetchAccessConfig(clusterbucket)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+		if !eksp.CallerIsAdmin(request, ac) {
+			return eksp.Forbidden("Only admins can manage API keys.")
+		}
+	}
+	switch request.HTTPMethod {
+	case http.MethodPost:
+		return issueAPIKey(clusterbucket, request)
+	case http.MethodDelete:
+		kref := request.PathParameters["keyid"]
+		if kref == "" {
+			return eksp.BadRequest("Unknown API key revoke request, please specify a valid API key ID.")
+		}
+		return revokeAPIKey(clusterbucket, kref)
+	default:
+		keys, err := listAPIKeys(clusterbucket, "")
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+		apikeys := []eksp.APIKey{}
+		for _, apikey := range keys {
+			apikeys = append(apikeys, apikey)
+		}
+		return eksp.Respond(http.StatusOK, apikeys)
+	}
+}
+
+func main() {
+	lambda.Start(eksp.Authenticated(handler))
+}
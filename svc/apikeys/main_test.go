@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	key, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, apiKeyPrefix) || len(key) != len(apiKeyPrefix)+64 {
+		t.Errorf("newAPIKey() = %v, want %v followed by 64 hex digits", key, apiKeyPrefix)
+	}
+	other, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == other {
+		t.Errorf("newAPIKey() returned %v twice", key)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// listAPIKeys returns the API keys in the given bucket whose
+// hash, and with it ID, starts with the prefix, keyed by hash
+func listAPIKeys(clusterbucket, prefix string) (map[string]eksp.APIKey, error) {
+	apikeys := map[string]eksp.APIKey{}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return apikeys, err
+	}
+	svc := s3.New(cfg)
+	downloader := s3manager.NewDownloader(cfg)
+	input := &s3.ListObjectsInput{
+		Bucket: aws.String(clusterbucket),
+		Prefix: aws.String(eksp.APIKeysPrefix + prefix),
+	}
+	for {
+		req := svc.ListObjectsRequest(input)
+		resp, err := req.Send(context.TODO())
+		if err != nil {
+			return apikeys, err
+		}
+		for _, obj := range resp.Contents {
+			buf := aws.NewWriteAtBuffer([]byte{})
+			_, err = downloader.Download(buf, &s3.GetObjectInput{
+				Bucket: aws.String(clusterbucket),
+				Key:    obj.Key,
+			})
+			if err != nil {
+				return apikeys, err
+			}
+			apikey := eksp.APIKey{}
+			err = json.Unmarshal(buf.Bytes(), &apikey)
+			if err != nil {
+				return apikeys, err
+			}
+			hash := strings.TrimSuffix(strings.TrimPrefix(*obj.Key, eksp.APIKeysPrefix), ".json")
+			apikeys[hash] = apikey
+		}
+		if resp.IsTruncated == nil || !*resp.IsTruncated || len(resp.Contents) == 0 {
+			break
+		}
+		// without a delimiter, we carry on after the last key we got:
+		input.Marker = resp.Contents[len(resp.Contents)-1].Key
+	}
+	return apikeys, nil
+}
+
+// storeAPIKey stores the API key with the given hash in the given bucket
+func storeAPIKey(clusterbucket, hash string, apikey eksp.APIKey) error {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	keyjson, err := json.Marshal(apikey)
+	if err != nil {
+		return err
+	}
+	uploader := s3manager.NewUploader(cfg)
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(eksp.APIKeysPrefix + hash + ".json"),
+		Body:   strings.NewReader(string(keyjson)),
+	})
+	return err
+}
+
+// rmAPIKey deletes the API key with the given hash from the given bucket
+func rmAPIKey(clusterbucket, hash string) error {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	svc := s3.New(cfg)
+	req := svc.DeleteObjectRequest(&s3.DeleteObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(eksp.APIKeysPrefix + hash + ".json"),
+	})
+	_, err = req.Send(context.TODO())
+	return err
+}
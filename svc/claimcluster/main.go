@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// ClaimRequest is what users ask for when claiming
+// a cluster from a warm pool, all fields are optional
+type ClaimRequest struct {
+	// Name is the name to claim the cluster under,
+	// defaults to the name the cluster was provisioned with
+	Name string `json:"name"`
+	// Owner is the email address of the new owner, defaults to the
+	// caller or else the owner of the warm pool's clusters
+	Owner string `json:"owner"`
+	// Timeout is the timeout in minutes, starting with the claim,
+	// defaults to the timeout of the warm pool's clusters
+	Timeout int `json:"timeout"`
+}
+
+// activatedAt returns when the cluster became active
+func activatedAt(cs eksp.ClusterSpec) int64 {
+	at, _ := strconv.ParseInt(cs.ActivationTime, 10, 64)
+	return at
+}
+
+// reserveCluster reserves the first of the candidates still ready to be
+// claimed by putting a claim marker in place, of which only one of
+// concurrent claims for the same cluster succeeds. Returns the spec of
+// the reserved cluster along with its ETag, or nil if there's no
+// candidate left to reserve.
+func reserveCluster(clusterbucket, pool string, candidates []eksp.ClusterSpec) (*eksp.ClusterSpec, string, error) {
+	for _, candidate := range candidates {
+		marker, err := json.Marshal(map[string]string{
+			"clusterid": candidate.ID,
+			"claimtime": fmt.Sprintf("%v", time.Now().Unix()),
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		won, err := eksp.PutObjectIfAbsent(clusterbucket, eksp.ClaimKey(candidate.ID), marker)
+		if err != nil {
+			return nil, "", err
+		}
+		if !won {
+			fmt.Printf("DEBUG:: cluster %v has been claimed by someone else\n", candidate.ID)
+			continue
+		}
+		// now that it's ours, make sure it's still in the pool:
+		cs, etag, err := eksp.FetchClusterSpecVersion(clusterbucket, candidate.ID)
+		if err == nil && eksp.ReadyToClaim(cs, pool) {
+			return &cs, etag, nil
+		}
+		fmt.Printf("DEBUG:: cluster %v is no longer ready to be claimed\n", candidate.ID)
+		derr := eksp.DeleteObject(clusterbucket, eksp.ClaimKey(candidate.ID))
+		if derr != nil {
+			fmt.Println(derr.Error())
+		}
+		if _, ok := err.(eksp.NotFoundError); err != nil && !ok {
+			return nil, "", err
+		}
+	}
+	return nil, "", nil
+}
+
+func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	clusterbucket := os.Getenv("CLUSTER_METADATA_BUCKET")
+	fmt.Printf("DEBUG:: claim start\n")
+	pool, ok := request.PathParameters["pool"]
+	if !ok || pool == "" {
+		return eksp.BadRequest("Unknown cluster claim request, please specify a warm pool.")
+	}
+	claim := ClaimRequest{}
+	if strings.TrimSpace(request.Body) != "" {
+		err := json.Unmarshal([]byte(request.Body), &claim)
+		if err != nil {
+			return eksp.BadRequest(fmt.Sprintf("The claim is not valid JSON: %v", err))
+		}
+	}
+	pools, err := eksp.FetchWarmPools(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	known := false
+	for _, wp := range pools {
+		known = known || wp.Name == pool
+	}
+	if !known {
+		return eksp.RespondError(http.StatusNotFound, "not_found", fmt.Sprintf("There is no warm pool %v", pool), nil)
+	}
+	clusterIDs, err := eksp.ListClusterIDs(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	// line up the clusters ready to be claimed, the one that has been waiting
+	// the longest first, and make sure the name asked for is unique amongst
+	// active clusters:
+	candidates := []eksp.ClusterSpec{}
+	taken := map[string]string{}
+	for _, cID := range clusterIDs {
+		candidate, err := eksp.FetchClusterSpec(clusterbucket, cID)
+		if err != nil {
+			// the cluster might have been torn down in the meantime:
+			if _, ok := err.(eksp.NotFoundError); ok {
+				continue
+			}
+			return eksp.ServerError(err)
+		}
+		taken[candidate.Name] = candidate.ID
+		if candidate.Alias != "" {
+			taken[candidate.Alias] = candidate.ID
+		}
+		if eksp.ReadyToClaim(candidate, pool) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return activatedAt(candidates[i]) < activatedAt(candidates[j])
+	})
+	// reserve a cluster, as others might be claiming from the pool, too:
+	cs, etag, err := reserveCluster(clusterbucket, pool, candidates)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if cs == nil {
+		return eksp.Conflict(fmt.Sprintf("There is no cluster in warm pool %v ready to be claimed, please try again in a few minutes", pool))
+	}
+	// if the claim doesn't go through, the cluster goes back to the pool:
+	claimedOK := false
+	defer func() {
+		if !claimedOK {
+			err := eksp.DeleteObject(clusterbucket, eksp.ClaimKey(cs.ID))
+			if err != nil {
+				fmt.Println(err.Error())
+			}
+		}
+	}()
+	// apply the claim and check the outcome like any other cluster spec:
+	claimed := *cs
+	if claim.Name != "" && claim.Name != cs.Name {
+		claimed.Alias = claim.Name
+	}
+	if claim.Owner == "" {
+		claim.Owner = eksp.CallerOf(request)
+	}
+	if claim.Owner != "" {
+		claimed.Owner = claim.Owner
+	}
+	if claim.Timeout != 0 {
+		claimed.Timeout = claim.Timeout
+	}
+	check := claimed
+	if claimed.Alias != "" {
+		check.Name = claimed.Alias
+	}
+	if ferrs := eksp.ValidateClusterSpec(check); len(ferrs) > 0 {
+		return eksp.RespondError(http.StatusBadRequest, "validation_failed", "The claim is invalid", ferrs)
+	}
+	ac, err := eksp.FetchAccessConfig(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if reason := eksp.OwnershipError(request, ac, claimed.Owner, claimed.Team); reason != "" {
+		return eksp.Forbidden(reason)
+	}
+	if existing, ok := taken[claimed.Alias]; ok && claimed.Alias != "" {
+		return eksp.RespondError(http.StatusConflict, "conflict", "The claim conflicts with an active cluster",
+			[]eksp.FieldError{{Field: "name", Message: fmt.Sprintf("is already used by the active cluster %v", existing)}})
+	}
+	pol, err := eksp.FetchPolicies(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if denials := eksp.EvaluatePolicies(request, ac, pol, "claim", eksp.AttributesOf(claimed, 0)); len(denials) > 0 {
+		return eksp.PolicyDenied(denials)
+	}
+	if msg := eksp.TimeoutCapError(request, ac, pol, claimed.Timeout); msg != "" {
+		return eksp.RespondError(http.StatusBadRequest, "validation_failed", "The claim is invalid", []eksp.FieldError{{Field: "timeout", Message: msg}})
+	}
+	// the timeout starts now:
+	claimed.WarmPool = ""
+	claimed.ClaimTime = fmt.Sprintf("%v", time.Now().Unix())
+	claimed.CreationTime = claimed.ClaimTime
+	claimed.TTL = claimed.Timeout
+	claimed.ExpiryWarningTime = ""
+	// the reaper might have changed the spec since we reserved the cluster,
+	// such as tearing it down, so only store the claim if it didn't:
+	replaced, err := eksp.ReplaceClusterSpec(clusterbucket, claimed, etag)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if !replaced {
+		return eksp.Conflict(fmt.Sprintf("Cluster %v changed while claiming it, please try again", cs.ID))
+	}
+	claimedOK = true
+	fmt.Printf("DEBUG:: claimed cluster %v from warm pool %v as %v for %v, TTL is %v min\n", claimed.ID, pool, check.Name, claimed.Owner, claimed.TTL)
+	eksp.AuditSpecChange(clusterbucket, eksp.ActorOf(request), "claim", *cs, claimed)
+	eksp.EmitWebhook(clusterbucket, "claimed", claimed)
+	// the scheduler notices that the pool lacks a cluster
+	// and creates a new one within the next minute
+	fmt.Printf("DEBUG:: claim done\n")
+	return eksp.Respond(http.StatusOK, claimed)
+}
+
+func main() {
+	lambda.Start(eksp.Authenticated(handler))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// idle returns a fully provisioned cluster of the ci warm pool
+func idle() eksp.ClusterSpec {
+	return eksp.ClusterSpec{
+		ID:                 "e-abc123",
+		Name:               "ci-1a2b3c",
+		WarmPool:           "ci",
+		ActivationTime:     "1561000000",
+		ProvisioningTask:   "arn:aws:ecs:us-east-2:123456789012:task/eksphemeral/0123",
+		ProvisioningStatus: eksp.TaskStopped,
+	}
+}
+
+func TestActivatedAt(t *testing.T) {
+	if at := activatedAt(idle()); at != 1561000000 {
+		t.Errorf("activatedAt() = %v, want 1561000000", at)
+	}
+	if at := activatedAt(eksp.ClusterSpec{}); at != 0 {
+		t.Errorf("activatedAt() of an inactive cluster = %v, want 0", at)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// provisionerContainer is the name of the container
+// running eksctl in the provisioner task definition
+const provisionerContainer = "eksctl"
+
+// storeClusterConfig stores the eksctl ClusterConfig manifest of the
+// cluster in the metadata bucket, returning the key the provisioner
+// fetches it from, since the overrides of a task are limited to 8 KiB
+func storeClusterConfig(clusterbucket string, cs eksp.ClusterSpec, clusterConfig string) (string, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return "", err
+	}
+	key := eksp.ClusterConfigKey(cs.ID)
+	uploader := s3manager.NewUploader(cfg)
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(clusterConfig),
+	})
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// launchProvisioner runs the eksctl task provisioning the cluster in ECS
+// on Fargate, passing it where to find the eksctl ClusterConfig manifest
+// to use and the role to grant access to the cluster. The task runs under
+// its own task role, so no credentials are passed around.
+// Returns the ARN of the task.
+func launchProvisioner(clusterbucket string, cs eksp.ClusterSpec, configKey string) (string, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return "", err
+	}
+	svc := ecs.New(cfg)
+	req := svc.RunTaskRequest(&ecs.RunTaskInput{
+		Cluster:        aws.String(os.Getenv("PROVISIONER_CLUSTER")),
+		TaskDefinition: aws.String(os.Getenv("PROVISIONER_TASK_DEFINITION")),
+		LaunchType:     ecs.LaunchTypeFargate,
+		Count:          aws.Int64(1),
+		StartedBy:      aws.String("eksphemeral"),
+		NetworkConfiguration: &ecs.NetworkConfiguration{
+			AwsvpcConfiguration: &ecs.AwsVpcConfiguration{
+				Subnets:        strings.Split(os.Getenv("PROVISIONER_SUBNETS"), ","),
+				SecurityGroups: []string{os.Getenv("PROVISIONER_SECURITY_GROUP")},
+				// needed to pull the eksctl image and talk to the AWS APIs:
+				AssignPublicIp: ecs.AssignPublicIpEnabled,
+			},
+		},
+		Overrides: &ecs.TaskOverride{
+			ContainerOverrides: []ecs.ContainerOverride{
+				{
+					Name: aws.String(provisionerContainer),
+					Environment: []ecs.KeyValuePair{
+						{Name: aws.String("AWS_DEFAULT_REGION"), Value: aws.String(cfg.Region)},
+						{Name: aws.String("CLUSTER_NAME"), Value: aws.String(cs.Name)},
+						{Name: aws.String("NUM_WORKERS"), Value: aws.String(strconv.Itoa(cs.NumWorkers))},
+						{Name: aws.String("KUBERNETES_VERSION"), Value: aws.String(cs.KubeVersion)},
+						{Name: aws.String("CLUSTER_METADATA_BUCKET"), Value: aws.String(clusterbucket)},
+						{Name: aws.String("CLUSTER_CONFIG_KEY"), Value: aws.String(configKey)},
+						{Name: aws.String("CLUSTER_ACCESS_ROLE_ARN"), Value: aws.String(os.Getenv("CLUSTER_ACCESS_ROLE_ARN"))},
+					},
+				},
+			},
+		},
+	})
+	fmt.Printf("DEBUG:: launching provisioner for cluster %v\n", cs.Name)
+	resp, err := req.Send(context.TODO())
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Failures) > 0 {
+		return "", fmt.Errorf("Can't launch provisioner: %v", aws.StringValue(resp.Failures[0].Reason))
+	}
+	if len(resp.Tasks) == 0 {
+		return "", fmt.Errorf("Can't launch provisioner: no task started")
+	}
+	return aws.StringValue(resp.Tasks[0].TaskArn), nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+const (
+	// idempotencyPrefix is the prefix in the metadata bucket
+	// under which idempotency records are kept
+	idempotencyPrefix = "idempotency/"
+	// idempotencyHeader is the HTTP header clients can use
+	// to provide an idempotency key
+	idempotencyHeader = "Idempotency-Key"
+	// idempotencyWindow is how long a create request
+	// can be repeated with the same idempotency key
+	idempotencyWindow = 24 * time.Hour
+	// idempotencyPendingTimeout is how long a reservation without a
+	// cluster ID is honoured, well past the timeout of the function,
+	// after which we assume the request holding it failed
+	idempotencyPendingTimeout = 5 * time.Minute
+	// idempotencyAttempts is how often we try to reserve a key
+	// before giving up due to concurrent requests with the key
+	idempotencyAttempts = 3
+)
+
+// IdempotencyRecord captures which cluster has been
+// created for a given idempotency key
+type IdempotencyRecord struct {
+	// ClusterID is the ID of the cluster created for the first
+	// request with the key, empty while that request is in progress
+	ClusterID string `json:"clusterid,omitempty"`
+	// CreationTime is the UTC timestamp of the first request with the key
+	CreationTime string `json:"created"`
+}
+
+// idempotencyKeyOf returns the idempotency key of the create request,
+// either from the HTTP header or, as a fallback, from the cluster spec
+func idempotencyKeyOf(request events.APIGatewayProxyRequest, cs eksp.ClusterSpec) string {
+	for header, value := range request.Headers {
+		if strings.EqualFold(header, idempotencyHeader) && value != "" {
+			return value
+		}
+	}
+	return cs.IdempotencyKey
+}
+
+// idempotencyObjectKey returns the object key of the record for the
+// idempotency key of the caller in the metadata bucket. Keys are scoped
+// to the caller, so that nobody gets hold of the clusters of others by
+// guessing their keys. We hash the key since clients can send any
+// string they like.
+func idempotencyObjectKey(caller, ikey string) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%v:%v/%v", len(caller), caller, ikey)))
+	return idempotencyPrefix + hex.EncodeToString(h[:]) + ".json"
+}
+
+// reserveIdempotencyKey atomically reserves the idempotency key for the
+// request at hand, before the request has any side effects. If the key
+// has been used within the idempotency window, it returns the record of
+// that request instead, which lacks the cluster ID if it's in progress.
+func reserveIdempotencyKey(clusterbucket, caller, ikey string) (*IdempotencyRecord, error) {
+	okey := idempotencyObjectKey(caller, ikey)
+	irjson, err := json.Marshal(IdempotencyRecord{
+		CreationTime: fmt.Sprintf("%v", time.Now().Unix()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; attempt < idempotencyAttempts; attempt++ {
+		reserved, err := eksp.PutObjectIfAbsent(clusterbucket, okey, irjson)
+		if err != nil {
+			return nil, err
+		}
+		if reserved {
+			return nil, nil
+		}
+		content, etag, err := eksp.FetchObject(clusterbucket, okey)
+		if err != nil {
+			// the reservation might have been released in the meantime:
+			if eksp.IsNoSuchKey(err) {
+				continue
+			}
+			return nil, err
+		}
+		ir := IdempotencyRecord{}
+		err = json.Unmarshal(content, &ir)
+		if err != nil {
+			return nil, err
+		}
+		created, err := parseUnixTime(ir.CreationTime)
+		if err != nil {
+			return nil, err
+		}
+		if time.Since(created) <= idempotencyWindow && (ir.ClusterID != "" || time.Since(created) <= idempotencyPendingTimeout) {
+			return &ir, nil
+		}
+		// the record expired, so take it over unless
+		// a concurrent request beats us to it:
+		replaced, err := eksp.ReplaceObjectIfUnchanged(clusterbucket, okey, etag, irjson)
+		if err != nil {
+			return nil, err
+		}
+		if replaced {
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("Can't reserve idempotency key %v due to concurrent requests with it", ikey)
+}
+
+// recordIdempotencyKey fills in the cluster created
+// for the idempotency key we reserved
+func recordIdempotencyKey(clusterbucket, caller, ikey, clusterID string) error {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	irjson, err := json.Marshal(IdempotencyRecord{
+		ClusterID:    clusterID,
+		CreationTime: fmt.Sprintf("%v", time.Now().Unix()),
+	})
+	if err != nil {
+		return err
+	}
+	uploader := s3manager.NewUploader(cfg)
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(idempotencyObjectKey(caller, ikey)),
+		Body:   strings.NewReader(string(irjson)),
+	})
+	return err
+}
+
+// releaseIdempotencyKey releases the idempotency key we reserved
+// for a request that failed before creating a cluster, so that
+// the client can retry the request with the key
+func releaseIdempotencyKey(clusterbucket, caller, ikey string) error {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	svc := s3.New(cfg)
+	req := svc.DeleteObjectRequest(&s3.DeleteObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(idempotencyObjectKey(caller, ikey)),
+	})
+	_, err = req.Send(context.TODO())
+	return err
+}
+
+// parseUnixTime parses a UTC timestamp in seconds
+// such as the one we use for creation times
+func parseUnixTime(ts string) (time.Time, error) {
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(secs, 0), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+func TestIdempotencyKeyOf(t *testing.T) {
+	withHeader := func(name, value string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{Headers: map[string]string{name: value}}
+	}
+	tests := []struct {
+		name    string
+		request events.APIGatewayProxyRequest
+		cs      eksp.ClusterSpec
+		ikey    string
+	}{
+		{"header", withHeader("Idempotency-Key", "k-1"), eksp.ClusterSpec{}, "k-1"},
+		{"lower case header", withHeader("idempotency-key", "k-1"), eksp.ClusterSpec{}, "k-1"},
+		{"header before spec", withHeader("Idempotency-Key", "k-1"), eksp.ClusterSpec{IdempotencyKey: "k-2"}, "k-1"},
+		{"empty header", withHeader("Idempotency-Key", ""), eksp.ClusterSpec{IdempotencyKey: "k-2"}, "k-2"},
+		{"spec", events.APIGatewayProxyRequest{}, eksp.ClusterSpec{IdempotencyKey: "k-2"}, "k-2"},
+		{"none", withHeader("X-Request-Id", "r-1"), eksp.ClusterSpec{}, ""},
+	}
+	for _, tt := range tests {
+		if got := idempotencyKeyOf(tt.request, tt.cs); got != tt.ikey {
+			t.Errorf("%v: got idempotency key %q, want %q", tt.name, got, tt.ikey)
+		}
+	}
+}
+
+func TestIdempotencyObjectKey(t *testing.T) {
+	okey := idempotencyObjectKey("jane@example.com", "../../clusters/k-1")
+	if !strings.HasPrefix(okey, idempotencyPrefix) || !strings.HasSuffix(okey, ".json") {
+		t.Errorf("object key %q is not a record under %v", okey, idempotencyPrefix)
+	}
+	if strings.Contains(okey, "..") || strings.Count(okey, "/") != 1 {
+		t.Errorf("object key %q contains parts of the idempotency key", okey)
+	}
+	if okey != idempotencyObjectKey("jane@example.com", "../../clusters/k-1") {
+		t.Error("object key of the same idempotency key differs")
+	}
+	if okey == idempotencyObjectKey("jane@example.com", "../../clusters/k-2") {
+		t.Error("object keys of different idempotency keys are the same")
+	}
+	if okey == idempotencyObjectKey("joe@example.com", "../../clusters/k-1") {
+		t.Error("object keys of the same idempotency key of different callers are the same")
+	}
+	if idempotencyObjectKey("jane@example.com/a", "k-1") == idempotencyObjectKey("jane@example.com", "a/k-1") {
+		t.Error("object keys of different callers and idempotency keys are the same")
+	}
+}
+
+func TestParseUnixTime(t *testing.T) {
+	ts, err := parseUnixTime("1571496000")
+	if err != nil || !ts.Equal(time.Date(2019, 10, 19, 14, 40, 0, 0, time.UTC)) {
+		t.Errorf("got %v, %v", ts, err)
+	}
+	if _, err := parseUnixTime("2019-10-19"); err == nil {
+		t.Error("parsed a date that isn't a UTC timestamp in seconds")
+	}
+}
@@ -5,135 +5,263 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
 	uuid "github.com/satori/go.uuid"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/aws/external"
-	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
-)
-
-// ClusterSpec represents the parameters for eksctl,
-// as cluster metadata including owner and how long the cluster
-// still has to live.
-type ClusterSpec struct {
-	// ID is a unique identifier for the cluster
-	ID string `json:"id"`
-	// Name specifies the cluster name
-	Name string `json:"name"`
-	// NumWorkers specifies the number of worker nodes, defaults to 1
-	NumWorkers int `json:"numworkers"`
-	// KubeVersion  specifies the Kubernetes version to use, defaults to `1.12`
-	KubeVersion string `json:"kubeversion"`
-	// Timeout specifies the timeout in minutes, after which the cluster
-	// is destroyed, defaults to 10
-	Timeout int `json:"timeout"`
-	// Timeout specifies the cluster time to live in minutes.
-	// In other words: the remaining time the cluster has before it is destroyed
-	TTL int `json:"ttl"`
-	// Owner specifies the email address of the owner (will be notified when cluster is created and 5 min before destruction)
-	Owner string `json:"owner"`
-	// CreationTime is the UTC timestamp of when the cluster was created
-	// which equals the point in time of the creation of the respective
-	// JSON representation of the cluster spec as an object in the metadata
-	// bucket
-	CreationTime string `json:"created"`
-}
-
-// storeClusterSpec stores the cluster spec in a given bucket
-func storeClusterSpec(clusterbucket string, cs ClusterSpec) error {
-	cfg, err := external.LoadDefaultAWSConfig()
-	if err != nil {
-		return err
-	}
-	csjson, err := json.Marshal(cs)
-	if err != nil {
-		return err
-	}
-	uploader := s3manager.NewUploader(cfg)
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(clusterbucket),
-		Key:    aws.String(cs.ID + ".json"),
-		Body:   strings.NewReader(string(csjson)),
-	})
-	return err
-}
 
-func serverError(err error) (events.APIGatewayProxyResponse, error) {
-	fmt.Println(err.Error())
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusInternalServerError,
-		Headers: map[string]string{
-			"Access-Control-Allow-Origin": "*",
-		},
-		Body: fmt.Sprintf("%v", err.Error()),
-	}, nil
-}
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
 
 func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	// region := os.Getenv("AWS_REGION")
+	region := os.Getenv("AWS_REGION")
 	clusterbucket := os.Getenv("CLUSTER_METADATA_BUCKET")
 	fmt.Println("DEBUG:: create start")
 	// parse params:
-	cs := ClusterSpec{
+	cs := eksp.ClusterSpec{
 		ID:           "",
 		Name:         "unknown",
 		NumWorkers:   1,
 		KubeVersion:  "1.12",
 		Timeout:      10,
 		TTL:          10,
-		Owner:        "nobody@example.com",
+		Owner:        eksp.DefaultOwner,
 		CreationTime: "",
 	}
+	// if the request refers to a template, its cluster spec
+	// is what the fields of the request are applied to:
+	var ref struct {
+		Template string `json:"template"`
+	}
+	err := json.Unmarshal([]byte(request.Body), &ref)
+	if err != nil {
+		return eksp.BadRequest(fmt.Sprintf("The cluster spec is not valid JSON: %v", err))
+	}
+	var tmpl *eksp.Template
+	switch {
+	case ref.Template != "":
+		tmpl, err = eksp.FetchTemplate(clusterbucket, ref.Template)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+		if tmpl == nil {
+			return eksp.ValidationError([]eksp.FieldError{{Field: "template", Message: fmt.Sprintf("there is no template %v", ref.Template)}})
+		}
+		err = json.Unmarshal(tmpl.Spec, &cs)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+		fmt.Printf("DEBUG:: using template %v\n", tmpl.Name)
+	case os.Getenv("REQUIRE_TEMPLATE") == "true":
+		return eksp.Forbidden("Clusters can only be created from a template, please specify one.")
+	}
 	// Unmarshal the JSON payload in the POST:
-	err := json.Unmarshal([]byte(request.Body), &cs)
+	err = json.Unmarshal([]byte(request.Body), &cs)
 	if err != nil {
-		return serverError(err)
+		return eksp.BadRequest(fmt.Sprintf("The cluster spec is not valid JSON: %v", err))
 	}
 	fmt.Println("DEBUG:: parsing input cluster spec from HTTP POST payload done")
-	fmt.Printf("Creating %v, a %v cluster with %v nodes for %v minutes which is owned by %v and adding a respective entry to bucket %v\n", cs.Name, cs.KubeVersion, cs.NumWorkers, cs.Timeout, cs.Owner, clusterbucket)
+	// only the scheduler puts clusters into warm pools, when refilling them:
+	if !eksp.IsInternal(request) {
+		cs.WarmPool = ""
+	}
+	// callers who identify themselves own the cluster, unless they say otherwise:
+	if caller := eksp.CallerOf(request); caller != "" && cs.Owner == eksp.DefaultOwner {
+		cs.Owner = caller
+	}
+	if ferrs := eksp.ValidateClusterSpec(cs); len(ferrs) > 0 {
+		return eksp.ValidationError(ferrs)
+	}
+	ac, err := eksp.FetchAccessConfig(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if ac != nil && cs.Team != "" && !eksp.KnownTeam(request, ac, cs.Team) {
+		return eksp.ValidationError([]eksp.FieldError{{Field: "team", Message: fmt.Sprintf("there is no team %v", cs.Team)}})
+	}
+	if reason := eksp.OwnershipError(request, ac, cs.Owner, cs.Team); reason != "" {
+		return eksp.Forbidden(reason)
+	}
+	if tmpl != nil && !tmpl.Allows(cs.Owner) {
+		return eksp.Forbidden(fmt.Sprintf("The template %v is restricted to certain owners, and %v is not one of them.", tmpl.Name, cs.Owner))
+	}
+	cs.NumWorkers = eksp.NumWorkersOf(cs)
+	// the control plane keeps track of clusters in its own region only:
+	if cs.Region == "" {
+		cs.Region = region
+	}
+	if cs.Region != region {
+		return eksp.ValidationError([]eksp.FieldError{{Field: "region", Message: fmt.Sprintf("must be %v, the region of the control plane", region)}})
+	}
+	pol, err := eksp.FetchPolicies(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if denials := eksp.EvaluatePolicies(request, ac, pol, "create", eksp.AttributesOf(cs, 0)); len(denials) > 0 {
+		return eksp.PolicyDenied(denials)
+	}
+	if msg := eksp.TimeoutCapError(request, ac, pol, cs.Timeout); msg != "" {
+		return eksp.ValidationError([]eksp.FieldError{{Field: "timeout", Message: msg}})
+	}
+	// if we've seen this request before, return the cluster created back then,
+	// otherwise reserve the key before we do anything with side effects:
+	ikey := idempotencyKeyOf(request, cs)
+	caller := eksp.CallerOf(request)
+	created := false
+	if ikey != "" {
+		ir, err := reserveIdempotencyKey(clusterbucket, caller, ikey)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+		if ir != nil && ir.ClusterID == "" {
+			return eksp.Conflict("A create request with the same idempotency key is in progress, please try again in a bit.")
+		}
+		if ir != nil {
+			fmt.Printf("DEBUG:: repeated create request, cluster %v already created\n", ir.ClusterID)
+			// the cluster might be gone by now, in which case we only know its ID:
+			orig, err := eksp.FetchClusterSpec(clusterbucket, ir.ClusterID)
+			if err != nil {
+				orig = eksp.ClusterSpec{ID: ir.ClusterID}
+			}
+			return eksp.Respond(http.StatusOK, orig)
+		}
+		cs.IdempotencyKey = ikey
+		// if we fail before creating the cluster, the client may retry with the key:
+		defer func() {
+			if !created {
+				err := releaseIdempotencyKey(clusterbucket, caller, ikey)
+				if err != nil {
+					fmt.Println(err.Error())
+				}
+			}
+		}()
+	}
+	// have the admission webhooks review the cluster, which they may change:
+	cs, denial, err := eksp.Admit(clusterbucket, request, "create", cs)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if denial != nil {
+		return eksp.AdmissionDenied(*denial)
+	}
+	if ferrs := eksp.ValidateClusterSpec(cs); len(ferrs) > 0 {
+		return eksp.ValidationError(ferrs)
+	}
+	if cs.Region != region {
+		return eksp.ValidationError([]eksp.FieldError{{Field: "region", Message: fmt.Sprintf("must be %v, the region of the control plane", region)}})
+	}
+	cs.NumWorkers = eksp.NumWorkersOf(cs)
+	// the admission webhooks may have changed the cluster, so check it again:
+	if reason := eksp.OwnershipError(request, ac, cs.Owner, cs.Team); reason != "" {
+		return eksp.Forbidden(reason)
+	}
+	if denials := eksp.EvaluatePolicies(request, ac, pol, "create", eksp.AttributesOf(cs, 0)); len(denials) > 0 {
+		return eksp.PolicyDenied(denials)
+	}
+	if msg := eksp.TimeoutCapError(request, ac, pol, cs.Timeout); msg != "" {
+		return eksp.ValidationError([]eksp.FieldError{{Field: "timeout", Message: msg}})
+	}
 	// create unique cluster ID and assign:
 	clusterID, err := uuid.NewV4()
 	if err != nil {
-		return serverError(err)
+		return eksp.ServerError(err)
 	}
 	cs.ID = clusterID.String()
+	// make sure the cluster name is unique amongst active clusters,
+	// including ones created concurrently, by reserving it:
+	existing, err := eksp.ReserveClusterName(clusterbucket, cs.Name, cs.ID)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if existing != "" {
+		return eksp.ConflictError([]eksp.FieldError{{Field: "name", Message: fmt.Sprintf("is already used by the active cluster %v", existing)}})
+	}
+	// if we fail before storing the cluster spec, the name is up for grabs again:
+	defer func() {
+		if !created {
+			err := eksp.ReleaseClusterNames(clusterbucket, cs)
+			if err != nil {
+				fmt.Println(err.Error())
+			}
+		}
+	}()
+	fmt.Printf("Creating %v, a %v cluster with %v nodes for %v minutes which is owned by %v and adding a respective entry to bucket %v\n", cs.Name, cs.KubeVersion, cs.NumWorkers, cs.Timeout, cs.Owner, clusterbucket)
 	cs.CreationTime = fmt.Sprintf("%v", time.Now().Unix())
+	cs.LaunchTime = cs.CreationTime
+	cs.ActivationTime, cs.ProvisioningTask, cs.ProvisioningStatus, cs.ProvisioningError = "", "", "", ""
+	cs.Phase, cs.TeardownReason, cs.TeardownTime = "", "", ""
+	cs.HibernationTime, cs.HibernatedNodeGroups = "", nil
+	cs.HibernationReason, cs.NextTransition, cs.ExpiryWarningTime = "", "", ""
+	cs.Alias, cs.ClaimTime = "", ""
 	fmt.Printf("DEBUG:: created cluster spec %v", cs)
 	// store cluster spec in S3 bucket keyed by cluster ID:
-	err = storeClusterSpec(clusterbucket, cs)
+	err = eksp.StoreClusterSpec(clusterbucket, cs)
 	if err != nil {
-		return serverError(err)
+		return eksp.ServerError(err)
 	}
+	created = true
 	fmt.Println("DEBUG:: state sync done")
-	// if the owner shared their mail address, let's inform them that
+	if ikey != "" {
+		err = recordIdempotencyKey(clusterbucket, caller, ikey, cs.ID)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+	}
+	actor := eksp.ActorOf(request)
+	eksp.AuditSpecChange(clusterbucket, actor, "create", eksp.ClusterSpec{}, cs)
+	// provision the cluster, that is, launch eksctl in ECS:
+	taskARN := ""
+	before := cs
+	clusterConfig, err := eksp.RenderClusterConfig(cs, region)
+	if err == nil {
+		fmt.Printf("DEBUG:: rendered eksctl config for cluster %v:\n%v", cs.ID, clusterConfig)
+		var configKey string
+		configKey, err = storeClusterConfig(clusterbucket, cs, clusterConfig)
+		if err == nil {
+			taskARN, err = launchProvisioner(clusterbucket, cs, configKey)
+		}
+	}
+	if err != nil {
+		// expire the cluster right away, so that destroycluster
+		// cleans up whatever might have been created:
+		cs.Timeout, cs.TTL = 0, 0
+		cs.TeardownReason = "provisioning failed"
+		cs.ProvisioningError = err.Error()
+		serr := eksp.StoreClusterSpec(clusterbucket, cs)
+		if serr != nil {
+			fmt.Println(serr.Error())
+		}
+		eksp.AuditSpecChange(clusterbucket, actor, "provision", before, cs)
+		return eksp.ServerError(err)
+	}
+	cs.ProvisioningTask = taskARN
+	cs.ProvisioningStatus = "PROVISIONING"
+	err = eksp.StoreClusterSpec(clusterbucket, cs)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	eksp.AuditSpecChange(clusterbucket, actor, "provision", before, cs)
+	fmt.Printf("DEBUG:: provisioning cluster %v in task %v\n", cs.ID, taskARN)
+	eksp.EmitWebhook(clusterbucket, "created", cs)
+	// if the owners shared their mail addresses, let's inform them that
 	// the cluster is ready now:
 	if cs.Owner != "" {
 		fmt.Println("DEBUG:: begin inform owner")
-		fmt.Printf("Attempting to send owner %v an info concerning the creation of cluster %v\n", cs.Owner, cs.ID)
-		subject := fmt.Sprintf("EKS cluster %v created and available", cs.Name)
-		body := fmt.Sprintf("Hello there,\n\nThis is to inform you that your EKS cluster %v (cluster ID %v) is now available for you to use.\n\nHave a nice day,\nEKSphemeral", cs.Name, cs.ID)
-		err := informOwner(cs.Owner, subject, body)
+		fmt.Printf("Attempting to send owners %v an info concerning the creation of cluster %v\n", eksp.OwnersOf(cs), cs.ID)
+		subject := fmt.Sprintf("EKS cluster %v is being created", cs.Name)
+		body := fmt.Sprintf("Hello there,\n\nThis is to inform you that your EKS cluster %v (cluster ID %v) is being provisioned, which takes some 15 minutes. You will get another mail once it is available for you to use.%v\n\nHave a nice day,\nEKSphemeral", cs.Name, cs.ID, eksp.CostNote(clusterbucket, cs))
+		err := eksp.InformOwners(clusterbucket, actor, cs, subject, body)
 		if err != nil {
-			return serverError(err)
+			return eksp.ServerError(err)
 		}
 		fmt.Println("DEBUG:: inform owner done")
 	}
 	fmt.Println("DEBUG:: create done")
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusOK,
-		Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-		},
-		Body: cs.ID,
-	}, nil
+	return eksp.Respond(http.StatusOK, cs)
 }
 
 func main() {
-	lambda.Start(handler)
+	lambda.Start(eksp.Authenticated(handler))
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// handler expires the cluster right away, that is, sets its timeout
+// to zero. The actual tear down is then done by destroycluster, the
+// same way as for any other cluster that has reached its timeout.
+func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	clusterbucket := os.Getenv("CLUSTER_METADATA_BUCKET")
+	fmt.Printf("DEBUG:: delete start\n")
+	// validate cluster ID:
+	if _, ok := request.PathParameters["clusterid"]; !ok {
+		return eksp.BadRequest("Unknown cluster delete request, please specify a valid cluster ID.")
+	}
+	cref := request.PathParameters["clusterid"]
+	cID, err := eksp.ResolveClusterID(clusterbucket, cref)
+	if err != nil {
+		return eksp.ClusterError(clusterbucket, err)
+	}
+	cs, err := eksp.FetchClusterSpec(clusterbucket, cID)
+	if err != nil {
+		return eksp.ClusterError(clusterbucket, err)
+	}
+	ac, err := eksp.FetchAccessConfig(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if !eksp.MayManage(request, ac, cs) {
+		return eksp.Forbidden(eksp.NotAllowed(request, "delete", cs))
+	}
+	if cs.Phase == eksp.PhaseTearingDown {
+		return eksp.Conflict(fmt.Sprintf("Cluster %v is already being torn down", cs.Name))
+	}
+	pol, err := eksp.FetchPolicies(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if denials := eksp.EvaluatePolicies(request, ac, pol, "delete", eksp.AttributesOf(cs, 0)); len(denials) > 0 {
+		return eksp.PolicyDenied(denials)
+	}
+	before := cs
+	cs.Timeout = 0
+	cs.TTL = 0
+	cs.TeardownReason = "deleted by user"
+	fmt.Printf("DEBUG:: expiring cluster %v now\n", cs.ID)
+	err = eksp.StoreClusterSpec(clusterbucket, cs)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	eksp.AuditSpecChange(clusterbucket, eksp.ActorOf(request), "delete", before, cs)
+	fmt.Printf("DEBUG:: delete done\n")
+	return eksp.Respond(http.StatusOK, cs)
+}
+
+func main() {
+	lambda.Start(eksp.Authenticated(handler))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// reaperActor is the actor recorded for
+// mutations done by the reaper itself
+const reaperActor = "eksphemeral/reaper"
+
+// auditStackDeletion appends an event to the audit log
+// capturing that we deleted a stack of the cluster
+func auditStackDeletion(clusterbucket, clusterID, plane, stack string) {
+	err := eksp.RecordEvent(clusterbucket, eksp.Event{
+		Actor:     reaperActor,
+		ClusterID: clusterID,
+		Action:    "delete-stack",
+		Details: map[string]string{
+			"plane": plane,
+			"stack": stack,
+		},
+	})
+	if err != nil {
+		fmt.Printf("Can't record delete-stack event for cluster %v in audit log: %v\n", clusterID, err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// nodeGroupResource is the logical ID of the auto scaling
+// group in the stacks eksctl creates for nodegroups
+const nodeGroupResource = "NodeGroup"
+
+// lookupNodeGroup returns the current size of the nodegroup
+// the data plane stack represents
+func lookupNodeGroup(dpstack nodeGroupStack) (eksp.HibernatedNodeGroup, error) {
+	hng := eksp.HibernatedNodeGroup{
+		NodeGroup: dpstack.NodeGroup,
+	}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return hng, err
+	}
+	cfsvc := cloudformation.New(cfg)
+	dsrreq := cfsvc.DescribeStackResourceRequest(&cloudformation.DescribeStackResourceInput{
+		StackName:         aws.String(dpstack.Name),
+		LogicalResourceId: aws.String(nodeGroupResource),
+	})
+	dsrresp, err := dsrreq.Send(context.TODO())
+	if err != nil {
+		return hng, err
+	}
+	hng.AutoScalingGroup = aws.StringValue(dsrresp.StackResourceDetail.PhysicalResourceId)
+	assvc := autoscaling.New(cfg)
+	dasgreq := assvc.DescribeAutoScalingGroupsRequest(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{hng.AutoScalingGroup},
+	})
+	dasgresp, err := dasgreq.Send(context.TODO())
+	if err != nil {
+		return hng, err
+	}
+	if len(dasgresp.AutoScalingGroups) == 0 {
+		return hng, fmt.Errorf("Can't find auto scaling group %v of nodegroup %v", hng.AutoScalingGroup, hng.NodeGroup)
+	}
+	asg := dasgresp.AutoScalingGroups[0]
+	hng.MinSize = int(aws.Int64Value(asg.MinSize))
+	hng.MaxSize = int(aws.Int64Value(asg.MaxSize))
+	hng.DesiredCapacity = int(aws.Int64Value(asg.DesiredCapacity))
+	return hng, nil
+}
+
+// scaleToZero scales the nodegroup down to zero nodes,
+// keeping its maximum size as is
+func scaleToZero(hng eksp.HibernatedNodeGroup) error {
+	fmt.Printf("DEBUG:: scaling nodegroup %v down to zero\n", hng.NodeGroup)
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	svc := autoscaling.New(cfg)
+	uasgreq := svc.UpdateAutoScalingGroupRequest(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(hng.AutoScalingGroup),
+		MinSize:              aws.Int64(0),
+		DesiredCapacity:      aws.Int64(0),
+	})
+	_, err = uasgreq.Send(context.TODO())
+	return err
+}
+
+// restoreNodeGroup scales the nodegroup back to
+// the size it had before the cluster was hibernated
+func restoreNodeGroup(hng eksp.HibernatedNodeGroup) error {
+	fmt.Printf("DEBUG:: scaling nodegroup %v back to %v nodes\n", hng.NodeGroup, hng.DesiredCapacity)
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	svc := autoscaling.New(cfg)
+	uasgreq := svc.UpdateAutoScalingGroupRequest(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(hng.AutoScalingGroup),
+		MinSize:              aws.Int64(int64(hng.MinSize)),
+		MaxSize:              aws.Int64(int64(hng.MaxSize)),
+		DesiredCapacity:      aws.Int64(int64(hng.DesiredCapacity)),
+	})
+	_, err = uasgreq.Send(context.TODO())
+	return err
+}
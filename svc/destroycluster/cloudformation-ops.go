@@ -26,47 +26,56 @@ func deleteStack(name string) error {
 	return nil
 }
 
-// lookupStacks returns the control plane stack name
-// and the dataplane stack name via matching labels.
-func lookupStack(clustername string) (string, string, error) {
+// nodeGroupStack is a data plane stack, representing a nodegroup
+type nodeGroupStack struct {
+	// Name is the stack name
+	Name string
+	// NodeGroup is the name of the nodegroup
+	NodeGroup string
+	// Deleting is true if the stack is being deleted
+	Deleting bool
+}
+
+// lookupStacks returns the control plane stack name and the
+// data plane stacks, one per nodegroup, via matching labels.
+// Data plane stacks being deleted are included, so that we
+// know when all nodegroups are gone.
+func lookupStacks(clustername string) (string, []nodeGroupStack, error) {
+	dpstacks := []nodeGroupStack{}
 	cfg, err := external.LoadDefaultAWSConfig()
 	if err != nil {
-		return "", "", err
+		return "", dpstacks, err
 	}
 	svc := cloudformation.New(cfg)
-	var activeStacks = []cloudformation.StackStatus{"CREATE_COMPLETE"}
+	var activeStacks = []cloudformation.StackStatus{"CREATE_COMPLETE", "DELETE_IN_PROGRESS"}
 	lsreq := svc.ListStacksRequest(&cloudformation.ListStacksInput{StackStatusFilter: activeStacks})
-	if err != nil {
-		return "", "", err
-	}
 	lsresp, err := lsreq.Send(context.TODO())
 	if err != nil {
-		return "", "", err
+		return "", dpstacks, err
 	}
-	// iterate over active stacks to find the two eksctl created, by label
-	cpstack, dpstack := "", ""
+	// iterate over active stacks to find the ones eksctl created, by label
+	cpstack := ""
 	for _, stack := range lsresp.StackSummaries {
 		dsreq := svc.DescribeStacksRequest(&cloudformation.DescribeStacksInput{StackName: stack.StackName})
-		if err != nil {
-			return "", "", err
-		}
 		dsresp, err := dsreq.Send(context.TODO())
 		if err != nil {
-			return "", "", err
+			return "", dpstacks, err
 		}
 		// fmt.Printf("DEBUG:: checking stack %v if it has label with cluster name %v\n", *dsresp.Stacks[0].StackName, clustername)
 		cnofstack := tagValueOf(dsresp.Stacks[0], "eksctl.cluster.k8s.io/v1alpha1/cluster-name")
 		if cnofstack != "" && cnofstack == clustername {
+			deleting := dsresp.Stacks[0].StackStatus == cloudformation.StackStatusDeleteInProgress
+			nodegroup := tagValueOf(dsresp.Stacks[0], "alpha.eksctl.io/nodegroup-name")
 			switch {
-			case tagValueOf(dsresp.Stacks[0], "alpha.eksctl.io/nodegroup-name") != "":
-				dpstack = *dsresp.Stacks[0].StackName
-			default:
+			case nodegroup != "":
+				dpstacks = append(dpstacks, nodeGroupStack{*dsresp.Stacks[0].StackName, nodegroup, deleting})
+			case !deleting:
 				cpstack = *dsresp.Stacks[0].StackName
 			}
 		}
 	}
-	fmt.Printf("DEBUG:: found control plane stack [%v] and data plane stack [%v] for cluster %v\n", cpstack, dpstack, clustername)
-	return cpstack, dpstack, nil
+	fmt.Printf("DEBUG:: found control plane stack [%v] and data plane stacks %v for cluster %v\n", cpstack, dpstacks, clustername)
+	return cpstack, dpstacks, nil
 }
 
 // tagValueOf searches through the tags of a CF stack and
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// describeProvisioner returns the last known status of the
+// provisioning task and, if eksctl failed, why
+func describeProvisioner(taskARN string) (status, failure string, err error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return "", "", err
+	}
+	svc := ecs.New(cfg)
+	req := svc.DescribeTasksRequest(&ecs.DescribeTasksInput{
+		Cluster: aws.String(os.Getenv("PROVISIONER_CLUSTER")),
+		Tasks:   []string{taskARN},
+	})
+	resp, err := req.Send(context.TODO())
+	if err != nil {
+		return "", "", err
+	}
+	if len(resp.Tasks) == 0 {
+		// ECS only keeps stopped tasks around for a while, so we
+		// can't tell anymore, but if the cluster didn't become active
+		// the provisioning timeout takes care of it:
+		return eksp.TaskStopped, "", nil
+	}
+	status, failure = provisionerStatus(resp.Tasks[0])
+	return status, failure, nil
+}
+
+// provisionerStatus returns the status of the provisioning task
+// and, if it stopped because eksctl failed, why
+func provisionerStatus(task ecs.Task) (status, failure string) {
+	status = aws.StringValue(task.LastStatus)
+	if status != eksp.TaskStopped {
+		return status, ""
+	}
+	for _, c := range task.Containers {
+		switch {
+		case c.ExitCode == nil: // the container didn't even start
+			return status, fmt.Sprintf("%v: %v", aws.StringValue(task.StoppedReason), aws.StringValue(c.Reason))
+		case *c.ExitCode != 0:
+			return status, fmt.Sprintf("eksctl exited with code %v, check the logs for details", *c.ExitCode)
+		}
+	}
+	return status, ""
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+)
+
+func TestProvisionerStatus(t *testing.T) {
+	exited := func(code int64) ecs.Task {
+		return ecs.Task{
+			LastStatus: aws.String("STOPPED"),
+			Containers: []ecs.Container{{Name: aws.String("eksctl"), ExitCode: aws.Int64(code)}},
+		}
+	}
+	cases := map[string]struct {
+		task    ecs.Task
+		status  string
+		failure string
+	}{
+		"pending": {
+			task:   ecs.Task{LastStatus: aws.String("PENDING")},
+			status: "PENDING",
+		},
+		"running": {
+			task:   ecs.Task{LastStatus: aws.String("RUNNING")},
+			status: "RUNNING",
+		},
+		"eksctl succeeded": {
+			task:   exited(0),
+			status: "STOPPED",
+		},
+		"eksctl failed": {
+			task:    exited(1),
+			status:  "STOPPED",
+			failure: "eksctl exited with code 1, check the logs for details",
+		},
+		"container didn't start": {
+			task: ecs.Task{
+				LastStatus:    aws.String("STOPPED"),
+				StoppedReason: aws.String("Task failed to start"),
+				Containers:    []ecs.Container{{Name: aws.String("eksctl"), Reason: aws.String("CannotPullContainerError")}},
+			},
+			status:  "STOPPED",
+			failure: "Task failed to start: CannotPullContainerError",
+		},
+	}
+	for name, c := range cases {
+		status, failure := provisionerStatus(c.task)
+		if status != c.status || failure != c.failure {
+			t.Errorf("%v: provisionerStatus() = (%q, %q), want (%q, %q)", name, status, failure, c.status, c.failure)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+)
+
+// isClusterActive returns true if the EKS cluster
+// with the given name is active, that is, ready to be used
+func isClusterActive(clustername string) (bool, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return false, err
+	}
+	svc := eks.New(cfg)
+	dcreq := svc.DescribeClusterRequest(&eks.DescribeClusterInput{Name: &clustername})
+	fmt.Printf("DEBUG:: looking up status of cluster %v\n", clustername)
+	dcrresp, err := dcreq.Send(context.TODO())
+	if err != nil {
+		return false, err
+	}
+	return dcrresp.Cluster.Status == eks.ClusterStatusActive, nil
+}
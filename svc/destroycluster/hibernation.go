@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// hibernateCluster scales all nodegroups of the cluster to zero,
+// recording their sizes so that they can be restored on wake up.
+// If a nodegroup can't be scaled to zero, the ones already scaled are
+// restored and the cluster is left as is, to try again next time.
+func hibernateCluster(clusterbucket string, cs eksp.ClusterSpec, reason string) (eksp.ClusterSpec, error) {
+	_, dpstacks, err := lookupStacks(cs.Name)
+	if err != nil {
+		return cs, err
+	}
+	// record the sizes of all nodegroups before
+	// scaling any, so we can restore them on wake up:
+	hngs := []eksp.HibernatedNodeGroup{}
+	for _, dpstack := range dpstacks {
+		hng, err := lookupNodeGroup(dpstack)
+		if err != nil {
+			return cs, err
+		}
+		hngs = append(hngs, hng)
+	}
+	for i, hng := range hngs {
+		err = scaleToZero(hng)
+		if err != nil {
+			// otherwise we'd record a size of zero for
+			// these on the next attempt to hibernate:
+			for _, scaled := range hngs[:i] {
+				rerr := restoreNodeGroup(scaled)
+				if rerr != nil {
+					fmt.Printf("Can't scale nodegroup %v of cluster %v back up: %v\n", scaled.NodeGroup, cs.ID, rerr)
+				}
+			}
+			return cs, fmt.Errorf("Can't scale nodegroup %v of cluster %v to zero: %v", hng.NodeGroup, cs.ID, err)
+		}
+	}
+	before := cs
+	cs.Phase = eksp.PhaseHibernated
+	cs.HibernationTime = fmt.Sprintf("%v", time.Now().Unix())
+	cs.HibernationReason = reason
+	cs.HibernatedNodeGroups = hngs
+	cs.TTL = 0
+	eksp.AuditSpecChange(clusterbucket, reaperActor, "hibernate", before, cs)
+	eksp.EmitWebhook(clusterbucket, "hibernated", cs)
+	return cs, nil
+}
+
+// wakeCluster restores the nodegroups of a hibernated cluster
+// to their previous sizes. If a nodegroup
+// can't be restored, the cluster stays hibernated, to try again next time.
+func wakeCluster(clusterbucket string, cs eksp.ClusterSpec) (eksp.ClusterSpec, error) {
+	for _, hng := range cs.HibernatedNodeGroups {
+		err := restoreNodeGroup(hng)
+		if err != nil {
+			return cs, fmt.Errorf("Can't scale nodegroup %v of cluster %v back up: %v", hng.NodeGroup, cs.ID, err)
+		}
+	}
+	before := cs
+	cs = eksp.WakeUp(cs, time.Now())
+	eksp.AuditSpecChange(clusterbucket, reaperActor, "wake", before, cs)
+	eksp.EmitWebhook(clusterbucket, "woken", cs)
+	return cs, nil
+}
+
+// scheduled returns true if the cluster has a schedule
+// and is in a lifecycle phase the schedule applies to
+func scheduled(cs eksp.ClusterSpec) bool {
+	return cs.Schedule != nil && cs.TeardownReason == "" &&
+		(cs.Phase == "" || cs.Phase == eksp.PhaseHibernated) &&
+		(cs.ProvisioningTask == "" || cs.ActivationTime != "")
+}
+
+// applySchedule hibernates or wakes up the cluster once the next
+// transition of its schedule is due. Only clusters hibernated by the
+// schedule are woken up, and the schedule only acts on transitions, so
+// a cluster woken up manually stays up until the end of the day.
+func applySchedule(clusterbucket string, cs eksp.ClusterSpec) (eksp.ClusterSpec, error) {
+	now := time.Now()
+	if cs.NextTransition != "" {
+		nt, err := strconv.ParseInt(cs.NextTransition, 10, 64)
+		if err == nil && now.Before(time.Unix(nt, 0)) {
+			return cs, nil
+		}
+	}
+	w, err := eksp.ParseSchedule(*cs.Schedule)
+	if err != nil {
+		fmt.Printf("Can't apply the schedule of cluster %v: %v\n", cs.ID, err)
+		return cs, nil
+	}
+	active := w.ActiveAt(now)
+	switch {
+	case !active && cs.Phase == "":
+		fmt.Printf("Hibernating EKS cluster %v outside of its schedule\n", cs.ID)
+		cs, err = hibernateCluster(clusterbucket, cs, eksp.HibernationSchedule)
+		if err != nil {
+			return cs, err
+		}
+	case active && cs.Phase == eksp.PhaseHibernated && cs.HibernationReason == eksp.HibernationSchedule:
+		fmt.Printf("Waking up EKS cluster %v as per its schedule\n", cs.ID)
+		cs, err = wakeCluster(clusterbucket, cs)
+		if err != nil {
+			return cs, err
+		}
+	}
+	cs.NextTransition = ""
+	if nt := w.NextTransition(now); !nt.IsZero() {
+		cs.NextTransition = fmt.Sprintf("%v", nt.Unix())
+	}
+	fmt.Printf("DEBUG:: next schedule transition of cluster %v at %v\n", cs.ID, cs.NextTransition)
+	return cs, nil
+}
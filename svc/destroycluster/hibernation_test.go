@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+func TestScheduled(t *testing.T) {
+	working := &eksp.Schedule{Days: "Mon-Fri", Start: "08:00", End: "19:00", TimeZone: "Europe/Berlin"}
+	provisioned := eksp.ClusterSpec{
+		Schedule:         working,
+		ProvisioningTask: "arn:aws:ecs:us-east-2:123456789012:task/eksphemeral/0123",
+		ActivationTime:   "1561000000",
+	}
+	for name, cs := range map[string]eksp.ClusterSpec{
+		"active":                        provisioned,
+		"hibernated":                    {Schedule: working, Phase: eksp.PhaseHibernated},
+		"created by the client scripts": {Schedule: working},
+	} {
+		if !scheduled(cs) {
+			t.Errorf("%v cluster isn't scheduled, want it to be", name)
+		}
+	}
+	for name, cs := range map[string]eksp.ClusterSpec{
+		"unscheduled":  {},
+		"provisioning": {Schedule: working, ProvisioningTask: provisioned.ProvisioningTask},
+		"tearing down": {Schedule: working, Phase: eksp.PhaseTearingDown},
+		"deleted":      {Schedule: working, TeardownReason: "deleted by jane@example.com"},
+	} {
+		if scheduled(cs) {
+			t.Errorf("%v cluster is scheduled, want it not to be", name)
+		}
+	}
+}
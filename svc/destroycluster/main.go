@@ -1,47 +1,44 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"os"
+	"reflect"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/aws/external"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
 )
 
-// ClusterSpec represents the parameters for eksctl,
-// as cluster metadata including owner and how long the cluster
-// still has to live.
-type ClusterSpec struct {
-	// ID is a unique identifier for the cluster
-	ID string `json:"id"`
-	// Name specifies the cluster name
-	Name string `json:"name"`
-	// NumWorkers specifies the number of worker nodes, defaults to 1
-	NumWorkers int `json:"numworkers"`
-	// KubeVersion  specifies the Kubernetes version to use, defaults to `1.12`
-	KubeVersion string `json:"kubeversion"`
-	// Timeout specifies the timeout in minutes, after which the cluster
-	// is destroyed, defaults to 10
-	Timeout int `json:"timeout"`
-	// Timeout specifies the cluster time to live in minutes.
-	// In other words: the remaining time the cluster has before it is destroyed
-	TTL int `json:"ttl"`
-	// Owner specifies the email address of the owner (will be notified when cluster is created and 5 min before destruction)
-	Owner string `json:"owner"`
-	// CreationTime is the UTC timestamp of when the cluster was created
-	// which equals the point in time of the creation of the respective
-	// JSON representation of the cluster spec as an object in the metadata
-	// bucket
-	CreationTime string `json:"created"`
+const (
+
+	// onExpiryHibernate is the expiry policy of clusters to
+	// be hibernated rather than torn down
+	onExpiryHibernate = "hibernate"
+	// provisioningTimeout is how long we wait for
+	// a cluster to become active after it was created
+	provisioningTimeout = 45 * time.Minute
+)
+
+// provisioning returns true if the cluster is still being provisioned
+// by eksctl. We only allow so much time for that, after which we
+// consider the provisioning failed and tear down the cluster.
+func provisioning(cs eksp.ClusterSpec, clusterage time.Duration) bool {
+	return cs.ProvisioningTask != "" && cs.ActivationTime == "" &&
+		cs.TeardownReason == "" && clusterage < provisioningTimeout
+}
+
+// hibernates returns true if the cluster is to be hibernated rather
+// than torn down once its timeout expired. Clusters deleted by users or
+// which failed to provision are torn down in any case.
+func hibernates(cs eksp.ClusterSpec) bool {
+	return cs.OnExpiry == onExpiryHibernate && cs.TeardownReason == ""
 }
 
 // getClusterAge returns the age of the cluster
-func getClusterAge(cs ClusterSpec) (time.Duration, error) {
+func getClusterAge(cs eksp.ClusterSpec) (time.Duration, error) {
 	ct, err := strconv.ParseInt(cs.CreationTime, 10, 64)
 	if err != nil {
 		return 0 * time.Minute, err
@@ -50,30 +47,118 @@ func getClusterAge(cs ClusterSpec) (time.Duration, error) {
 	return clusterage, nil
 }
 
+// activate records that the cluster became active. For clusters we
+// provisioned, the timeout counts from when the cluster is ready to be used.
+func activate(cs eksp.ClusterSpec, now time.Time) eksp.ClusterSpec {
+	cs.ActivationTime = fmt.Sprintf("%v", now.Unix())
+	if cs.ProvisioningTask != "" {
+		cs.CreationTime = cs.ActivationTime
+	}
+	return cs
+}
+
+// trackProvisioning records the status of the provisioning task. If the
+// task failed, the cluster expires right away, so that we clean up
+// whatever eksctl might have created.
+func trackProvisioning(cs eksp.ClusterSpec, status, failure string) eksp.ClusterSpec {
+	cs.ProvisioningStatus = status
+	if failure != "" {
+		cs.ProvisioningError = failure
+		cs.Timeout = 0
+		cs.TeardownReason = "provisioning failed"
+	}
+	return cs
+}
+
+// storeClusterSpec saves the changes made to the cluster spec, unless
+// the spec has been changed since we fetched it, such as by a user
+// deleting or prolonging the cluster, in which case we leave the
+// cluster alone and look at it again next time
+func storeClusterSpec(clusterbucket string, fetched, cs eksp.ClusterSpec, etag string) {
+	if reflect.DeepEqual(fetched, cs) {
+		return
+	}
+	stored, err := eksp.ReplaceClusterSpec(clusterbucket, cs, etag)
+	if err != nil {
+		fmt.Printf("Can't store the spec of cluster %v: %v\n", cs.ID, err)
+		return
+	}
+	if !stored {
+		fmt.Printf("Cluster %v has been changed in the meantime, skipping it until next time\n", cs.ID)
+	}
+}
+
 func handler() error {
 	fmt.Printf("DEBUG:: destroy cluster start\n")
 	clusterbucket := os.Getenv("CLUSTER_METADATA_BUCKET")
-	cfg, err := external.LoadDefaultAWSConfig()
-	if err != nil {
-		fmt.Println(err)
-		return err
-	}
-	svc := s3.New(cfg)
 	fmt.Printf("Scanning bucket %v for cluster specs\n", clusterbucket)
-	req := svc.ListObjectsRequest(&s3.ListObjectsInput{
-		Bucket: &clusterbucket,
-		// Delimiter: aws.String("/"),
-	},
-	)
-	resp, err := req.Send(context.TODO())
+	clusterIDs, err := eksp.ListClusterIDs(clusterbucket)
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
-	for _, obj := range resp.Contents {
-		fn := *obj.Key
-		clusterID := strings.TrimSuffix(fn, ".json")
-		cs, err := fetchClusterSpec(clusterbucket, clusterID)
+	for _, clusterID := range clusterIDs {
+		cs, etag, err := eksp.FetchClusterSpecVersion(clusterbucket, clusterID)
+		if err != nil {
+			// the cluster might have been torn down in the meantime:
+			if _, ok := err.(eksp.NotFoundError); ok {
+				continue
+			}
+			return err
+		}
+		fetched := cs
+		// make sure clusters we don't store below, such as hibernated
+		// ones, can be looked up by name, too, even if they were
+		// created before we indexed clusters by name:
+		err = eksp.IndexClusterNames(clusterbucket, cs)
+		if err != nil {
+			return err
+		}
+		// check if the cluster became active since we last looked:
+		if cs.ActivationTime == "" && cs.Phase == "" {
+			active, err := isClusterActive(cs.Name)
+			if err != nil {
+				fmt.Printf("Can't look up the status of cluster %v: %v\n", clusterID, err)
+			}
+			if active {
+				before := cs
+				cs = activate(cs, time.Now())
+				eksp.AuditSpecChange(clusterbucket, reaperActor, "activate", before, cs)
+				eksp.EmitWebhook(clusterbucket, "active", cs)
+				if cs.Owner != "" {
+					fmt.Printf("Attempting to send owners %v an info concerning the availability of cluster %v\n", eksp.OwnersOf(cs), clusterID)
+					subject := fmt.Sprintf("EKS cluster %v created and available", cs.Name)
+					body := fmt.Sprintf("Hello there,\n\nThis is to inform you that your EKS cluster %v (cluster ID %v) is now available for you to use.%v\n\nHave a nice day,\nEKSphemeral", cs.Name, clusterID, eksp.CostNote(clusterbucket, cs))
+					err := eksp.InformOwners(clusterbucket, reaperActor, cs, subject, body)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+		// keep track of how provisioning is going, noting that eksctl carries
+		// on creating the worker nodes once the cluster is active:
+		if cs.Phase == "" && cs.ProvisioningTask != "" && cs.ProvisioningStatus != eksp.TaskStopped {
+			status, failure, err := describeProvisioner(cs.ProvisioningTask)
+			if err != nil {
+				fmt.Printf("Can't look up the provisioning task of cluster %v: %v\n", clusterID, err)
+			}
+			if err == nil && (status != cs.ProvisioningStatus || failure != "") {
+				before := cs
+				cs = trackProvisioning(cs, status, failure)
+				eksp.AuditSpecChange(clusterbucket, reaperActor, "provision", before, cs)
+			}
+		}
+		// hibernate or wake up the cluster as per its schedule:
+		if scheduled(cs) {
+			cs, err = applySchedule(clusterbucket, cs)
+			if err != nil {
+				// don't hold up the other clusters, we try again next time:
+				fmt.Printf("Can't apply the schedule of cluster %v: %v\n", clusterID, err)
+				storeClusterSpec(clusterbucket, fetched, cs, etag)
+				continue
+			}
+		}
 		clusterage, err := getClusterAge(cs)
 		if err != nil {
 			return err
@@ -82,63 +167,135 @@ func handler() error {
 		headsuptime := timeout - 5*time.Minute
 		ttl := timeout - clusterage
 		switch {
+		case provisioning(cs, clusterage): // give eksctl time to do its thing
+			fmt.Printf("Cluster %v is still being provisioned, %.0f min in\n", clusterID, clusterage.Minutes())
+			storeClusterSpec(clusterbucket, fetched, cs, etag)
+			continue
+		case cs.Phase == eksp.PhaseHibernated && cs.TeardownReason == "": // sleeping until woken up
+			fmt.Printf("Cluster %v is hibernated\n", clusterID)
+			storeClusterSpec(clusterbucket, fetched, cs, etag)
+			continue
+		case cs.WarmPool != "" && cs.TeardownReason == "": // idle until claimed, its timeout starts then
+			fmt.Printf("Cluster %v is waiting in warm pool %v to be claimed\n", clusterID, cs.WarmPool)
+			storeClusterSpec(clusterbucket, fetched, cs, etag)
+			continue
+		case clusterage > timeout && cs.Phase == "" && hibernates(cs): // time is up, but keep the control plane around
+			fmt.Printf("Hibernating EKS cluster %v\n", clusterID)
+			cs, err = hibernateCluster(clusterbucket, cs, eksp.HibernationTimeout)
+			if err != nil {
+				// don't hold up the other clusters, we try again next time:
+				fmt.Printf("Can't hibernate cluster %v: %v\n", clusterID, err)
+				storeClusterSpec(clusterbucket, fetched, cs, etag)
+				continue
+			}
+			ttl = 0
+			if cs.Owner != "" {
+				fmt.Printf("Attempting to send owners %v an info concerning the hibernation of cluster %v\n", eksp.OwnersOf(cs), clusterID)
+				subject := fmt.Sprintf("EKS cluster %v hibernated", cs.Name)
+				body := fmt.Sprintf("Hello there,\n\nThis is to inform you that your EKS cluster %v (cluster ID %v) has been hibernated: its worker nodes are gone, the control plane is kept around. Use eksp wake %v to bring the worker nodes back.%v\n\nHave a nice day,\nEKSphemeral", cs.Name, clusterID, clusterID, eksp.CostNote(clusterbucket, cs))
+				err := eksp.InformOwners(clusterbucket, reaperActor, cs, subject, body)
+				if err != nil {
+					return err
+				}
+			}
 		case clusterage > timeout: // time is up, let's get rid of dat thing
 			fmt.Printf("Tearing down EKS cluster %v\n", clusterID)
-			if err != nil {
-				return err
+			if cs.Phase != eksp.PhaseTearingDown {
+				before := cs
+				cs.Phase = eksp.PhaseTearingDown
+				cs.TeardownTime = fmt.Sprintf("%v", time.Now().Unix())
+				if cs.TeardownReason == "" {
+					cs.TeardownReason = "timeout expired"
+				}
+				eksp.AuditSpecChange(clusterbucket, reaperActor, "teardown", before, cs)
+				eksp.EmitWebhook(clusterbucket, "deleting", cs)
 			}
 			// data plane tear down:
-			cpstack, dpstack, err := lookupStack(cs.Name)
+			cpstack, dpstacks, err := lookupStacks(cs.Name)
 			if err != nil {
 				return err
 			}
 			switch {
-			// if this time around there's a stack
-			// representing the data plane, delete it:
-			case dpstack != "":
-				err = deleteStack(dpstack)
-				if err != nil {
-					return err
+			// if this time around there are stacks
+			// representing nodegroups, delete them all:
+			case len(dpstacks) > 0:
+				for _, dpstack := range dpstacks {
+					if dpstack.Deleting {
+						fmt.Printf("DEBUG:: nodegroup %v of cluster %v is being deleted\n", dpstack.NodeGroup, clusterID)
+						continue
+					}
+					err = deleteStack(dpstack.Name)
+					if err != nil {
+						return err
+					}
+					auditStackDeletion(clusterbucket, cs.ID, "data", dpstack.Name)
 				}
-			// if this time around there's no more stack
-			// representing the data plane but there's still
+			// if this time around there are no more stacks
+			// representing nodegroups but there's still
 			// a control plane stack, delete it:
-			case dpstack == "" && cpstack != "":
+			case cpstack != "":
 				err = deleteStack(cpstack)
 				if err != nil {
 					return err
 				}
+				auditStackDeletion(clusterbucket, cs.ID, "control", cpstack)
 			// if this time around there's neither a stack
 			// representing the data plane nor a control plane
 			// stack, we're ready to delete the cluster spec entry
 			// from the metadata bucket:
-			case dpstack == "" && cpstack == "":
-				err := rmClusterSpec(clusterbucket, cs.ID)
+			case len(dpstacks) == 0 && cpstack == "":
+				before := cs
+				cs.Phase = eksp.PhaseDeleted
+				err := archiveClusterSpec(clusterbucket, cs)
+				if err != nil {
+					return err
+				}
+				err = rmClusterSpec(clusterbucket, cs.ID)
+				if err != nil {
+					return err
+				}
+				err = eksp.ReleaseClusterNames(clusterbucket, cs)
+				if err != nil {
+					return err
+				}
+				err = rmObject(clusterbucket, eksp.ClusterConfigKey(cs.ID))
 				if err != nil {
 					return err
 				}
-				// now we need to exit in order to avoid cluster
-				// update (storing the spec) as per usual
-				// this solves the orphaned cluster issue
-				return nil
+				err = rmObject(clusterbucket, eksp.ClaimKey(cs.ID))
+				if err != nil {
+					return err
+				}
+				eksp.AuditSpecChange(clusterbucket, reaperActor, "archive", before, cs)
+				eksp.EmitWebhook(clusterbucket, "deleted", cs)
+				// now we need to skip storing the spec as per usual,
+				// which would bring back the cluster we just deleted:
+				continue
 			default:
 				fmt.Printf("DEBUG:: seems both control and data plane stacks and all cluster metadata have been deleted, so this would be a NOP.\n")
 			}
-		case clusterage > headsuptime: // oho, it's time to nudge the owner
+		case clusterage > headsuptime && cs.ExpiryWarningTime == "": // oho, it's time to nudge the owner, once
 			if cs.Owner != "" {
-				fmt.Printf("Attempting to send owner %v a warning concerning tear down of cluster %v\n", cs.Owner, clusterID)
+				fmt.Printf("Attempting to send owners %v a warning concerning tear down of cluster %v\n", eksp.OwnersOf(cs), clusterID)
+				note := eksp.CostNote(clusterbucket, cs)
 				subject := fmt.Sprintf("EKS cluster %v shutting down in 5 min", cs.Name)
-				body := fmt.Sprintf("Hello there,\n\nThis is to inform you that your EKS cluster %v (cluster ID %v) will shut down and all associated resources destroyed within the next few minutes.\n\nHave a nice day,\nEKSphemeral", cs.Name, clusterID)
-				err := informOwner(cs.Owner, subject, body)
+				body := fmt.Sprintf("Hello there,\n\nThis is to inform you that your EKS cluster %v (cluster ID %v) will shut down and all associated resources destroyed within the next few minutes.%v\n\nHave a nice day,\nEKSphemeral", cs.Name, clusterID, note)
+				if hibernates(cs) {
+					subject = fmt.Sprintf("EKS cluster %v hibernating in 5 min", cs.Name)
+					body = fmt.Sprintf("Hello there,\n\nThis is to inform you that your EKS cluster %v (cluster ID %v) will hibernate within the next few minutes, that is, its worker nodes will be scaled to zero.%v\n\nHave a nice day,\nEKSphemeral", cs.Name, clusterID, note)
+				}
+				err := eksp.InformOwners(clusterbucket, reaperActor, cs, subject, body)
 				if err != nil {
 					return err
 				}
 			}
+			cs.ExpiryWarningTime = fmt.Sprintf("%v", time.Now().Unix())
+			eksp.EmitWebhook(clusterbucket, "expiring", cs)
 		default: // business as usual, just log age
 			fmt.Printf("Cluster %v is %.0f min old has %.0f min to live, left\n", clusterID, clusterage.Minutes(), ttl.Minutes())
 		}
 		cs.TTL = int(ttl.Minutes())
-		storeClusterSpec(clusterbucket, cs)
+		storeClusterSpec(clusterbucket, fetched, cs, etag)
 	}
 	fmt.Printf("DEBUG:: destroy cluster done\n")
 	return nil
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+func TestProvisioning(t *testing.T) {
+	cs := eksp.ClusterSpec{
+		ProvisioningTask: "arn:aws:ecs:us-east-2:123456789012:task/eksphemeral/0123",
+	}
+	if !provisioning(cs, 10*time.Minute) {
+		t.Error("expected a fresh cluster with a provisioning task to be provisioning")
+	}
+	if provisioning(cs, provisioningTimeout+time.Minute) {
+		t.Error("expected provisioning to give up after the timeout")
+	}
+
+	activated := cs
+	activated.ActivationTime = "1561000000"
+	if provisioning(activated, 10*time.Minute) {
+		t.Error("expected an active cluster not to be provisioning")
+	}
+
+	failed := cs
+	failed.TeardownReason = "provisioning failed"
+	if provisioning(failed, 10*time.Minute) {
+		t.Error("expected a cluster torn down not to be provisioning")
+	}
+
+	// clusters created by the client scripts have no provisioning task:
+	if provisioning(eksp.ClusterSpec{}, 10*time.Minute) {
+		t.Error("expected a cluster without provisioning task not to be provisioning")
+	}
+}
+
+func TestGetClusterAge(t *testing.T) {
+	created := time.Now().Add(-90 * time.Minute)
+	age, err := getClusterAge(eksp.ClusterSpec{CreationTime: fmt.Sprintf("%v", created.Unix())})
+	if err != nil {
+		t.Fatalf("getClusterAge() failed: %v", err)
+	}
+	if age < 89*time.Minute || age > 91*time.Minute {
+		t.Errorf("getClusterAge() = %v, want about 90m", age)
+	}
+	if _, err := getClusterAge(eksp.ClusterSpec{CreationTime: "yesterday"}); err == nil {
+		t.Error("expected an error for a malformed creation time")
+	}
+}
+
+func TestHibernates(t *testing.T) {
+	tests := []struct {
+		onexpiry, reason string
+		want             bool
+	}{
+		{"", "", false},
+		{"destroy", "", false},
+		{"hibernate", "", true},
+		{"hibernate", "deleted by jane@example.com", false},
+		{"hibernate", "provisioning failed", false},
+	}
+	for _, tt := range tests {
+		cs := eksp.ClusterSpec{OnExpiry: tt.onexpiry, TeardownReason: tt.reason}
+		if got := hibernates(cs); got != tt.want {
+			t.Errorf("hibernates() with expiry policy %q and teardown reason %q = %v, want %v", tt.onexpiry, tt.reason, got, tt.want)
+		}
+	}
+}
+
+// a warm pool cluster can be claimed once the reaper noticed that it's
+// active and that eksctl is done creating its worker nodes, which only
+// holds if the reaper stores what it noticed
+func TestActivationMakesWarmClusterReady(t *testing.T) {
+	fetched := eksp.ClusterSpec{
+		ID:                 "e-abc123",
+		Name:               "ci-1a2b3c",
+		WarmPool:           "ci",
+		CreationTime:       "1561000000",
+		Timeout:            60,
+		ProvisioningTask:   "arn:aws:ecs:us-east-2:123456789012:task/eksphemeral/0123",
+		ProvisioningStatus: "RUNNING",
+	}
+	if eksp.ReadyToClaim(fetched, "ci") {
+		t.Fatal("cluster is ready before it became active")
+	}
+	cs := trackProvisioning(activate(fetched, time.Unix(1561000900, 0)), eksp.TaskStopped, "")
+	if reflect.DeepEqual(cs, fetched) {
+		t.Fatal("reaper pass didn't change the spec, so it wouldn't be stored")
+	}
+	if !eksp.ReadyToClaim(cs, "ci") {
+		t.Errorf("cluster isn't ready after the reaper pass: %+v", cs)
+	}
+	if cs.CreationTime != "1561000900" {
+		t.Errorf("creation time = %v, want the timeout to count from activation", cs.CreationTime)
+	}
+}
+
+func TestTrackProvisioningFailure(t *testing.T) {
+	cs := trackProvisioning(eksp.ClusterSpec{Timeout: 60}, eksp.TaskStopped, "eksctl exited with 1")
+	if cs.Timeout != 0 || cs.TeardownReason != "provisioning failed" || cs.ProvisioningError != "eksctl exited with 1" {
+		t.Errorf("trackProvisioning() = %+v, want the cluster to expire right away", cs)
+	}
+}
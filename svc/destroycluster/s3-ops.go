@@ -5,53 +5,37 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/external"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-)
 
-// fetchClusterSpec returns the cluster spec
-// in a given bucket, with a given cluster ID
-func fetchClusterSpec(clusterbucket, clusterid string) (ClusterSpec, error) {
-	cs := ClusterSpec{}
-	cfg, err := external.LoadDefaultAWSConfig()
-	if err != nil {
-		return cs, err
-	}
-	downloader := s3manager.NewDownloader(cfg)
-	buf := aws.NewWriteAtBuffer([]byte{})
-	_, err = downloader.Download(buf, &s3.GetObjectInput{
-		Bucket: aws.String(clusterbucket),
-		Key:    aws.String(clusterid + ".json"),
-	})
-	if err != nil {
-		return cs, err
-	}
-	err = json.Unmarshal(buf.Bytes(), &cs)
-	if err != nil {
-		return cs, err
-	}
-	return cs, nil
-}
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
 
-// storeClusterSpec stores the cluster spec in a given bucket
-func storeClusterSpec(clusterbucket string, cs ClusterSpec) error {
+// archiveClusterSpec stores a tombstone for the cluster in the archive
+// of the metadata bucket, so that we can tell deleted clusters apart
+// from ones that never existed
+func archiveClusterSpec(clusterbucket string, cs eksp.ClusterSpec) error {
 	cfg, err := external.LoadDefaultAWSConfig()
 	if err != nil {
 		return err
 	}
-	csjson, err := json.Marshal(cs)
+	tsjson, err := json.Marshal(eksp.Tombstone{
+		ClusterSpec:  cs,
+		DeletionTime: fmt.Sprintf("%v", time.Now().Unix()),
+	})
 	if err != nil {
 		return err
 	}
 	uploader := s3manager.NewUploader(cfg)
 	_, err = uploader.Upload(&s3manager.UploadInput{
 		Bucket: aws.String(clusterbucket),
-		Key:    aws.String(cs.ID + ".json"),
-		Body:   strings.NewReader(string(csjson)),
+		Key:    aws.String(eksp.ArchivePrefix + cs.ID + ".json"),
+		Body:   strings.NewReader(string(tsjson)),
 	})
 	return err
 }
@@ -60,7 +44,13 @@ func storeClusterSpec(clusterbucket string, cs ClusterSpec) error {
 // in the metadata bucket and with that effectively
 // states the cluster doesn't exist anymore
 func rmClusterSpec(clusterbucket, clusterid string) error {
-	fmt.Printf("DEBUG:: attempting to remove cluster spec %v from bucket %v\n", clusterid+".json", clusterbucket)
+	return rmObject(clusterbucket, clusterid+".json")
+}
+
+// rmObject deletes the object with the given key in the metadata
+// bucket, which is a no-op if there's no such object
+func rmObject(clusterbucket, key string) error {
+	fmt.Printf("DEBUG:: attempting to remove %v from bucket %v\n", key, clusterbucket)
 	cfg, err := external.LoadDefaultAWSConfig()
 	if err != nil {
 		return err
@@ -68,11 +58,11 @@ func rmClusterSpec(clusterbucket, clusterid string) error {
 	svc := s3.New(cfg)
 	req := svc.DeleteObjectRequest(&s3.DeleteObjectInput{
 		Bucket: aws.String(clusterbucket),
-		Key:    aws.String(clusterid + ".json"),
+		Key:    aws.String(key),
 	})
 	_, err = req.Send(context.Background())
 	if err != nil {
-		fmt.Printf("DEBUG:: error removing cluster spec %v", err)
+		fmt.Printf("DEBUG:: error removing %v %v", key, err)
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
 			default:
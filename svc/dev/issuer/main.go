@@ -0,0 +1,189 @@
+// The issuer is a stand-in OIDC provider for developing and testing the
+// control plane with AUTH_MODE=oidc locally. It signs ID tokens for the one
+// user and groups given, and approves device logins once the verification
+// URL has been opened, or right away with -auto-approve.
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyID identifies the one signing key of the issuer
+const keyID = "eksp-dev"
+
+var (
+	issuer      = flag.String("issuer", "http://localhost:9999", "the URL the issuer is reachable at")
+	listen      = flag.String("listen", ":9999", "the address to listen on")
+	clientID    = flag.String("client-id", "eksphemeral", "the client ID, that is, audience of the ID tokens")
+	user        = flag.String("user", "jane@example.com", "the email address of the user to sign ID tokens for")
+	groups      = flag.String("groups", "", "the comma-separated groups the user is a member of, such as payments,ops")
+	ttl         = flag.Duration("ttl", time.Hour, "how long ID tokens are valid")
+	autoApprove = flag.Bool("auto-approve", false, "approve device logins without opening the verification URL")
+)
+
+var key *rsa.PrivateKey
+
+// approved tracks the device logins, by device code, and if they've been approved
+var approved = struct {
+	sync.Mutex
+	codes map[string]bool
+	users map[string]string
+}{codes: map[string]bool{}, users: map[string]string{}}
+
+func main() {
+	flag.Parse()
+	var err error
+	key, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	http.HandleFunc("/.well-known/openid-configuration", discovery)
+	http.HandleFunc("/jwks", keys)
+	http.HandleFunc("/device", device)
+	http.HandleFunc("/verify", verify)
+	http.HandleFunc("/token", token)
+	idtoken, err := sign()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Serving as OIDC issuer %v for %v, for example:\nexport EKSPHEMERAL_TOKEN=%v", *issuer, *user, idtoken)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
+
+func respond(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func discovery(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                *issuer,
+		"jwks_uri":                              *issuer + "/jwks",
+		"device_authorization_endpoint":         *issuer + "/device",
+		"token_endpoint":                        *issuer + "/token",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func keys(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": keyID,
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+}
+
+func randomCode(n int) string {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func device(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") != *clientID {
+		respond(w, http.StatusBadRequest, map[string]string{"error": "invalid_client"})
+		return
+	}
+	devicecode, usercode := randomCode(16), strings.ToUpper(randomCode(4))
+	approved.Lock()
+	approved.codes[devicecode] = *autoApprove
+	approved.users[usercode] = devicecode
+	approved.Unlock()
+	respond(w, http.StatusOK, map[string]interface{}{
+		"device_code":               devicecode,
+		"user_code":                 usercode,
+		"verification_uri":          *issuer + "/verify",
+		"verification_uri_complete": *issuer + "/verify?user_code=" + usercode,
+		"expires_in":                300,
+		"interval":                  1,
+	})
+}
+
+func verify(w http.ResponseWriter, r *http.Request) {
+	approved.Lock()
+	defer approved.Unlock()
+	devicecode, ok := approved.users[r.FormValue("user_code")]
+	if !ok {
+		http.Error(w, "unknown code", http.StatusNotFound)
+		return
+	}
+	approved.codes[devicecode] = true
+	fmt.Fprintf(w, "Logged in as %v, you can close this window now.\n", *user)
+}
+
+func token(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:device_code" {
+		respond(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	approved.Lock()
+	ok, known := approved.codes[r.FormValue("device_code")]
+	approved.Unlock()
+	switch {
+	case !known:
+		respond(w, http.StatusBadRequest, map[string]string{"error": "expired_token"})
+		return
+	case !ok:
+		respond(w, http.StatusBadRequest, map[string]string{"error": "authorization_pending"})
+		return
+	}
+	idtoken, err := sign()
+	if err != nil {
+		respond(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	respond(w, http.StatusOK, map[string]interface{}{
+		"id_token":   idtoken,
+		"token_type": "Bearer",
+		"expires_in": int(ttl.Seconds()),
+	})
+}
+
+// sign returns a signed ID token for the user
+func sign() (string, error) {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            *issuer,
+		"aud":            *clientID,
+		"sub":            *user,
+		"email":          *user,
+		"email_verified": true,
+		"iat":            now.Unix(),
+		"exp":            now.Add(*ttl).Unix(),
+	}
+	if *groups != "" {
+		claims["groups"] = strings.Split(*groups, ",")
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hashed := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
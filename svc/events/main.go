@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// listEventKeys returns the object keys of all events under the prefix,
+// which in the order S3 lists them are oldest first for a given cluster
+func listEventKeys(bucket, prefix string) ([]string, error) {
+	keys := []string{}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return keys, err
+	}
+	svc := s3.New(cfg)
+	input := &s3.ListObjectsInput{
+		Bucket: &bucket,
+		Prefix: aws.String(prefix),
+	}
+	for {
+		req := svc.ListObjectsRequest(input)
+		resp, err := req.Send(context.TODO())
+		if err != nil {
+			return keys, err
+		}
+		for _, obj := range resp.Contents {
+			keys = append(keys, *obj.Key)
+		}
+		if resp.IsTruncated == nil || !*resp.IsTruncated || len(resp.Contents) == 0 {
+			break
+		}
+		input.Marker = resp.Contents[len(resp.Contents)-1].Key
+	}
+	return keys, nil
+}
+
+// fetchEvent returns the event stored under the key
+func fetchEvent(bucket, key string) (eksp.Event, error) {
+	event := eksp.Event{}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return event, err
+	}
+	downloader := s3manager.NewDownloader(cfg)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	_, err = downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return event, err
+	}
+	err = json.Unmarshal(buf.Bytes(), &event)
+	return event, err
+}
+
+// eventsPrefixFor returns the prefix of the events of the referenced cluster.
+// Active clusters can be referred to as usual, while clusters which have been
+// torn down can only be referred to by their full cluster ID.
+func eventsPrefixFor(bucket, cref string) (string, error) {
+	if cref == "*" {
+		return eksp.EventsPrefix, nil
+	}
+	cID, err := eksp.ResolveClusterID(bucket, cref)
+	if err != nil {
+		if _, ok := err.(eksp.NotFoundError); !ok {
+			return "", err
+		}
+		cID = cref
+	}
+	return eksp.EventsPrefix + cID + "/", nil
+}
+
+func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	clusterbucket := os.Getenv("CLUSTER_METADATA_BUCKET")
+	fmt.Printf("DEBUG:: events start\n")
+	// validate cluster ID or list lookup in URL path:
+	if _, ok := request.PathParameters["clusterid"]; !ok {
+		return eksp.BadRequest("Unknown events query. Either specify a cluster ID or * for the events of all clusters.")
+	}
+	cref := request.PathParameters["clusterid"]
+	prefix, err := eventsPrefixFor(clusterbucket, cref)
+	if err != nil {
+		if aerr, ok := err.(eksp.AmbiguousRefError); ok {
+			return eksp.RespondError(http.StatusBadRequest, "ambiguous_reference", aerr.Error(), aerr.Candidates)
+		}
+		return eksp.ServerError(err)
+	}
+	keys, err := listEventKeys(clusterbucket, prefix)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if len(keys) == 0 && cref != "*" {
+		return eksp.RespondError(http.StatusNotFound, "not_found", fmt.Sprintf("There are no events for the cluster %v", cref), nil)
+	}
+	auditlog := []eksp.Event{}
+	for _, key := range keys {
+		event, err := fetchEvent(clusterbucket, key)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+		auditlog = append(auditlog, event)
+	}
+	// oldest events first, keeping the order
+	// of events happening in the same second:
+	sort.SliceStable(auditlog, func(i, j int) bool {
+		ti, _ := strconv.ParseInt(auditlog[i].Time, 10, 64)
+		tj, _ := strconv.ParseInt(auditlog[j].Time, 10, 64)
+		return ti < tj
+	})
+	fmt.Printf("DEBUG:: events done, found %v events\n", len(auditlog))
+	return eksp.Respond(http.StatusOK, auditlog)
+}
+
+func main() {
+	lambda.Start(eksp.Authenticated(handler))
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// HistoryQuery selects archived clusters
+type HistoryQuery struct {
+	// Owner, if set, only selects clusters owned by this email address
+	Owner string
+	// Since, if set, only selects clusters deleted at or after this time
+	Since time.Time
+	// Until, if set, only selects clusters deleted before this time
+	Until time.Time
+}
+
+// parseHistoryQuery parses the owner, since, and until query parameters,
+// where the dates are either plain dates such as 2019-07-08 or RFC 3339
+// timestamps. A plain date as until includes the entire day.
+func parseHistoryQuery(params map[string]string) (HistoryQuery, error) {
+	hq := HistoryQuery{
+		Owner: params["owner"],
+	}
+	if since, ok := params["since"]; ok && since != "" {
+		t, _, err := parseDate(since)
+		if err != nil {
+			return hq, fmt.Errorf("Invalid since parameter, please use a date such as 2019-07-08 or an RFC 3339 timestamp")
+		}
+		hq.Since = t
+	}
+	if until, ok := params["until"]; ok && until != "" {
+		t, dateonly, err := parseDate(until)
+		if err != nil {
+			return hq, fmt.Errorf("Invalid until parameter, please use a date such as 2019-07-08 or an RFC 3339 timestamp")
+		}
+		if dateonly {
+			t = t.AddDate(0, 0, 1)
+		}
+		hq.Until = t
+	}
+	return hq, nil
+}
+
+// parseDate parses either a plain date or an RFC 3339 timestamp
+// and reports which of the two it was
+func parseDate(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
+}
+
+// matches returns true if the tombstone is selected by the query
+func (hq HistoryQuery) matches(ts eksp.Tombstone) bool {
+	if hq.Owner != "" && !strings.EqualFold(hq.Owner, ts.Owner) {
+		return false
+	}
+	deleted, err := strconv.ParseInt(ts.DeletionTime, 10, 64)
+	if err != nil {
+		return false
+	}
+	return hq.inRange(time.Unix(deleted, 0))
+}
+
+// inRange returns true if the point in time is within the date range of the query
+func (hq HistoryQuery) inRange(t time.Time) bool {
+	if !hq.Since.IsZero() && t.Before(hq.Since) {
+		return false
+	}
+	if !hq.Until.IsZero() && !t.Before(hq.Until) {
+		return false
+	}
+	return true
+}
+
+// listArchive returns the object keys of all tombstones in the archive.
+// Since archiving is the last thing that happens to a cluster, the last
+// modified time of the object is a good first filter for the date range.
+func listArchive(bucket string, hq HistoryQuery) ([]string, error) {
+	keys := []string{}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return keys, err
+	}
+	svc := s3.New(cfg)
+	input := &s3.ListObjectsInput{
+		Bucket: &bucket,
+		Prefix: aws.String(eksp.ArchivePrefix),
+	}
+	for {
+		req := svc.ListObjectsRequest(input)
+		resp, err := req.Send(context.TODO())
+		if err != nil {
+			return keys, err
+		}
+		for _, obj := range resp.Contents {
+			if obj.LastModified != nil && !hq.inRange(*obj.LastModified) {
+				continue
+			}
+			keys = append(keys, *obj.Key)
+		}
+		if resp.IsTruncated == nil || !*resp.IsTruncated || len(resp.Contents) == 0 {
+			break
+		}
+		input.Marker = resp.Contents[len(resp.Contents)-1].Key
+	}
+	return keys, nil
+}
+
+// fetchTombstone returns the tombstone stored under the key
+func fetchTombstone(bucket, key string) (eksp.Tombstone, error) {
+	ts := eksp.Tombstone{}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return ts, err
+	}
+	downloader := s3manager.NewDownloader(cfg)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	_, err = downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ts, err
+	}
+	err = json.Unmarshal(buf.Bytes(), &ts)
+	return ts, err
+}
+
+func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	clusterbucket := os.Getenv("CLUSTER_METADATA_BUCKET")
+	fmt.Printf("DEBUG:: history start\n")
+	hq, err := parseHistoryQuery(request.QueryStringParameters)
+	if err != nil {
+		return eksp.BadRequest(err.Error())
+	}
+	keys, err := listArchive(clusterbucket, hq)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	history := []eksp.Tombstone{}
+	for _, key := range keys {
+		ts, err := fetchTombstone(clusterbucket, key)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+		if hq.matches(ts) {
+			history = append(history, ts)
+		}
+	}
+	// most recently deleted clusters first:
+	sort.Slice(history, func(i, j int) bool {
+		di, _ := strconv.ParseInt(history[i].DeletionTime, 10, 64)
+		dj, _ := strconv.ParseInt(history[j].DeletionTime, 10, 64)
+		return di > dj
+	})
+	fmt.Printf("DEBUG:: history done, found %v clusters\n", len(history))
+	return eksp.Respond(http.StatusOK, history)
+}
+
+func main() {
+	lambda.Start(eksp.Authenticated(handler))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+func TestParseHistoryQuery(t *testing.T) {
+	for _, params := range []map[string]string{
+		{"since": "2019-07-32"},
+		{"until": "yesterday"},
+		{"since": "2019-07-08T10:00:00"},
+	} {
+		if _, err := parseHistoryQuery(params); err == nil {
+			t.Errorf("parsed %v, want an error", params)
+		}
+	}
+	hq, err := parseHistoryQuery(map[string]string{"owner": "jane@example.com", "since": "", "until": ""})
+	if err != nil || hq.Owner != "jane@example.com" || !hq.Since.IsZero() || !hq.Until.IsZero() {
+		t.Errorf("got %+v, %v, want only the owner set", hq, err)
+	}
+}
+
+func TestHistoryQueryMatches(t *testing.T) {
+	deletedAt := func(ts string) eksp.Tombstone {
+		deleted, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return eksp.Tombstone{
+			ClusterSpec:  eksp.ClusterSpec{Owner: "Jane@example.com"},
+			DeletionTime: fmt.Sprintf("%v", deleted.Unix()),
+		}
+	}
+	tests := []struct {
+		params   map[string]string
+		ts       eksp.Tombstone
+		selected bool
+	}{
+		{map[string]string{}, deletedAt("2019-07-08T10:00:00Z"), true},
+		{map[string]string{"owner": "jane@example.com"}, deletedAt("2019-07-08T10:00:00Z"), true},
+		{map[string]string{"owner": "joe@example.com"}, deletedAt("2019-07-08T10:00:00Z"), false},
+		{map[string]string{"since": "2019-07-08"}, deletedAt("2019-07-08T00:00:00Z"), true},
+		{map[string]string{"since": "2019-07-08"}, deletedAt("2019-07-07T23:59:59Z"), false},
+		// a plain date as until includes the entire day:
+		{map[string]string{"until": "2019-07-08"}, deletedAt("2019-07-08T23:59:59Z"), true},
+		{map[string]string{"until": "2019-07-08"}, deletedAt("2019-07-09T00:00:00Z"), false},
+		{map[string]string{"until": "2019-07-08T12:00:00Z"}, deletedAt("2019-07-08T12:00:00Z"), false},
+		{map[string]string{"since": "2019-07-08T12:00:00+02:00"}, deletedAt("2019-07-08T10:00:00Z"), true},
+		{map[string]string{}, eksp.Tombstone{}, false},
+	}
+	for _, tt := range tests {
+		hq, err := parseHistoryQuery(tt.params)
+		if err != nil {
+			t.Errorf("can't parse %v: %v", tt.params, err)
+			continue
+		}
+		if got := hq.matches(tt.ts); got != tt.selected {
+			t.Errorf("query %v selects cluster deleted at %v is %v, want %v", tt.params, tt.ts.DeletionTime, got, tt.selected)
+		}
+	}
+}
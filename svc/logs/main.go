@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+const (
+	// provisionerLogStreamPrefix is the prefix of the log streams of the
+	// provisioning tasks, made up of the stream prefix configured in the
+	// task definition and the container name
+	provisionerLogStreamPrefix = "eksctl/eksctl/"
+)
+
+// lookupClusterSpec returns the cluster spec of the referenced cluster,
+// which can also be a cluster that has been torn down in the meantime,
+// referred to by its full cluster ID
+func lookupClusterSpec(bucket, cref string) (eksp.ClusterSpec, error) {
+	cID, err := eksp.ResolveClusterID(bucket, cref)
+	if err == nil {
+		return eksp.FetchClusterSpec(bucket, cID)
+	}
+	if _, ok := err.(eksp.NotFoundError); !ok {
+		return eksp.ClusterSpec{}, err
+	}
+	ts, terr := eksp.LookupTombstone(bucket, cref)
+	if terr != nil {
+		return eksp.ClusterSpec{}, terr
+	}
+	if ts == nil {
+		return eksp.ClusterSpec{}, err
+	}
+	return ts.ClusterSpec, nil
+}
+
+// fetchLogs returns the lines eksctl logged in the task with the given ARN,
+// starting after the given token or from the beginning if there's none
+func fetchLogs(taskARN, token string) ([]eksp.LogEvent, string, error) {
+	logevents := []eksp.LogEvent{}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return logevents, token, err
+	}
+	svc := cloudwatchlogs.New(cfg)
+	// the log stream is named after the task ID, the last part of the task ARN:
+	taskID := taskARN[strings.LastIndex(taskARN, "/")+1:]
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(os.Getenv("PROVISIONER_LOG_GROUP")),
+		LogStreamName: aws.String(provisionerLogStreamPrefix + taskID),
+		StartFromHead: aws.Bool(true),
+	}
+	if token != "" {
+		input.NextToken = aws.String(token)
+	}
+	req := svc.GetLogEventsRequest(input)
+	resp, err := req.Send(context.TODO())
+	if err != nil {
+		// until eksctl logs its first line, there's no log stream:
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
+			return logevents, token, nil
+		}
+		return logevents, token, err
+	}
+	for _, e := range resp.Events {
+		logevents = append(logevents, eksp.LogEvent{
+			Time:    aws.Int64Value(e.Timestamp),
+			Message: aws.StringValue(e.Message),
+		})
+	}
+	return logevents, aws.StringValue(resp.NextForwardToken), nil
+}
+
+func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	clusterbucket := os.Getenv("CLUSTER_METADATA_BUCKET")
+	fmt.Printf("DEBUG:: logs start\n")
+	// validate cluster ID:
+	if _, ok := request.PathParameters["clusterid"]; !ok {
+		return eksp.BadRequest("Unknown logs query, please specify a valid cluster ID.")
+	}
+	cref := request.PathParameters["clusterid"]
+	cs, err := lookupClusterSpec(clusterbucket, cref)
+	if err != nil {
+		switch cerr := err.(type) {
+		case eksp.NotFoundError:
+			return eksp.RespondError(http.StatusNotFound, "not_found", cerr.Error(), nil)
+		case eksp.AmbiguousRefError:
+			return eksp.RespondError(http.StatusBadRequest, "ambiguous_reference", cerr.Error(), cerr.Candidates)
+		default:
+			return eksp.ServerError(err)
+		}
+	}
+	if cs.ProvisioningTask == "" {
+		return eksp.RespondError(http.StatusNotFound, "not_found", fmt.Sprintf("Cluster %v has not been provisioned by the control plane, so there are no logs", cs.Name), nil)
+	}
+	logevents, next, err := fetchLogs(cs.ProvisioningTask, request.QueryStringParameters["next"])
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	page := eksp.LogsPage{
+		ClusterID: cs.ID,
+		Status:    cs.ProvisioningStatus,
+		Error:     cs.ProvisioningError,
+		Events:    logevents,
+		Next:      next,
+		Done:      cs.ProvisioningStatus == eksp.TaskStopped || cs.Phase != "",
+	}
+	fmt.Printf("DEBUG:: logs done, found %v lines\n", len(logevents))
+	return eksp.Respond(http.StatusOK, page)
+}
+
+func main() {
+	lambda.Start(eksp.Authenticated(handler))
+}
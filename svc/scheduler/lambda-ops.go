@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	awslambda "github.com/aws/aws-sdk-go-v2/service/lambda"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// schedulerActor is who we record in the audit log for clusters
+// created as per a schedule or to refill a warm pool
+const schedulerActor = "eksphemeral/scheduler"
+
+// createCluster creates a cluster from the cluster spec by invoking
+// the createcluster function the same way the API does, so that the
+// cluster is validated, provisioned, and audited as usual. The
+// idempotency key makes sure we create the cluster only once.
+func createCluster(spec []byte, ikey string) (eksp.ClusterSpec, error) {
+	cs := eksp.ClusterSpec{}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return cs, err
+	}
+	request := events.APIGatewayProxyRequest{
+		HTTPMethod: http.MethodPost,
+		Path:       "/create",
+		Headers: map[string]string{
+			"Idempotency-Key": ikey,
+		},
+		Body: string(spec),
+	}
+	payload, err := json.Marshal(eksp.Invocation{
+		APIGatewayProxyRequest: request,
+		Internal:               schedulerActor,
+	})
+	if err != nil {
+		return cs, err
+	}
+	svc := awslambda.New(cfg)
+	req := svc.InvokeRequest(&awslambda.InvokeInput{
+		FunctionName: aws.String(os.Getenv("CREATE_CLUSTER_FUNCTION")),
+		Payload:      payload,
+	})
+	resp, err := req.Send(context.TODO())
+	if err != nil {
+		return cs, err
+	}
+	if resp.FunctionError != nil {
+		return cs, fmt.Errorf("Can't create cluster: %v %s", aws.StringValue(resp.FunctionError), resp.Payload)
+	}
+	res := events.APIGatewayProxyResponse{}
+	err = json.Unmarshal(resp.Payload, &res)
+	if err != nil {
+		return cs, err
+	}
+	err = eksp.ParseResponse(res.Body, &cs)
+	if err != nil {
+		return cs, fmt.Errorf("Can't create cluster: %v", err)
+	}
+	return cs, nil
+}
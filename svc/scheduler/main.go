@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// onBehalfOf returns a request as if who added the schedule made it. We
+// only know their configured teams, not the ones as per their ID token.
+func onBehalfOf(sched eksp.CreationSchedule) events.APIGatewayProxyRequest {
+	request := events.APIGatewayProxyRequest{}
+	user := sched.CreatedBy
+	if strings.HasPrefix(user, "anonymous") {
+		user = ""
+	}
+	request.RequestContext.Authorizer = map[string]interface{}{"user": user}
+	return request
+}
+
+// mayCreate returns why who added the schedule may no longer have
+// clusters created from it, if they may not. The create requests of the
+// scheduler aren't subject to access control and policies, so we check
+// them here, as admins, teams, and policies may have changed since.
+func mayCreate(sched eksp.CreationSchedule, ac *eksp.AccessConfig, pol *eksp.Policies) error {
+	request, cs := onBehalfOf(sched), sched.Spec
+	if ac != nil && cs.Team != "" && !eksp.KnownTeam(request, ac, cs.Team) {
+		return fmt.Errorf("There is no team %v", cs.Team)
+	}
+	if reason := eksp.OwnershipError(request, ac, cs.Owner, cs.Team); reason != "" {
+		return fmt.Errorf("%v", reason)
+	}
+	if denials := eksp.EvaluatePolicies(request, ac, pol, "create", eksp.AttributesOf(cs, 0)); len(denials) > 0 {
+		reasons := []string{}
+		for _, d := range denials {
+			reasons = append(reasons, d.Reason)
+		}
+		return fmt.Errorf("Denied by policy: %v", strings.Join(reasons, "; "))
+	}
+	if msg := eksp.TimeoutCapError(request, ac, pol, cs.Timeout); msg != "" {
+		return fmt.Errorf("The timeout %v", msg)
+	}
+	return nil
+}
+
+func handler() error {
+	fmt.Printf("DEBUG:: scheduler start\n")
+	clusterbucket := os.Getenv("CLUSTER_METADATA_BUCKET")
+	scheds, err := eksp.ListSchedules(clusterbucket)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	ac, err := eksp.FetchAccessConfig(clusterbucket)
+	if err != nil {
+		return err
+	}
+	pol, err := eksp.FetchPolicies(clusterbucket)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, sched := range scheds {
+		nr, err := strconv.ParseInt(sched.NextRun, 10, 64)
+		if err != nil || now.Before(time.Unix(nr, 0)) {
+			continue
+		}
+		fmt.Printf("Creating cluster %v as per schedule %v\n", sched.Spec.Name, sched.ID)
+		spec, err := json.Marshal(sched.Spec)
+		if err != nil {
+			return err
+		}
+		cs := eksp.ClusterSpec{}
+		err = mayCreate(sched, ac, pol)
+		if err == nil {
+			cs, err = createCluster(spec, fmt.Sprintf("schedule/%v/%v", sched.ID, sched.NextRun))
+		}
+		sched.LastRun = fmt.Sprintf("%v", now.Unix())
+		sched.LastClusterID, sched.LastError = cs.ID, ""
+		if err != nil {
+			fmt.Printf("Can't create cluster %v as per schedule %v: %v\n", sched.Spec.Name, sched.ID, err)
+			sched.LastError = err.Error()
+		}
+		// one-off schedules are done now, recurring ones are due again:
+		sched.NextRun = ""
+		if sched.Days != "" {
+			next, err := eksp.NextRun(sched.Days, sched.Time, sched.TimeZone, now)
+			if err != nil {
+				fmt.Printf("Can't determine the next run of schedule %v: %v\n", sched.ID, err)
+			} else {
+				sched.NextRun = fmt.Sprintf("%v", next.Unix())
+			}
+		}
+		err = eksp.StoreSchedule(clusterbucket, sched)
+		if err != nil {
+			return err
+		}
+	}
+	err = refillWarmPools(clusterbucket)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("DEBUG:: scheduler done\n")
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+func TestMayCreate(t *testing.T) {
+	ac := &eksp.AccessConfig{
+		Admins: []string{"admin@example.com"},
+		Teams:  []eksp.Team{{Name: "payments", Members: []string{"jane@example.com"}}},
+	}
+	max := 2
+	pol := &eksp.Policies{
+		Rules: []eksp.PolicyRule{{
+			Name:   "max-workers",
+			When:   map[string]eksp.Condition{"numworkers": {GreaterThan: &max}},
+			Reason: "At most 2 workers",
+		}},
+	}
+	schedule := func(createdby, owner, team string, numworkers int) eksp.CreationSchedule {
+		return eksp.CreationSchedule{
+			ID:        "5b1f3c2e",
+			CreatedBy: createdby,
+			Spec:      eksp.ClusterSpec{Name: "mh9-eksp", NumWorkers: numworkers, Timeout: 60, Owner: owner, Team: team},
+		}
+	}
+	tests := []struct {
+		name   string
+		sched  eksp.CreationSchedule
+		ac     *eksp.AccessConfig
+		reason string
+	}{
+		{"owner", schedule("jane@example.com", "jane@example.com", "payments", 1), ac, ""},
+		{"without access control", schedule("anonymous@1.2.3.4", "jane@example.com", "", 1), nil, ""},
+		{"admin on behalf of someone else", schedule("admin@example.com", "joe@example.com", "", 1), ac, ""},
+		{"no longer in the team", schedule("joe@example.com", "joe@example.com", "payments", 1), ac, "not a member of the team payments"},
+		{"team removed", schedule("jane@example.com", "jane@example.com", "checkout", 1), ac, "no team checkout"},
+		{"anonymous", schedule("anonymous@1.2.3.4", "jane@example.com", "", 1), ac, "requires the control plane to authenticate callers"},
+		{"denied by policy", schedule("jane@example.com", "jane@example.com", "", 3), ac, "At most 2 workers"},
+	}
+	for _, tt := range tests {
+		err := mayCreate(tt.sched, tt.ac, pol)
+		switch {
+		case tt.reason == "" && err != nil:
+			t.Errorf("%v: mayCreate() = %v, want no error", tt.name, err)
+		case tt.reason != "" && (err == nil || !strings.Contains(err.Error(), tt.reason)):
+			t.Errorf("%v: mayCreate() = %v, want it to say %q", tt.name, err, tt.reason)
+		}
+	}
+}
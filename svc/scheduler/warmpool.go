@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+const (
+	// warmPoolStatusPrefix is the prefix in the metadata bucket under
+	// which we keep track of how refilling each warm pool goes
+	warmPoolStatusPrefix = "warmpools/"
+	// maxRefillBackoff is the longest we wait before trying
+	// to refill a warm pool whose clusters keep failing
+	maxRefillBackoff = 60 * time.Minute
+)
+
+// warmPoolStatus is how refilling a warm pool goes, so that we back off
+// rather than creating cluster after cluster if provisioning them fails
+type warmPoolStatus struct {
+	// Failures is the number of consecutive failures to refill the pool
+	Failures int `json:"failures"`
+	// LastError is why refilling the pool failed last
+	LastError string `json:"lasterror,omitempty"`
+	// RetryAfter is when we try to refill the pool again, in Unix time
+	RetryAfter int64 `json:"retryafter,omitempty"`
+	// Failed are the IDs of the pool's clusters we've
+	// seen failing to provision, so we count them only once
+	Failed []string `json:"failed,omitempty"`
+}
+
+// failed records a failure to refill the pool, doubling
+// the time we wait before trying again with each one
+func (st *warmPoolStatus) failed(reason string, now time.Time) {
+	st.Failures++
+	st.LastError = reason
+	backoff := maxRefillBackoff
+	if st.Failures < 7 {
+		backoff = time.Duration(1<<uint(st.Failures-1)) * time.Minute
+	}
+	if backoff > maxRefillBackoff {
+		backoff = maxRefillBackoff
+	}
+	st.RetryAfter = now.Add(backoff).Unix()
+}
+
+// seen returns true if we've counted the failed cluster already
+func (st warmPoolStatus) seen(clusterID string) bool {
+	for _, id := range st.Failed {
+		if id == clusterID {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchWarmPoolStatus returns how refilling the warm pool goes
+func fetchWarmPoolStatus(clusterbucket, pool string) (warmPoolStatus, error) {
+	st := warmPoolStatus{}
+	content, _, err := eksp.FetchObject(clusterbucket, warmPoolStatusPrefix+pool+".json")
+	if err != nil {
+		if eksp.IsNoSuchKey(err) {
+			return st, nil
+		}
+		return st, err
+	}
+	err = json.Unmarshal(content, &st)
+	return st, err
+}
+
+// storeWarmPoolStatus stores how refilling the warm pool goes
+func storeWarmPoolStatus(clusterbucket, pool string, st warmPoolStatus) error {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	stjson, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	uploader := s3manager.NewUploader(cfg)
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(warmPoolStatusPrefix + pool + ".json"),
+		Body:   strings.NewReader(string(stjson)),
+	})
+	return err
+}
+
+// warmClusterName returns a fresh name for a cluster in the pool
+func warmClusterName(pool string) string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return pool + "-" + hex.EncodeToString(b)
+}
+
+// refillWarmPools creates as many clusters as each warm pool lacks, counting
+// the clusters still being provisioned, so that once a cluster has been
+// claimed, another one is on its way. If creating or provisioning the
+// clusters of a pool fails, we back off refilling it, and once one of
+// its clusters is ready to be claimed, we're back to normal.
+func refillWarmPools(clusterbucket string) error {
+	pools, err := eksp.FetchWarmPools(clusterbucket)
+	if err != nil {
+		return err
+	}
+	if len(pools) == 0 {
+		return nil
+	}
+	specs, err := eksp.ListClusterSpecs(clusterbucket)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, pool := range pools {
+		st, err := fetchWarmPoolStatus(clusterbucket, pool.Name)
+		if err != nil {
+			return err
+		}
+		idle, ok := 0, false
+		failed, reasons := []string{}, []string{}
+		for _, cs := range specs {
+			if cs.WarmPool != pool.Name {
+				continue
+			}
+			switch {
+			case cs.ProvisioningError != "":
+				failed = append(failed, cs.ID)
+				if !st.seen(cs.ID) {
+					reasons = append(reasons, fmt.Sprintf("cluster %v failed to provision: %v", cs.ID, cs.ProvisioningError))
+				}
+			case cs.TeardownReason == "" && cs.ActivationTime != "" && cs.ProvisioningStatus == eksp.TaskStopped:
+				idle++
+				ok = true
+			case cs.TeardownReason == "":
+				idle++
+			}
+		}
+		if ok {
+			st.Failures, st.LastError, st.RetryAfter = 0, "", 0
+		}
+		for _, reason := range reasons {
+			st.failed(reason, now)
+		}
+		if len(reasons) > 0 {
+			fmt.Printf("Provisioning clusters of warm pool %v fails, backing off until %v\n", pool.Name, time.Unix(st.RetryAfter, 0))
+		}
+		// failed clusters are torn down, no need to remember them after that:
+		st.Failed = failed
+		for ; idle < pool.Size && now.Unix() >= st.RetryAfter; idle++ {
+			spec := map[string]interface{}{}
+			if len(pool.Spec) > 0 {
+				err = json.Unmarshal(pool.Spec, &spec)
+				if err != nil {
+					return fmt.Errorf("Can't parse the cluster spec of warm pool %v: %v", pool.Name, err)
+				}
+			}
+			spec["name"] = warmClusterName(pool.Name)
+			spec["warmpool"] = pool.Name
+			body, err := json.Marshal(spec)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Creating cluster %v to refill warm pool %v\n", spec["name"], pool.Name)
+			cs, err := createCluster(body, fmt.Sprintf("warmpool/%v/%v", pool.Name, spec["name"]))
+			if err != nil {
+				// most likely the pool's cluster spec is invalid,
+				// no point in trying again for a while:
+				fmt.Printf("Can't refill warm pool %v: %v\n", pool.Name, err)
+				st.failed(err.Error(), now)
+				break
+			}
+			fmt.Printf("DEBUG:: created cluster %v for warm pool %v\n", cs.ID, pool.Name)
+		}
+		err = storeWarmPoolStatus(clusterbucket, pool.Name, st)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+func TestWarmClusterName(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		name := warmClusterName("ci")
+		if !strings.HasPrefix(name, "ci-") || len(name) != len("ci-")+6 {
+			t.Errorf("warmClusterName() = %v, want ci- followed by six hex digits", name)
+		}
+		if !eksp.IsValidClusterName(name) {
+			t.Errorf("warmClusterName() = %v, which isn't a valid cluster name", name)
+		}
+		seen[name] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("warmClusterName() keeps returning %v", seen)
+	}
+}
+
+func TestWarmPoolStatusFailed(t *testing.T) {
+	now := time.Date(2019, time.June, 14, 6, 0, 0, 0, time.UTC)
+	st := warmPoolStatus{}
+	// the backoff doubles with each failure, up to the maximum:
+	for i, want := range []time.Duration{1, 2, 4, 8, 16, 32, 60, 60} {
+		st.failed("eksctl exited with code 1", now)
+		if st.Failures != i+1 {
+			t.Errorf("after %d failures, counted %d", i+1, st.Failures)
+		}
+		if backoff := time.Unix(st.RetryAfter, 0).Sub(now); backoff != want*time.Minute {
+			t.Errorf("after %d failures, backing off for %v, want %v", i+1, backoff, want*time.Minute)
+		}
+	}
+	if st.LastError != "eksctl exited with code 1" {
+		t.Errorf("last error is %q", st.LastError)
+	}
+}
+
+func TestWarmPoolStatusSeen(t *testing.T) {
+	st := warmPoolStatus{Failed: []string{"e-abc123", "e-def456"}}
+	if !st.seen("e-def456") {
+		t.Error("failed cluster e-def456 not seen")
+	}
+	if st.seen("e-123456") {
+		t.Error("cluster e-123456 seen, but it didn't fail")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// defaultSpec returns the cluster spec with the defaults the
+// control plane applies when creating a cluster filled in
+func defaultSpec(raw json.RawMessage) (eksp.ClusterSpec, error) {
+	cs := eksp.ClusterSpec{
+		Name:        "unknown",
+		NumWorkers:  1,
+		KubeVersion: "1.12",
+		Timeout:     10,
+		TTL:         10,
+		Owner:       eksp.DefaultOwner,
+	}
+	err := json.Unmarshal(raw, &cs)
+	return cs, err
+}
+
+// validateTiming checks when the schedule creates clusters and returns
+// the first point in time it does, either once or recurring
+func validateTiming(sched eksp.CreationSchedule, now time.Time) (time.Time, []eksp.FieldError) {
+	switch {
+	case sched.At != "" && (sched.Days != "" || sched.Time != ""):
+		return time.Time{}, []eksp.FieldError{{Field: "at", Message: "must not be used together with days and time"}}
+	case sched.At != "":
+		at, err := time.Parse(time.RFC3339, sched.At)
+		if err != nil {
+			return at, []eksp.FieldError{{Field: "at", Message: "must be an RFC 3339 timestamp such as 2019-07-08T07:30:00Z"}}
+		}
+		if !at.After(now) {
+			return at, []eksp.FieldError{{Field: "at", Message: "must be in the future"}}
+		}
+		return at, nil
+	case sched.Days != "" && sched.Time != "":
+		next, err := eksp.NextRun(sched.Days, sched.Time, sched.TimeZone, now)
+		if err != nil {
+			field := strings.SplitN(err.Error(), ":", 2)[0]
+			return next, []eksp.FieldError{{Field: field, Message: err.Error()}}
+		}
+		return next, nil
+	default:
+		return time.Time{}, []eksp.FieldError{{Field: "at", Message: "either at or both days and time must be given"}}
+	}
+}
+
+// addSchedule validates and stores a new creation schedule
+func addSchedule(clusterbucket string, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	sched := eksp.CreationSchedule{}
+	var raw struct {
+		Spec json.RawMessage `json:"spec"`
+	}
+	err := json.Unmarshal([]byte(request.Body), &sched)
+	if err == nil {
+		err = json.Unmarshal([]byte(request.Body), &raw)
+	}
+	if err != nil {
+		return eksp.BadRequest(fmt.Sprintf("The schedule is not valid JSON: %v", err))
+	}
+	if len(raw.Spec) == 0 {
+		return eksp.BadRequest("The schedule lacks the cluster spec to create clusters from.")
+	}
+	// validate the cluster spec the way the create request will:
+	cs, err := defaultSpec(raw.Spec)
+	if err != nil {
+		return eksp.BadRequest(fmt.Sprintf("The cluster spec is not valid JSON: %v", err))
+	}
+	// callers who identify themselves own the clusters, unless they say otherwise:
+	if caller := eksp.CallerOf(request); caller != "" && cs.Owner == eksp.DefaultOwner {
+		cs.Owner = caller
+	}
+	if ferrs := eksp.ValidateClusterSpec(cs); len(ferrs) > 0 {
+		return eksp.ValidationError(ferrs)
+	}
+	ac, err := eksp.FetchAccessConfig(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if ac != nil && cs.Team != "" && !eksp.KnownTeam(request, ac, cs.Team) {
+		return eksp.ValidationError([]eksp.FieldError{{Field: "team", Message: fmt.Sprintf("there is no team %v", cs.Team)}})
+	}
+	if reason := eksp.OwnershipError(request, ac, cs.Owner, cs.Team); reason != "" {
+		return eksp.Forbidden(reason)
+	}
+	// the scheduler isn't subject to policies, so the caller is, up front:
+	pol, err := eksp.FetchPolicies(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if denials := eksp.EvaluatePolicies(request, ac, pol, "create", eksp.AttributesOf(cs, 0)); len(denials) > 0 {
+		return eksp.PolicyDenied(denials)
+	}
+	if msg := eksp.TimeoutCapError(request, ac, pol, cs.Timeout); msg != "" {
+		return eksp.ValidationError([]eksp.FieldError{{Field: "timeout", Message: msg}})
+	}
+	now := time.Now()
+	next, ferrs := validateTiming(sched, now)
+	if len(ferrs) > 0 {
+		return eksp.RespondError(http.StatusBadRequest, "validation_failed", "The schedule is invalid", ferrs)
+	}
+	schedID, err := uuid.NewV4()
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	sched.ID = schedID.String()
+	sched.Spec = cs
+	sched.CreatedBy = eksp.ActorOf(request)
+	sched.CreationTime = fmt.Sprintf("%v", now.Unix())
+	sched.NextRun = fmt.Sprintf("%v", next.Unix())
+	sched.LastRun, sched.LastClusterID, sched.LastError = "", "", ""
+	err = eksp.StoreSchedule(clusterbucket, sched)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	fmt.Printf("DEBUG:: added schedule %v, creating cluster %v next at %v\n", sched.ID, cs.Name, next)
+	return eksp.Respond(http.StatusOK, sched)
+}
+
+// removeSchedule deletes the creation schedule with the ID or unique
+// ID prefix provided, if the caller created it or is an admin
+func removeSchedule(clusterbucket, sref string, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	scheds, err := eksp.ListSchedules(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	matches := []eksp.CreationSchedule{}
+	for _, sched := range scheds {
+		if strings.HasPrefix(sched.ID, sref) {
+			matches = append(matches, sched)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return eksp.RespondError(http.StatusNotFound, "not_found", fmt.Sprintf("There is no schedule with the ID or ID prefix %v", sref), nil)
+	case 1:
+		ac, err := eksp.FetchAccessConfig(clusterbucket)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+		if ac != nil && !eksp.IsInternal(request) {
+			caller := eksp.CallerOf(request)
+			if !eksp.MatchesUser(caller, matches[0].CreatedBy) && !eksp.CallerIsAdmin(request, ac) {
+				return eksp.Forbidden(fmt.Sprintf("Only %v, who created schedule %v, and admins may remove it.", matches[0].CreatedBy, matches[0].ID))
+			}
+		}
+		err = rmSchedule(clusterbucket, matches[0].ID)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+		fmt.Printf("DEBUG:: removed schedule %v\n", matches[0].ID)
+		return eksp.Respond(http.StatusOK, matches[0])
+	default:
+		candidates := []string{}
+		for _, sched := range matches {
+			candidates = append(candidates, sched.ID)
+		}
+		return eksp.RespondError(http.StatusBadRequest, "ambiguous_reference", fmt.Sprintf("The ID prefix %v matches more than one schedule", sref), candidates)
+	}
+}
+
+func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	clusterbucket := os.Getenv("CLUSTER_METADATA_BUCKET")
+	fmt.Printf("DEBUG:: schedules %v start\n", request.HTTPMethod)
+	switch request.HTTPMethod {
+	case http.MethodPost:
+		return addSchedule(clusterbucket, request)
+	case http.MethodDelete:
+		sref, ok := request.PathParameters["scheduleid"]
+		if !ok || sref == "" {
+			return eksp.BadRequest("Unknown schedule delete request, please specify a valid schedule ID.")
+		}
+		return removeSchedule(clusterbucket, sref, request)
+	default:
+		scheds, err := eksp.ListSchedules(clusterbucket)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+		return eksp.Respond(http.StatusOK, scheds)
+	}
+}
+
+func main() {
+	lambda.Start(eksp.Authenticated(handler))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+func TestValidateTiming(t *testing.T) {
+	now := time.Date(2019, time.June, 14, 6, 0, 0, 0, time.UTC)
+
+	first, ferrs := validateTiming(eksp.CreationSchedule{At: "2019-06-14T08:30:00+02:00"}, now)
+	if len(ferrs) != 0 {
+		t.Fatalf("validateTiming() of a one-off schedule failed: %v", ferrs)
+	}
+	if want := now.Add(30 * time.Minute); !first.Equal(want) {
+		t.Errorf("one-off schedule first runs at %v, want %v", first, want)
+	}
+
+	first, ferrs = validateTiming(eksp.CreationSchedule{Days: "Mon-Fri", Time: "07:30", TimeZone: "Europe/Berlin"}, now)
+	if len(ferrs) != 0 {
+		t.Fatalf("validateTiming() of a recurring schedule failed: %v", ferrs)
+	}
+	if want := time.Date(2019, time.June, 17, 5, 30, 0, 0, time.UTC); !first.Equal(want) {
+		t.Errorf("recurring schedule first runs at %v, want %v", first, want)
+	}
+
+	for field, sched := range map[string]eksp.CreationSchedule{
+		"at":       {At: "2019-06-14T05:00:00Z"},
+		"days":     {Days: "weekdays", Time: "07:30"},
+		"time":     {Days: "Mon-Fri", Time: "7:30am"},
+		"timezone": {Days: "Mon-Fri", Time: "07:30", TimeZone: "CEST"},
+	} {
+		_, ferrs := validateTiming(sched, now)
+		if len(ferrs) != 1 || ferrs[0].Field != field {
+			t.Errorf("validateTiming(%+v) = %v, want an issue with %v", sched, ferrs, field)
+		}
+	}
+	// neither or both kinds of timing:
+	for _, sched := range []eksp.CreationSchedule{
+		{},
+		{Days: "Mon-Fri"},
+		{At: "2019-06-14T08:00:00Z", Days: "Mon-Fri", Time: "07:30"},
+	} {
+		if _, ferrs := validateTiming(sched, now); len(ferrs) == 0 {
+			t.Errorf("validateTiming(%+v) succeeded, want an issue", sched)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// rmSchedule deletes the creation schedule from the given bucket
+func rmSchedule(clusterbucket, schedID string) error {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	svc := s3.New(cfg)
+	req := svc.DeleteObjectRequest(&s3.DeleteObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(eksp.SchedulesPrefix + schedID + ".json"),
+	})
+	_, err = req.Send(context.TODO())
+	return err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// selectorOps are the operators of a label selector
+// requirement, in the order we look for them
+var selectorOps = []string{"!=", "==", "=", " notin ", " in "}
+
+// requirement is a single condition of a label selector,
+// such as team=payments or purpose!=demo
+type requirement struct {
+	key    string
+	op     string
+	values []string
+}
+
+// splitSelector splits a label selector into its requirements,
+// at the commas that don't separate the values of a set
+func splitSelector(selector string) []string {
+	parts := []string{}
+	depth, start := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, selector[start:])
+}
+
+// parseSelector parses a Kubernetes-style label selector such as
+// team=payments,purpose!=demo,env in (dev,test),!temporary
+func parseSelector(selector string) ([]requirement, error) {
+	reqs := []requirement{}
+	if strings.TrimSpace(selector) == "" {
+		return reqs, nil
+	}
+	for _, part := range splitSelector(selector) {
+		part = strings.TrimSpace(part)
+		r := requirement{}
+		for _, op := range selectorOps {
+			i := strings.Index(part, op)
+			if i < 0 {
+				continue
+			}
+			r.key = strings.TrimSpace(part[:i])
+			r.op = strings.TrimSpace(op)
+			value := strings.TrimSpace(part[i+len(op):])
+			if r.op == "in" || r.op == "notin" {
+				if !strings.HasPrefix(value, "(") || !strings.HasSuffix(value, ")") {
+					return reqs, fmt.Errorf("the values of %q must be of the form (a,b)", part)
+				}
+				for _, v := range strings.Split(value[1:len(value)-1], ",") {
+					r.values = append(r.values, strings.TrimSpace(v))
+				}
+			} else {
+				r.values = []string{value}
+			}
+			break
+		}
+		if r.op == "" {
+			// a bare key or !key asks if there's a label with the key:
+			r.op = "exists"
+			r.key = part
+			if strings.HasPrefix(part, "!") {
+				r.op = "!exists"
+				r.key = strings.TrimSpace(part[1:])
+			}
+		}
+		if r.key == "" || strings.ContainsAny(r.key, "=!() ") {
+			return reqs, fmt.Errorf("%q is not a valid requirement", part)
+		}
+		reqs = append(reqs, r)
+	}
+	return reqs, nil
+}
+
+// matches returns true if the labels meet the requirement
+func (r requirement) matches(labels map[string]string) bool {
+	value, ok := labels[r.key]
+	switch r.op {
+	case "exists":
+		return ok
+	case "!exists":
+		return !ok
+	case "=", "==", "in":
+		return ok && eksp.Contains(r.values, value)
+	default: // != and notin also match clusters without the label
+		return !ok || !eksp.Contains(r.values, value)
+	}
+}
+
+// selects returns true if the labels meet all requirements
+func selects(reqs []requirement, labels map[string]string) bool {
+	for _, r := range reqs {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
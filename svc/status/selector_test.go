@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		reqs     []requirement
+		invalid  bool
+	}{
+		{"", []requirement{}, false},
+		{"  ", []requirement{}, false},
+		{"team=payments", []requirement{{"team", "=", []string{"payments"}}}, false},
+		{"team==payments", []requirement{{"team", "==", []string{"payments"}}}, false},
+		{"team != payments", []requirement{{"team", "!=", []string{"payments"}}}, false},
+		{"env in (dev, test)", []requirement{{"env", "in", []string{"dev", "test"}}}, false},
+		{"env notin (prod)", []requirement{{"env", "notin", []string{"prod"}}}, false},
+		{"temporary", []requirement{{"temporary", "exists", nil}}, false},
+		{"!temporary", []requirement{{"temporary", "!exists", nil}}, false},
+		{"example.com/team=payments", []requirement{{"example.com/team", "=", []string{"payments"}}}, false},
+		{"team=payments,env in (dev,test),!temporary", []requirement{
+			{"team", "=", []string{"payments"}},
+			{"env", "in", []string{"dev", "test"}},
+			{"temporary", "!exists", nil},
+		}, false},
+		{"team=", []requirement{{"team", "=", []string{""}}}, false},
+		{"=payments", nil, true},
+		{"env in dev", nil, true},
+		{"env in (dev", nil, true},
+		{"team payments", nil, true},
+		{"team=payments,", nil, true},
+		{"!", nil, true},
+	}
+	for _, tt := range tests {
+		reqs, err := parseSelector(tt.selector)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("parseSelector(%q) = %v, want an error", tt.selector, reqs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSelector(%q) failed: %v", tt.selector, err)
+			continue
+		}
+		if !reflect.DeepEqual(reqs, tt.reqs) {
+			t.Errorf("parseSelector(%q) = %v, want %v", tt.selector, reqs, tt.reqs)
+		}
+	}
+}
+
+func TestSelects(t *testing.T) {
+	labels := map[string]string{"team": "payments", "env": "dev"}
+	tests := []struct {
+		selector string
+		selected bool
+	}{
+		{"", true},
+		{"team=payments", true},
+		{"team=checkout", false},
+		{"team!=checkout", true},
+		{"owner!=jane", true},
+		{"env in (dev,test)", true},
+		{"env in (prod)", false},
+		{"env notin (prod)", true},
+		{"env notin (dev)", false},
+		{"region notin (us-east-1)", true},
+		{"team", true},
+		{"temporary", false},
+		{"!temporary", true},
+		{"!team", false},
+		{"team=payments,env=dev", true},
+		{"team=payments,env=prod", false},
+	}
+	for _, tt := range tests {
+		reqs, err := parseSelector(tt.selector)
+		if err != nil {
+			t.Errorf("parseSelector(%q) failed: %v", tt.selector, err)
+			continue
+		}
+		if got := selects(reqs, labels); got != tt.selected {
+			t.Errorf("selects(%q, %v) = %v, want %v", tt.selector, labels, got, tt.selected)
+		}
+	}
+}
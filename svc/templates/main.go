@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// validateTemplate checks the template the way the create request
+// will check clusters created from it, with the defaults applied
+func validateTemplate(t eksp.Template) []eksp.FieldError {
+	ferrs := []eksp.FieldError{}
+	if len(t.Spec) == 0 {
+		return append(ferrs, eksp.FieldError{Field: "spec", Message: "must be given"})
+	}
+	cs := eksp.ClusterSpec{
+		Name:        "unknown",
+		NumWorkers:  1,
+		KubeVersion: "1.12",
+		Timeout:     10,
+		TTL:         10,
+		Owner:       eksp.DefaultOwner,
+	}
+	err := json.Unmarshal(t.Spec, &cs)
+	if err != nil {
+		return append(ferrs, eksp.FieldError{Field: "spec", Message: fmt.Sprintf("is not a valid cluster spec: %v", err)})
+	}
+	if cs.Template != "" {
+		ferrs = append(ferrs, eksp.FieldError{Field: "spec.template", Message: "must not refer to another template"})
+	}
+	for _, ferr := range eksp.ValidateClusterSpec(cs) {
+		ferrs = append(ferrs, eksp.FieldError{Field: "spec." + ferr.Field, Message: ferr.Message})
+	}
+	for i, o := range t.Owners {
+		if !strings.HasPrefix(o, "@") && !eksp.IsValidEmail(o) {
+			ferrs = append(ferrs, eksp.FieldError{Field: fmt.Sprintf("owners[%d]", i), Message: "must be an email address or a domain such as @example.com"})
+		}
+	}
+	return ferrs
+}
+
+// applyTemplate stores the template under the name provided,
+// replacing the template of that name if there is one
+func applyTemplate(clusterbucket, name string, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !eksp.IsValidClusterName(name) {
+		return eksp.ValidationError([]eksp.FieldError{{Field: "name", Message: "must start with a lower case letter and only contain lower case letters, digits, and hyphens"}})
+	}
+	t := eksp.Template{}
+	err := json.Unmarshal([]byte(request.Body), &t)
+	if err != nil {
+		return eksp.BadRequest(fmt.Sprintf("The template is not valid JSON: %v", err))
+	}
+	if t.Name != "" && t.Name != name {
+		return eksp.ValidationError([]eksp.FieldError{{Field: "name", Message: fmt.Sprintf("must match the name in the path, %v", name)}})
+	}
+	t.Name = name
+	if ferrs := validateTemplate(t); len(ferrs) > 0 {
+		return eksp.RespondError(http.StatusBadRequest, "validation_failed", "The template is invalid", ferrs)
+	}
+	t.UpdatedBy = eksp.ActorOf(request)
+	t.UpdateTime = fmt.Sprintf("%v", time.Now().Unix())
+	err = storeTemplate(clusterbucket, t)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	fmt.Printf("DEBUG:: stored template %v\n", t.Name)
+	return eksp.Respond(http.StatusOK, t)
+}
+
+func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	clusterbucket := os.Getenv("CLUSTER_METADATA_BUCKET")
+	fmt.Printf("DEBUG:: templates %v start\n", request.HTTPMethod)
+	name := request.PathParameters["name"]
+	if name == "" {
+		templates, err := listTemplates(clusterbucket)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+		return eksp.Respond(http.StatusOK, templates)
+	}
+	// only admins may change templates:
+	if request.HTTPMethod == http.MethodPut || request.HTTPMethod == http.MethodDelete {
+		ac, err := eksp.FetchAccessConfig(clusterbucket)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+		if ac != nil && !eksp.IsInternal(request) && !eksp.CallerIsAdmin(request, ac) {
+			return eksp.Forbidden(fmt.Sprintf("Only admins may change templates, and you (%v) are not one of them.", eksp.ActorOf(request)))
+		}
+	}
+	if request.HTTPMethod == http.MethodPut {
+		return applyTemplate(clusterbucket, name, request)
+	}
+	t, err := eksp.FetchTemplate(clusterbucket, name)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if t == nil {
+		return eksp.RespondError(http.StatusNotFound, "not_found", fmt.Sprintf("There is no template %v", name), nil)
+	}
+	if request.HTTPMethod == http.MethodDelete {
+		err = rmTemplate(clusterbucket, name)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+		fmt.Printf("DEBUG:: removed template %v\n", name)
+	}
+	return eksp.Respond(http.StatusOK, t)
+}
+
+func main() {
+	lambda.Start(eksp.Authenticated(handler))
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		spec   string
+		owners []string
+		fields []string
+	}{
+		{`{"numworkers": 2, "kubeversion": "1.13"}`, nil, []string{}},
+		{`{}`, []string{"@example.com", "jane@example.com"}, []string{}},
+		{``, nil, []string{"spec"}},
+		{`{"numworkers": "two"}`, nil, []string{"spec"}},
+		{`{"template": "ci-small"}`, nil, []string{"spec.template"}},
+		{`{"numworkers": 0, "kubeversion": "0.1"}`, nil, []string{"spec.numworkers", "spec.kubeversion"}},
+		{`{}`, []string{"example.com", "@example.com"}, []string{"owners[0]"}},
+	}
+	for _, tt := range tests {
+		tmpl := eksp.Template{Name: "ci-small", Spec: json.RawMessage(tt.spec), Owners: tt.owners}
+		fields := []string{}
+		for _, ferr := range validateTemplate(tmpl) {
+			fields = append(fields, ferr.Field)
+		}
+		if !reflect.DeepEqual(fields, tt.fields) {
+			t.Errorf("validateTemplate() of spec %v and owners %v has issues with %v, want %v", tt.spec, tt.owners, fields, tt.fields)
+		}
+	}
+}
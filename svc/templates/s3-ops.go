@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// listTemplates returns all templates in the given bucket
+func listTemplates(clusterbucket string) ([]eksp.Template, error) {
+	templates := []eksp.Template{}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return templates, err
+	}
+	svc := s3.New(cfg)
+	req := svc.ListObjectsRequest(&s3.ListObjectsInput{
+		Bucket: aws.String(clusterbucket),
+		Prefix: aws.String(eksp.TemplatesPrefix),
+	})
+	resp, err := req.Send(context.TODO())
+	if err != nil {
+		return templates, err
+	}
+	for _, obj := range resp.Contents {
+		name := strings.TrimSuffix(strings.TrimPrefix(*obj.Key, eksp.TemplatesPrefix), ".json")
+		t, err := eksp.FetchTemplate(clusterbucket, name)
+		if err != nil {
+			return templates, err
+		}
+		// the template might have been removed in the meantime:
+		if t != nil {
+			templates = append(templates, *t)
+		}
+	}
+	return templates, nil
+}
+
+// storeTemplate stores the template in the given bucket
+func storeTemplate(clusterbucket string, t eksp.Template) error {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	tjson, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	uploader := s3manager.NewUploader(cfg)
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(eksp.TemplatesPrefix + t.Name + ".json"),
+		Body:   strings.NewReader(string(tjson)),
+	})
+	return err
+}
+
+// rmTemplate deletes the template from the given bucket
+func rmTemplate(clusterbucket, name string) error {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	svc := s3.New(cfg)
+	req := svc.DeleteObjectRequest(&s3.DeleteObjectInput{
+		Bucket: aws.String(clusterbucket),
+		Key:    aws.String(eksp.TemplatesPrefix + name + ".json"),
+	})
+	_, err = req.Send(context.TODO())
+	return err
+}
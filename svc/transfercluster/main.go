@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// TransferRequest is who the cluster is handed over to
+type TransferRequest struct {
+	// Owner is the email address of the new owner,
+	// defaults to keeping the current owner
+	Owner string `json:"owner"`
+	// CoOwners optionally replaces the co-owners,
+	// an empty list removes all of them
+	CoOwners *[]string `json:"coowners"`
+}
+
+func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	clusterbucket := os.Getenv("CLUSTER_METADATA_BUCKET")
+	fmt.Printf("DEBUG:: transfer start\n")
+	// validate cluster ID:
+	if _, ok := request.PathParameters["clusterid"]; !ok {
+		return eksp.BadRequest("Unknown cluster transfer request, please specify a valid cluster ID.")
+	}
+	cref := request.PathParameters["clusterid"]
+	transfer := TransferRequest{}
+	err := json.Unmarshal([]byte(request.Body), &transfer)
+	if err != nil {
+		return eksp.BadRequest(fmt.Sprintf("The transfer is not valid JSON: %v", err))
+	}
+	if transfer.Owner == "" && transfer.CoOwners == nil {
+		return eksp.BadRequest("Invalid transfer request, please specify the new owner or co-owners.")
+	}
+	cID, err := eksp.ResolveClusterID(clusterbucket, cref)
+	if err != nil {
+		return eksp.ClusterError(clusterbucket, err)
+	}
+	cs, err := eksp.FetchClusterSpec(clusterbucket, cID)
+	if err != nil {
+		return eksp.ClusterError(clusterbucket, err)
+	}
+	if cs.Phase == eksp.PhaseTearingDown {
+		return eksp.Conflict(fmt.Sprintf("Cluster %v is already being torn down and can't be transferred", cs.Name))
+	}
+	ac, err := eksp.FetchAccessConfig(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if !eksp.MayManage(request, ac, cs) {
+		return eksp.Forbidden(eksp.NotAllowed(request, "transfer", cs))
+	}
+	before := cs
+	if transfer.Owner != "" {
+		cs.Owner = transfer.Owner
+	}
+	if transfer.CoOwners != nil {
+		cs.CoOwners = *transfer.CoOwners
+	}
+	if ferrs := eksp.ValidateClusterSpec(cs); len(ferrs) > 0 {
+		return eksp.RespondError(http.StatusBadRequest, "validation_failed", "The transfer is invalid", ferrs)
+	}
+	pol, err := eksp.FetchPolicies(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if denials := eksp.EvaluatePolicies(request, ac, pol, "transfer", eksp.AttributesOf(cs, 0)); len(denials) > 0 {
+		return eksp.PolicyDenied(denials)
+	}
+	fmt.Printf("DEBUG:: cluster %v is now owned by %v\n", cs.ID, eksp.OwnersOf(cs))
+	err = eksp.StoreClusterSpec(clusterbucket, cs)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	actor := eksp.ActorOf(request)
+	eksp.AuditSpecChange(clusterbucket, actor, "transfer", before, cs)
+	eksp.EmitWebhook(clusterbucket, "transferred", cs)
+	// let the owners know, noting that the transfer stands even if we can't:
+	subject := fmt.Sprintf("EKS cluster %v has been transferred", cs.Name)
+	body := fmt.Sprintf("Hello there,\n\nThis is to inform you that %v transferred the EKS cluster %v (cluster ID %v), which is now owned by %v. You will get the warnings before it shuts down from now on.\n\nHave a nice day,\nEKSphemeral", actor, cs.Name, cs.ID, strings.Join(eksp.OwnersOf(cs), ", "))
+	err = eksp.InformOwners(clusterbucket, actor, cs, subject, body)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+	fmt.Printf("DEBUG:: transfer done\n")
+	return eksp.Respond(http.StatusOK, cs)
+}
+
+func main() {
+	lambda.Start(eksp.Authenticated(handler))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// restoreNodeGroup scales the nodegroup back to
+// the size it had before the cluster was hibernated
+func restoreNodeGroup(hng eksp.HibernatedNodeGroup) error {
+	fmt.Printf("DEBUG:: scaling nodegroup %v back to %v nodes\n", hng.NodeGroup, hng.DesiredCapacity)
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	svc := autoscaling.New(cfg)
+	uasgreq := svc.UpdateAutoScalingGroupRequest(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(hng.AutoScalingGroup),
+		MinSize:              aws.Int64(int64(hng.MinSize)),
+		MaxSize:              aws.Int64(int64(hng.MaxSize)),
+		DesiredCapacity:      aws.Int64(int64(hng.DesiredCapacity)),
+	})
+	_, err = uasgreq.Send(context.TODO())
+	return err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	clusterbucket := os.Getenv("CLUSTER_METADATA_BUCKET")
+	fmt.Printf("DEBUG:: wake start\n")
+	// validate cluster ID:
+	if _, ok := request.PathParameters["clusterid"]; !ok {
+		return eksp.BadRequest("Unknown cluster wake request, please specify a valid cluster ID.")
+	}
+	cref := request.PathParameters["clusterid"]
+	cID, err := eksp.ResolveClusterID(clusterbucket, cref)
+	if err != nil {
+		return eksp.ClusterError(clusterbucket, err)
+	}
+	cs, err := eksp.FetchClusterSpec(clusterbucket, cID)
+	if err != nil {
+		return eksp.ClusterError(clusterbucket, err)
+	}
+	ac, err := eksp.FetchAccessConfig(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if !eksp.MayManage(request, ac, cs) {
+		return eksp.Forbidden(eksp.NotAllowed(request, "wake up", cs))
+	}
+	if cs.Phase == eksp.PhaseTearingDown || cs.TeardownReason != "" {
+		return eksp.Conflict(fmt.Sprintf("Cluster %v is already being torn down and can't be woken up", cs.Name))
+	}
+	if cs.Phase != eksp.PhaseHibernated {
+		return eksp.Conflict(fmt.Sprintf("Cluster %v is not hibernated", cs.Name))
+	}
+	// the timeout may start over, so check the cluster as it'll be once woken up:
+	woken := eksp.WakeUp(cs, time.Now())
+	pol, err := eksp.FetchPolicies(clusterbucket)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	if denials := eksp.EvaluatePolicies(request, ac, pol, "wake", eksp.AttributesOf(woken, 0)); len(denials) > 0 {
+		return eksp.PolicyDenied(denials)
+	}
+	// restore the nodegroups to the sizes they had before hibernation:
+	for _, hng := range cs.HibernatedNodeGroups {
+		err = restoreNodeGroup(hng)
+		if err != nil {
+			return eksp.ServerError(err)
+		}
+	}
+	before := cs
+	cs = woken
+	fmt.Printf("DEBUG:: woke up cluster %v, TTL is %v min\n", cs.ID, cs.TTL)
+	err = eksp.StoreClusterSpec(clusterbucket, cs)
+	if err != nil {
+		return eksp.ServerError(err)
+	}
+	eksp.AuditSpecChange(clusterbucket, eksp.ActorOf(request), "wake", before, cs)
+	eksp.EmitWebhook(clusterbucket, "woken", cs)
+	fmt.Printf("DEBUG:: wake done\n")
+	return eksp.Respond(http.StatusOK, cs)
+}
+
+func main() {
+	lambda.Start(eksp.Authenticated(handler))
+}
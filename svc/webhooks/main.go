@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
+
+// handler delivers a lifecycle event, as emitted by the other functions
+// of the control plane, to the webhook subscribers interested in it.
+// We never fail, since a retried invocation would deliver the event
+// to subscribers again who already got it.
+func handler(ev eksp.WebhookEvent) error {
+	clusterbucket := os.Getenv("CLUSTER_METADATA_BUCKET")
+	fmt.Printf("DEBUG:: webhooks start, %v event for cluster %v\n", ev.Event, ev.Cluster.ID)
+	eksp.DeliverWebhooks(clusterbucket, ev)
+	fmt.Printf("DEBUG:: webhooks done\n")
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -4,13 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
 )
 
 // updateCache updates the cluster spec in the local cache
 func updateCache(csstring string) error {
 	decoder := json.NewDecoder(strings.NewReader(csstring))
-	cs := ClusterSpec{}
-	err := decoder.Decode(&cs)
+	cs := eksp.ClusterSpec{}
+	err := decoder.Decode(&eksp.Envelope{Data: &cs})
 	if err != nil {
 		return err
 	}
@@ -26,7 +28,7 @@ func invalidateCacheEntry(cID string) {
 }
 
 // lookup tries to look up a cluster spec by ID
-func lookup(cID string) (ClusterSpec, error) {
+func lookup(cID string) (eksp.ClusterSpec, error) {
 	cs, ok := cscache[cID]
 	if ok {
 		return cs, nil
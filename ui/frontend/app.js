@@ -9,6 +9,9 @@ var refreshClusterList= 5*60*1000;
 // how fast to refresh cluster details (1* 1000 = every second)
 var refreshClusterDetails = 1*1000;
 
+// the idempotency key of the current create request:
+var createKey = '';
+
 $(document).ready(function($){
   clusters();
 
@@ -32,6 +35,9 @@ $(document).ready(function($){
 
   // when user clicks the create button in the right upper corner:
   $('#create').click(function (event) {
+    // each opening of the dialog is one create request, no matter
+    // how often the user hits the Go! button:
+    createKey = idempotencyKey();
     $('#createdialog').show();
   });
   // when user clicks the Go! button in the dialog command row:
@@ -72,7 +78,8 @@ function createCluster() {
       'numworkers': parseInt(cworkernum, 10),
       'kubeversion': cversion, 
       'timeout': parseInt(ctimeout, 10),
-      'owner': cowner
+      'owner': cowner,
+      'idempotencykey': createKey
     };
     $.ajax({
       type: 'POST',
@@ -81,12 +88,12 @@ function createCluster() {
       async: true,
       error: function (d) {
         console.info(d);
-        $('#status').html('<div>'+ d.responseText + '</div>');
+        $('#status').html('<div>'+ apiError(d) + '</div>');
       },
       success: function (d) {
         if (d != null) {
           console.info(d);
-          $('#status').html('<div>Provisioning cluster with ID '+ d + ' now! This can take up to 15 minutes, will try to notify you via mail.</div>');
+          $('#status').html('<div>Provisioning cluster with ID '+ d.data.id + ' now! This can take up to 15 minutes, will try to notify you via mail.</div>');
         }
       }
     });
@@ -103,17 +110,17 @@ function prolongCluster(cID, prolongTime) {
     $.ajax({
       type: 'POST',
       url: cpURL+'/prolong',
-      // dataType: 'json',
+      dataType: 'json',
       data: JSON.stringify(clusterprolong),
       async: true,
       error: function (d) {
         console.info(d);
-        $('#status').html('<div>'+ d + '</div>');
+        $('#status').html('<div>'+ apiError(d) + '</div>');
       },
       success: function (d) {
         if (d != null) {
           console.info(d);
-          $('#status').html('<div>'+ d + '</div>');
+          $('#status').html('<div>Successfully prolonged the lifetime of cluster '+ d.data.name + ', it now has ' + d.data.ttl + ' min to live</div>');
           $('#clusterdetails').html('');
           clusters();
         }
@@ -137,14 +144,15 @@ function updateClusters(){
         async: true,
         error: function (d) {
           console.info(d.responseText);
-          $('#status').html('<div>'+ d.responseText + '</div>');
+          $('#status').html('<div>'+ apiError(d) + '</div>');
         },
         success: function (d) {
           if (d != null) {
+            d = d.data;
             console.info(d);
             var consoleLink = 'https://console.aws.amazon.com/eks/home?#/clusters/';
             var buffer = '';
-            buffer += d.name;
+            buffer += d.alias ? d.alias + ' (' + d.name + ')' : d.name;
             $('#' + cID + ' .cdlabel a').html(buffer);
             $('#' + cID + ' .cdlabel a').attr('href', consoleLink + d.name);
             $('#' + cID + ' .cdlabel a').attr('title', 'this link takes you to the AWS console where you can view the details of the EKS cluster');
@@ -165,10 +173,11 @@ function clusters(){
     async: true,
     error: function (d) {
       console.info(d.responseText);
-      $('#status').html('<div>'+ d.responseText + '</div>');
+      $('#status').html('<div>'+ apiError(d) + '</div>');
     },
     success: function (d) {
       if (d != null) {
+        d = d.data;
         console.info(d);
         var buffer = '';
         // var consoleURL = "https://console.aws.amazon.com/eks/";
@@ -204,19 +213,70 @@ function clusterdetail(cID) {
     async: true,
     error: function (d) {
       console.info(d);
-      $('#status').html('<div>looking up details for cluster ' + cID + ' failed</div>');
+      $('#status').html('<div>looking up details for cluster ' + cID + ' failed: ' + apiError(d) + '</div>');
     },
     success: function (d) {
       if (d != null) {
+        d = d.data;
         console.info(d);
         var buffer = '';
         buffer += '<div class="cdfield"><span class="cdtitle">Name:</span> ' + d.name + '</div>';
+        if (d.alias) {
+          buffer += '<div class="cdfield"><span class="cdtitle">Claimed as:</span> ' + d.alias + ' at ' + convertTimestamp(d.claimed) + '</div>';
+        }
         buffer += '<div class="cdfield"><span class="cdtitle">Kubernetes version:</span> ' + d.kubeversion + '</div>';
         buffer += '<div class="cdfield"><span class="cdtitle">Number of worker nodes:</span> ' + d.numworkers + '</div>';
+        if (d.nodegroups != null) {
+          var ngbuffer = '';
+          for (let i = 0; i < d.nodegroups.length; i++) {
+            ngbuffer += '<div class="moarfield"><span class="cdtitle">' + d.nodegroups[i].name + ':</span> ' + nodegroupsummary(d.nodegroups[i]) + '</div>';
+          }
+          buffer += '<div class="cdfield"><span class="cdtitle">Nodegroups:</span> ' + ngbuffer + '</div>';
+        }
         buffer += '<div class="cdfield"><span class="cdtitle">Created at:</span> ' + convertTimestamp(d.created) + '</div>';
         buffer += '<div class="cdfield"><span class="cdtitle">Timeout:</span> ' + d.timeout + '</div>';
-        buffer += '<div class="cdfield"><span class="cdtitle">TTL:</span> ' + d.ttl + ' min left</div>';
+        if (d.phase == 'Hibernated') {
+          buffer += '<div class="cdfield"><span class="cdtitle">TTL:</span> hibernated since ' + convertTimestamp(d.hibernated) + ', wake it up with <code class="inlinecode">eksp wake ' + d.id + '</code></div>';
+        } else if (d.warmpool && !d.teardownreason) {
+          buffer += '<div class="cdfield"><span class="cdtitle">TTL:</span> idle in warm pool ' + d.warmpool + ', claim it with <code class="inlinecode">eksp claim ' + d.warmpool + '</code></div>';
+        } else {
+          buffer += '<div class="cdfield"><span class="cdtitle">TTL:</span> ' + d.ttl + ' min left</div>';
+        }
+        if (d.schedule != null) {
+          var next = '';
+          if (d.nexttransition) {
+            next = (d.phase == 'Hibernated' ? ', wakes up ' : ', hibernates ') + convertTimestamp(d.nexttransition);
+          }
+          buffer += '<div class="cdfield"><span class="cdtitle">Schedule:</span> ' + d.schedule.days + ' ' + d.schedule.start + '-' + d.schedule.end + ' ' + (d.schedule.timezone || 'UTC') + next + '</div>';
+        }
+        if (d.labels != null) {
+          buffer += '<div class="cdfield"><span class="cdtitle">Labels:</span> ' + keyvalues(d.labels) + '</div>';
+        }
+        if (d.annotations != null) {
+          var abuffer = '';
+          var keys = Object.keys(d.annotations).sort();
+          for (let i = 0; i < keys.length; i++) {
+            abuffer += '<div class="moarfield"><span class="cdtitle">' + keys[i] + ':</span> ' + d.annotations[keys[i]] + '</div>';
+          }
+          buffer += '<div class="cdfield"><span class="cdtitle">Annotations:</span> ' + abuffer + '</div>';
+        }
         buffer += '<div class="cdfield"><span class="cdtitle">Owner:</span> <a href="mailto:' + d.owner + '">' + d.owner + '</a> notified on creation and 5 min before destruction</div>';
+        if (d.coowners != null) {
+          buffer += '<div class="cdfield"><span class="cdtitle">Co-owners:</span> ' + d.coowners.map(function (m) { return '<a href="mailto:' + m + '">' + m + '</a>'; }).join(', ') + ' notified like the owner</div>';
+        }
+        if (d.team) {
+          buffer += '<div class="cdfield"><span class="cdtitle">Team:</span> ' + d.team + ', whose members may manage the cluster, too</div>';
+        }
+        if (d.cost != null) {
+          var cbuffer = '';
+          cbuffer += '<div class="moarfield"><span class="cdtitle">Per hour:</span> ' + amount(d.cost.hourly, d.cost.currency) + '</div>';
+          cbuffer += '<div class="moarfield"><span class="cdtitle">So far:</span> ' + amount(d.cost.sofar, d.cost.currency) + '</div>';
+          cbuffer += '<div class="moarfield"><span class="cdtitle">Until expiry:</span> ' + amount(d.cost.projected, d.cost.currency) + '</div>';
+          if (d.cost.unpriced != null) {
+            cbuffer += '<div class="moarfield"><span class="cdtitle">Not covered:</span> ' + d.cost.unpriced.join(', ') + ', for lack of prices</div>';
+          }
+          buffer += '<div class="cdfield"><span class="cdtitle">Estimated cost:</span> ' + cbuffer + '</div>';
+        }
         var dbuffer = '';
         dbuffer += '<div class="moarfield"><span class="cdtitle">Status:</span> ' + d.details['status'] + '</div>';
         dbuffer += '<div class="moarfield"><span class="cdtitle">Endpoint:</span> <code class="inlinecode">' + d.details['endpoint'] + '</code></div>';
@@ -231,6 +291,43 @@ function clusterdetail(cID) {
   })
 }
 
+// nodegroupsummary renders size, instance type, and node
+// settings of a nodegroup in a single line
+function nodegroupsummary(ng) {
+  var min = ng.minsize || ng.desired;
+  var max = ng.maxsize || ng.desired;
+  var summary = ng.desired + ' x ' + (ng.instancetype || 'default');
+  if (min != ng.desired || max != ng.desired) {
+    summary += ' (min ' + min + ', max ' + max + ')';
+  }
+  if (ng.spot) {
+    summary += ', spot';
+  }
+  if (ng.volumesize) {
+    summary += ', ' + ng.volumesize + ' GiB volumes';
+  }
+  if (ng.labels) {
+    summary += ', labels ' + keyvalues(ng.labels);
+  }
+  if (ng.taints) {
+    summary += ', taints ' + keyvalues(ng.taints);
+  }
+  return summary;
+}
+
+// keyvalues renders an object as comma separated key=value pairs
+function keyvalues(o) {
+  return Object.keys(o).sort().map(function (k) { return k + '=' + o[k]; }).join(',');
+}
+
+// amount renders an amount in a currency, such as $1.23 or 1.23 EUR
+function amount(a, currency) {
+  if (currency == 'USD') {
+    return '$' + a.toFixed(2);
+  }
+  return a.toFixed(2) + ' ' + currency;
+}
+
 function clusterconf(cID) {
   var ep = '/configof?cluster='+cID;
   // var currentcontent = $('#' + cID + ' .cdetails').text();
@@ -244,13 +341,15 @@ function clusterconf(cID) {
   $.ajax({
     type: 'GET',
     url: cpURL + ep,
+    dataType: 'json',
     async: true,
     error: function (d) {
       console.info(d);
-      $('#status').html('<div>' + d.responseText  + '</div>');
+      $('#status').html('<div>' + apiError(d)  + '</div>');
     },
     success: function (d) {
       if (d != null) {
+        d = d.data.command;
         console.info(d);
         var buffer = '';
         buffer += '<div class="configinstructions">';
@@ -276,6 +375,30 @@ function clusterconf(cID) {
 
 
 
+// generates a random key identifying a create request
+function idempotencyKey() {
+  return Date.now().toString(36) + '-' + Math.random().toString(36).substring(2);
+}
+
+// renders the error envelope of a failed request, including field-level
+// validation errors, falling back to the raw response text for everything else
+function apiError(d) {
+  if (d.responseJSON == null || d.responseJSON.error == null) {
+    return d.responseText;
+  }
+  var apierr = d.responseJSON.error;
+  var buffer = apierr.message;
+  if (Array.isArray(apierr.details) && apierr.details.length > 0 && apierr.details[0].field != null) {
+    buffer += ':<ul>';
+    for (let i = 0; i < apierr.details.length; i++) {
+      var ferr = apierr.details[i];
+      buffer += '<li><code class="inlinecode">' + ferr.field + '</code> ' + ferr.message + '</li>';
+    }
+    buffer += '</ul>';
+  }
+  return buffer;
+}
+
 // as per https://gist.github.com/kmaida/6045266
 function convertTimestamp(timestamp) {
   var d = new Date(timestamp * 1000),	// Convert the passed timestamp to milliseconds
@@ -9,14 +9,16 @@ import (
 	"os"
 	"strconv"
 	"time"
+
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
 )
 
 // ListCluster invokes the /status endpoint in the EKSphemeral control
 // plane, returning the result to the caller
 func ListCluster(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		w.Write([]byte(`Allow: ` + "GET"))
+		w.Header().Set("Allow", "GET")
+		errorResponse(w, http.StatusMethodNotAllowed, "method_not_allowed", "Only GET is allowed", nil)
 		return
 	}
 	q := r.URL.Query()
@@ -25,122 +27,114 @@ func ListCluster(w http.ResponseWriter, r *http.Request) {
 	if targetcluster != "*" { // cluster details
 		cs, err := lookup(targetcluster) // try local cache
 		if err == nil {
-			csjson, err := json.Marshal(cs)
-			if err != nil {
-				perr("Can't marshal cluster spec data", err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				jsonResponse(w, http.StatusInternalServerError, "Can't marshal cluster spec data")
-				return
-			}
 			pinfo("Serving from cache")
-			jsonResponse(w, http.StatusOK, string(csjson))
+			dataResponse(w, http.StatusOK, cs)
 			return
 		}
 	}
 	// either list all clusters or not cached yet
-	_, _, _, _, ekspcp := getDefaults()
+	_, ekspcp := getDefaults()
 	pinfo(fmt.Sprintf("Using %v as the control plane endpoint", ekspcp))
 	c := &http.Client{
 		Timeout: time.Second * 30,
 	}
-	pres, err := c.Get(ekspcp + "/status/" + targetcluster)
+	pres, err := callControlPlane(c, http.MethodGet, ekspcp+"/status/"+targetcluster, nil)
 	if err != nil {
 		perr("Can't GET control plane for cluster status", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		jsonResponse(w, http.StatusInternalServerError, "Can't GET control plane for cluster status")
+		errorResponse(w, http.StatusInternalServerError, "internal", "Can't GET control plane for cluster status", nil)
 		return
 	}
 	defer pres.Body.Close()
 	body, err := ioutil.ReadAll(pres.Body)
 	if err != nil {
 		perr("Can't read control plane response for cluster status", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		jsonResponse(w, http.StatusInternalServerError, "Can't read control plane response for cluster status")
+		errorResponse(w, http.StatusInternalServerError, "internal", "Can't read control plane response for cluster status", nil)
 		return
 	}
 	pinfo(fmt.Sprintf("Status for cluster: %v", string(body)))
-	if targetcluster != "*" {
+	if targetcluster != "*" && pres.StatusCode == http.StatusOK {
 		err = updateCache(string(body))
 		if err != nil {
 			perr("Can't update local cluster spec cache", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			jsonResponse(w, http.StatusInternalServerError, "Can't update local cluster spec cache")
+			errorResponse(w, http.StatusInternalServerError, "internal", "Can't update local cluster spec cache", nil)
 			return
 		}
 	}
-	jsonResponse(w, http.StatusOK, string(body))
+	jsonResponse(w, pres.StatusCode, string(body))
 }
 
-// CreateCluster sanitizes user input, provisions the EKS cluster using the
-// Fargate CLI, and invokes the /create endpoint in the EKSphemeral control
-// plane, returning the result to the caller
+// CreateCluster sanitizes user input and invokes the /create endpoint in the
+// EKSphemeral control plane, which provisions the EKS cluster, returning the
+// result to the caller
 func CreateCluster(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		w.Write([]byte(`Allow: ` + "POST"))
+		w.Header().Set("Allow", "POST")
+		errorResponse(w, http.StatusMethodNotAllowed, "method_not_allowed", "Only POST is allowed", nil)
 		return
 	}
 	decoder := json.NewDecoder(r.Body)
-	cs := ClusterSpec{}
+	cs := eksp.ClusterSpec{}
 	err := decoder.Decode(&cs)
 	if err != nil {
 		perr("Can't parse cluster spec from UI", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		jsonResponse(w, http.StatusInternalServerError, "Can't parse cluster spec from UI")
+		errorResponse(w, http.StatusBadRequest, "invalid_request", "Can't parse cluster spec from UI", nil)
 		return
 	}
 	pinfo(fmt.Sprintf("From the web UI I got the following values for cluster create: %+v", cs))
+	if ferrs := eksp.ValidateClusterSpec(cs); len(ferrs) > 0 {
+		perr("Invalid cluster spec from UI", fmt.Errorf("%+v", ferrs))
+		errorResponse(w, http.StatusBadRequest, "validation_failed", "The cluster spec is invalid", ferrs)
+		return
+	}
+
+	// if the UI submitted this request before, don't provision again:
+	if cs.IdempotencyKey != "" {
+		if body, ok := createcache[cs.IdempotencyKey]; ok {
+			pinfo("Repeated create request, serving from cache")
+			jsonResponse(w, http.StatusOK, body)
+			return
+		}
+	}
 
-	// provision cluster using Fargate CLI:
-	awsAccessKeyID, awsSecretAccessKey, awsRegion, defaultSG, ekspcp := getDefaults()
+	_, ekspcp := getDefaults()
 	pinfo(fmt.Sprintf("Using %v as the control plane endpoint", ekspcp))
-	shellout("sh", "-c", "fargate task run eksctl"+
-		" --image quay.io/mhausenblas/eksctl:base"+
-		" --region "+awsRegion+
-		" --env AWS_ACCESS_KEY_ID="+awsAccessKeyID+
-		" --env AWS_SECRET_ACCESS_KEY="+awsSecretAccessKey+
-		" --env AWS_DEFAULT_REGION="+awsRegion+
-		" --env CLUSTER_NAME="+cs.Name+
-		" --env "+fmt.Sprintf("NUM_WORKERS=%d", cs.NumWorkers)+
-		" --env KUBERNETES_VERSION="+cs.KubeVersion+
-		" --security-group-id "+defaultSG)
+	if clusterNameTaken(ekspcp, cs.Name) {
+		perr("Can't create cluster with a name that is already taken", nil)
+		errorResponse(w, http.StatusConflict, "conflict", "The cluster spec conflicts with an active cluster",
+			[]eksp.FieldError{{Field: "name", Message: "is already used by an active cluster"}})
+		return
+	}
 
-	//create cluster spec in control plane:
+	// create cluster spec in control plane, which provisions the cluster:
 	c := &http.Client{
 		Timeout: time.Second * 30,
 	}
 	req, err := json.Marshal(cs)
 	if err != nil {
 		perr("Can't marshal cluster spec data", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		jsonResponse(w, http.StatusInternalServerError, "Can't marshal cluster spec data")
+		errorResponse(w, http.StatusInternalServerError, "internal", "Can't marshal cluster spec data", nil)
 		return
 	}
-	pres, err := c.Post(ekspcp+"/create/", "application/json", bytes.NewBuffer(req))
+	pres, err := callControlPlane(c, http.MethodPost, ekspcp+"/create/", bytes.NewBuffer(req))
 	if err != nil {
 		perr("Can't POST to control plane for cluster create", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		jsonResponse(w, http.StatusInternalServerError, "Can't POST to control plane for cluster create")
+		errorResponse(w, http.StatusInternalServerError, "internal", "Can't POST to control plane for cluster create", nil)
 		return
 	}
 	defer pres.Body.Close()
 	body, err := ioutil.ReadAll(pres.Body)
 	if err != nil {
 		perr("Can't read control plane response for cluster create", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		jsonResponse(w, http.StatusInternalServerError, "Can't read control plane response for cluster create")
+		errorResponse(w, http.StatusInternalServerError, "internal", "Can't read control plane response for cluster create", nil)
 		return
 	}
-	// make sure to compensate for provision time, so prolong immediately for 15min:
-	empty := ""
-	_, err = c.Post(ekspcp+"/prolong/"+cs.ID+"/15", "application/json", bytes.NewBuffer([]byte(empty)))
-	if err != nil {
-		perr("Can't POST to control plane for prolonging cluster", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		jsonResponse(w, http.StatusInternalServerError, "Can't POST to control plane for prolonging cluster")
+	if pres.StatusCode != http.StatusOK {
+		jsonResponse(w, pres.StatusCode, string(body))
 		return
 	}
-	defer pres.Body.Close()
+	if cs.IdempotencyKey != "" {
+		createcache[cs.IdempotencyKey] = string(body)
+	}
 	jsonResponse(w, http.StatusOK, string(body))
 }
 
@@ -148,8 +142,8 @@ func CreateCluster(w http.ResponseWriter, r *http.Request) {
 // in the EKSphemeral control plane, returning the result to the caller
 func ProlongCluster(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		w.Write([]byte(`Allow: ` + "POST"))
+		w.Header().Set("Allow", "POST")
+		errorResponse(w, http.StatusMethodNotAllowed, "method_not_allowed", "Only POST is allowed", nil)
 		return
 	}
 	type ClusterProlong struct {
@@ -161,8 +155,7 @@ func ProlongCluster(w http.ResponseWriter, r *http.Request) {
 	err := decoder.Decode(&cp)
 	if err != nil {
 		perr("Can't parse cluster prolong values from UI", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		jsonResponse(w, http.StatusInternalServerError, "Can't parse cluster prolong values from UI")
+		errorResponse(w, http.StatusBadRequest, "invalid_request", "Can't parse cluster prolong values from UI", nil)
 		return
 	}
 	pinfo(fmt.Sprintf("From the web UI I got the following values for proloning the cluster lifetime: %+v", cp))
@@ -170,21 +163,19 @@ func ProlongCluster(w http.ResponseWriter, r *http.Request) {
 	c := &http.Client{
 		Timeout: time.Second * 30,
 	}
-	_, _, _, _, ekspcp := getDefaults()
+	_, ekspcp := getDefaults()
 	pinfo(fmt.Sprintf("Using %v as the control plane endpoint", ekspcp))
-	pres, err := c.Post(ekspcp+"/prolong/"+cp.ID+"/"+strconv.Itoa(cp.ProlongTime), "application/json", r.Body)
+	pres, err := callControlPlane(c, http.MethodPost, ekspcp+"/prolong/"+cp.ID+"/"+strconv.Itoa(cp.ProlongTime), r.Body)
 	if err != nil {
 		perr("Can't POST to control plane for prolonging cluster", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		jsonResponse(w, http.StatusInternalServerError, "Can't POST to control plane for prolonging cluster")
+		errorResponse(w, http.StatusInternalServerError, "internal", "Can't POST to control plane for prolonging cluster", nil)
 		return
 	}
 	defer pres.Body.Close()
 	body, err := ioutil.ReadAll(pres.Body)
 	if err != nil {
 		perr("Can't read control plane response for prolonging cluster", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		jsonResponse(w, http.StatusInternalServerError, "Can't read control plane response for prolonging cluster")
+		errorResponse(w, http.StatusInternalServerError, "internal", "Can't read control plane response for prolonging cluster", nil)
 		return
 	}
 	pinfo(fmt.Sprintf("Result proloning the cluster lifetime: %v", string(body)))
@@ -192,14 +183,14 @@ func ProlongCluster(w http.ResponseWriter, r *http.Request) {
 	invalidateCacheEntry(cp.ID)
 	pinfo("Invalidated cache entry")
 
-	plainResponse(w, http.StatusOK, string(body))
+	jsonResponse(w, pres.StatusCode, string(body))
 }
 
 // GetClusterConfig returns the cluster config for kubectl
 func GetClusterConfig(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		w.Write([]byte(`Allow: ` + "GET"))
+		w.Header().Set("Allow", "GET")
+		errorResponse(w, http.StatusMethodNotAllowed, "method_not_allowed", "Only GET is allowed", nil)
 		return
 	}
 	q := r.URL.Query()
@@ -208,12 +199,15 @@ func GetClusterConfig(w http.ResponseWriter, r *http.Request) {
 	cs, err := lookup(cID)
 	if err != nil {
 		perr("Can't find cluster spec in cache", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		jsonResponse(w, http.StatusInternalServerError, "Can't find cluster spec in cache")
+		errorResponse(w, http.StatusNotFound, "not_found", "Can't find cluster spec in cache, look up the cluster details first", nil)
 		return
 	}
 	pinfo(fmt.Sprintf("Looking up config for cluster %v in region %v", cs.Name, region))
 	cmd := "aws eks update-kubeconfig --region " + region + " --name " + cs.Name //+ " --dry-run"
+	// access to clusters the control plane provisions is via a dedicated role:
+	if role := cs.ClusterDetails["accessrole"]; role != "" {
+		cmd += " --role-arn " + role
+	}
 	// config := bshellout("sh", "-c", cmd)
-	plainResponse(w, http.StatusOK, string(cmd))
+	dataResponse(w, http.StatusOK, map[string]string{"command": cmd})
 }
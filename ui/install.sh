@@ -8,21 +8,11 @@ set -o pipefail
 ###############################################################################
 ### GLOBALS
 
-fargateversion=0.3.0
 ekspversion=v0.3.0
 
 ###############################################################################
 ### HELPER FUNCTIONS
 
-function installFargate() {
-  srcURL="https://github.com/jpignata/fargate/releases/download/v$fargateversion/fargate-$fargateversion-linux-amd64.zip"
-  echo "Attempting to download $srcURL"
-  curl -L $srcURL -o fargate$fargateversion.zip
-  unzip fargate$fargateversion.zip
-  mv ./fargate /usr/local/bin
-  rm fargate$fargateversion.zip
-}
-
 function installEKSphemeralCLI() {
   srcURL="https://github.com/mhausenblas/eksphemeral/releases/download/$ekspversion/eksp-linux"
   echo "Attempting to download $srcURL"
@@ -40,8 +30,6 @@ then
   exit 1
 fi
 
-installFargate
-
 mkdir -p $EKSPHEMERAL_HOME
 
 git clone https://github.com/mhausenblas/eksphemeral.git $EKSPHEMERAL_HOME
@@ -3,15 +3,15 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws/external"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
 )
 
 // jsonResponse wraps a message with a JSON header and writes it out
@@ -21,26 +21,34 @@ func jsonResponse(w http.ResponseWriter, code int, message string) {
 	fmt.Fprint(w, message)
 }
 
-// plainResponse wraps a message with a text/plain header and writes it out
-func plainResponse(w http.ResponseWriter, code int, message string) {
-	w.Header().Set("Content-Type", "text/plain")
-	w.WriteHeader(code)
-	fmt.Fprint(w, message)
+// dataResponse wraps data in an envelope and writes it out as JSON
+func dataResponse(w http.ResponseWriter, code int, data interface{}) {
+	envelopeResponse(w, code, eksp.Envelope{Data: data})
 }
 
-// getDefaults returns creds and default configs
-func getDefaults() (awsAccessKeyID, awsSecretAccessKey, awsRegion, defaultSG, ekspcp string) {
-	awsAccessKeyID, ok := os.LookupEnv("AWS_ACCESS_KEY_ID")
-	if !ok {
-		perr("Please set the AWS_ACCESS_KEY_ID environment variable!", nil)
-		os.Exit(1)
-	}
-	awsSecretAccessKey, ok = os.LookupEnv("AWS_SECRET_ACCESS_KEY")
-	if !ok {
-		perr("Please set the AWS_SECRET_ACCESS_KEY environment variable!", nil)
-		os.Exit(1)
+// errorResponse wraps an error in an envelope and writes it out as JSON
+func errorResponse(w http.ResponseWriter, code int, errcode, message string, details interface{}) {
+	envelopeResponse(w, code, eksp.Envelope{Error: &eksp.APIError{
+		Code:    errcode,
+		Message: message,
+		Details: details,
+	}})
+}
+
+// envelopeResponse marshals the envelope and writes it out as JSON
+func envelopeResponse(w http.ResponseWriter, code int, env eksp.Envelope) {
+	envjson, err := json.Marshal(env)
+	if err != nil {
+		perr("Can't marshal response", err)
+		jsonResponse(w, http.StatusInternalServerError, `{"error":{"code":"internal","message":"can't marshal response"}}`)
+		return
 	}
-	awsRegion, ok = os.LookupEnv("AWS_DEFAULT_REGION")
+	jsonResponse(w, code, string(envjson))
+}
+
+// getDefaults returns the default configs
+func getDefaults() (awsRegion, ekspcp string) {
+	awsRegion, ok := os.LookupEnv("AWS_DEFAULT_REGION")
 	if !ok {
 		perr("Please set the AWS_DEFAULT_REGION environment variable!", nil)
 		os.Exit(1)
@@ -50,49 +58,48 @@ func getDefaults() (awsAccessKeyID, awsSecretAccessKey, awsRegion, defaultSG, ek
 		perr("Please set the EKSPHEMERAL_URL environment variable, pointing to the EKSphemeral control plane endpoint!", nil)
 		os.Exit(1)
 	}
-	defaultSG, err := getDefaultSecurityGroup()
-	if err != nil {
-		perr("Can't start up since I'm unable to determine the default security group: %v", err)
-		os.Exit(1)
-	}
-	// pinfo(fmt.Sprintf("Using %v as the default security group", defaultSG))
 	return
 }
 
-// getDefaultSecurityGroup returns the default security group
-func getDefaultSecurityGroup() (string, error) {
-	cfg, err := external.LoadDefaultAWSConfig()
+// callControlPlane sends a request to the control plane, authenticated
+// with the key in EKSPHEMERAL_API_KEY or the ID token in
+// EKSPHEMERAL_TOKEN, if any
+func callControlPlane(c *http.Client, method, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	svc := ec2.New(cfg)
-	vpcreq := svc.DescribeVpcsRequest(&ec2.DescribeVpcsInput{})
-	vpcres, err := vpcreq.Send(context.TODO())
-	if err != nil {
-		return "", err
+	req.Header.Set("Content-Type", "application/json")
+	if key, ok := os.LookupEnv("EKSPHEMERAL_API_KEY"); ok {
+		req.Header.Set("X-EKSphemeral-Key", key)
 	}
-	defaultVPC := ""
-	for _, vpc := range vpcres.Vpcs {
-		if *vpc.IsDefault {
-			defaultVPC = *vpc.VpcId
-			break
-		}
+	if token, ok := os.LookupEnv("EKSPHEMERAL_TOKEN"); ok && token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
+	return c.Do(req)
+}
 
-	sgreq := svc.DescribeSecurityGroupsRequest(&ec2.DescribeSecurityGroupsInput{})
-	sgres, err := sgreq.Send(context.TODO())
+// clusterNameTaken returns true if the control plane
+// knows an active cluster with the given name
+func clusterNameTaken(ekspcp, clustername string) bool {
+	c := &http.Client{
+		Timeout: time.Second * 30,
+	}
+	pres, err := callControlPlane(c, http.MethodGet, ekspcp+"/status/"+clustername, nil)
 	if err != nil {
-		return "", err
+		perr("Can't GET control plane for cluster status", err)
+		return false
 	}
-	defaultSG := ""
-	for _, sg := range sgres.SecurityGroups {
-		// fmt.Printf("%v\n", *sg.GroupId)
-		if *sg.VpcId == defaultVPC {
-			defaultSG = *sg.GroupId
-			break
-		}
+	defer pres.Body.Close()
+	if pres.StatusCode != http.StatusOK {
+		return false
+	}
+	cs := eksp.ClusterSpec{}
+	err = json.NewDecoder(pres.Body).Decode(&eksp.Envelope{Data: &cs})
+	if err != nil {
+		return false
 	}
-	return defaultSG, nil
+	return cs.Name == clustername
 }
 
 // shellout shells out to execute a command with a variable number
@@ -5,44 +5,20 @@ import (
 	"log"
 	"net/http"
 	"os"
-)
 
-// ClusterSpec represents the parameters for eksctl,
-// as cluster metadata including owner and how long the cluster
-// still has to live.
-type ClusterSpec struct {
-	// ID is a unique identifier for the cluster
-	ID string `json:"id"`
-	// Name specifies the cluster name
-	Name string `json:"name"`
-	// NumWorkers specifies the number of worker nodes, defaults to 1
-	NumWorkers int `json:"numworkers"`
-	// KubeVersion  specifies the Kubernetes version to use, defaults to `1.12`
-	KubeVersion string `json:"kubeversion"`
-	// Timeout specifies the timeout in minutes, after which the cluster
-	// is destroyed, defaults to 10
-	Timeout int `json:"timeout"`
-	// Timeout specifies the cluster time to live in minutes.
-	// In other words: the remaining time the cluster has before it is destroyed
-	TTL int `json:"ttl"`
-	// Owner specifies the email address of the owner (will be notified when cluster is created and 5 min before destruction)
-	Owner string `json:"owner"`
-	// CreationTime is the UTC timestamp of when the cluster was created
-	// which equals the point in time of the creation of the respective
-	// JSON representation of the cluster spec as an object in the metadata
-	// bucket
-	CreationTime string `json:"created"`
-	// ClusterDetails is only valid for lookup of individual clusters,
-	// that is, when user does, for example, a eksp l CLUSTERID. It
-	// holds info such as cluster status and config
-	ClusterDetails map[string]string `json:"details"`
-}
+	"github.com/mhausenblas/eksphemeral/internal/eksp"
+)
 
 var ekspcp string
-var cscache map[string]ClusterSpec
+var cscache map[string]eksp.ClusterSpec
+
+// createcache holds the control plane responses for create
+// requests, keyed by the idempotency key of the request
+var createcache map[string]string
 
 func main() {
-	cscache = make(map[string]ClusterSpec)
+	cscache = make(map[string]eksp.ClusterSpec)
+	createcache = make(map[string]string)
 	http.Handle("/", http.FileServer(http.Dir("./frontend")))
 	http.HandleFunc("/status", ListCluster)
 	http.HandleFunc("/create", CreateCluster)